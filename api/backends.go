@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"api/internal/backoff"
+	"api/internal/pg_gateway"
+	"api/internal/redis_gateway"
+	"api/internal/users"
+)
+
+const (
+	connectorMinBackoff = time.Second
+	connectorMaxBackoff = 30 * time.Second
+)
+
+// backends holds the clients the handlers depend on. A client stays nil
+// until its server has been reached, which may happen after the HTTP server
+// is up; handlers read them through the accessors and answer 503 meanwhile.
+type backends struct {
+	mu           sync.RWMutex
+	redis        *redis_gateway.RedisClient
+	pg           *pg_gateway.PGClient
+	db           *sql.DB
+	pgListener   *pg_gateway.Listener
+	usersManager *users.UsersManager
+	usersStarted bool
+}
+
+func (b *backends) redisClient() *redis_gateway.RedisClient {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.redis
+}
+
+// users returns nil until both Redis and PostgreSQL are connected and the
+// cache sync has been started.
+func (b *backends) users() *users.UsersManager {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.usersManager
+}
+
+func (b *backends) setRedis(client *redis_gateway.RedisClient) {
+	b.mu.Lock()
+	b.redis = client
+	b.mu.Unlock()
+}
+
+// setPG also opens the database/sql handle UsersManager uses. It borrows
+// connections from client's pool, so it keeps no idle ones of its own.
+func (b *backends) setPG(client *pg_gateway.PGClient) {
+	log.Printf("[POSTGRES] Opening database/sql handle with driver '%s' on the client pool...", pg_gateway.DriverName)
+	db := sql.OpenDB(client.Connector())
+	db.SetMaxIdleConns(0)
+
+	b.mu.Lock()
+	b.pg = client
+	b.db = db
+	b.mu.Unlock()
+}
+
+// startUsers creates the UsersManager and starts the cache sync once both
+// clients are set. It is called after each client comes online; only the
+// call that finds both does anything.
+func (b *backends) startUsers() {
+	b.mu.Lock()
+	if b.redis == nil || b.pg == nil || b.usersStarted {
+		b.mu.Unlock()
+		return
+	}
+	b.usersStarted = true
+	log.Println("[INIT] Creating UsersManager...")
+	manager := users.NewUsersManager(b.redis, b.pg, b.db, metricsRegistry)
+	b.pgListener = b.pg.NewListener()
+	listener := b.pgListener
+	b.mu.Unlock()
+	log.Println("[INIT] UsersManager created successfully")
+
+	log.Println("[INIT] Migrating cached users to hashes...")
+	if _, err := manager.MigrateCache(context.Background()); err != nil {
+		log.Printf("[INIT] WARNING: Could not migrate cached users: %v", err)
+	}
+
+	log.Println("[INIT] Starting users cache sync from PostgreSQL notifications...")
+	if err := manager.SyncCache(context.Background(), listener); err != nil {
+		log.Printf("[INIT] WARNING: Could not start users cache sync: %v", err)
+	} else {
+		log.Println("[INIT] Users cache sync started")
+	}
+
+	b.mu.Lock()
+	b.usersManager = manager
+	b.mu.Unlock()
+}
+
+// unavailable names the missing backends of a request that needs Redis,
+// PostgreSQL or both, or returns "" if they are all set.
+func (b *backends) unavailable(needRedis, needPG bool) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	missingRedis := needRedis && b.redis == nil
+	missingPG := needPG && b.pg == nil
+	switch {
+	case missingRedis && missingPG:
+		return "Redis and PostgreSQL are unavailable"
+	case missingRedis:
+		return "Redis is unavailable"
+	case missingPG:
+		return "PostgreSQL is unavailable"
+	case needRedis && needPG && b.usersManager == nil:
+		return "Users service is starting"
+	}
+	return ""
+}
+
+// status reports "up", "reconnecting" (the client lost its server after
+// startup) or "unavailable" (it never reached it) for each backend.
+func (b *backends) status() map[string]string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	status := map[string]string{"redis": "unavailable", "postgres": "unavailable"}
+	if b.redis != nil {
+		status["redis"] = "up"
+		if !b.redis.Connected() {
+			status["redis"] = "reconnecting"
+		}
+	}
+	if b.pg != nil {
+		status["postgres"] = "up"
+		if !b.pg.Connected() {
+			status["postgres"] = "reconnecting"
+		}
+	}
+	return status
+}
+
+func (b *backends) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pgListener != nil {
+		b.pgListener.Close()
+	}
+	if b.redis != nil {
+		b.redis.Close()
+	}
+	if b.db != nil {
+		b.db.Close()
+	}
+	if b.pg != nil {
+		b.pg.Close()
+	}
+}
+
+// connectInBackground calls connect until it succeeds, waiting a jittered,
+// growing delay between attempts. main runs it for every backend that was
+// down at startup.
+func connectInBackground(name string, connect func() error) {
+	go func() {
+		bo := backoff.Backoff{Min: connectorMinBackoff, Max: connectorMaxBackoff, Jitter: 0.5}
+		for {
+			delay := bo.Next()
+			log.Printf("[CONNECTOR] %s unavailable, retrying in %v (attempt %d)...", name, delay, bo.Attempt())
+			time.Sleep(delay)
+			if err := connect(); err != nil {
+				log.Printf("[CONNECTOR] WARNING: %s attempt %d failed: %v", name, bo.Attempt(), err)
+				continue
+			}
+			log.Printf("[CONNECTOR] %s online after %d attempts", name, bo.Attempt())
+			return
+		}
+	}()
+}
@@ -0,0 +1,85 @@
+// Package backoff computes jittered exponential delays for retry and
+// reconnect loops.
+package backoff
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Backoff hands out delays that double (or grow by Factor) after every
+// attempt, capped at Max. Each delay is randomized downwards by up to Jitter
+// of its value so that many clients losing the same server do not retry in
+// lockstep. A Backoff is not safe for concurrent use; give every loop its
+// own.
+//
+//	b := backoff.Backoff{Min: 100 * time.Millisecond, Max: 10 * time.Second, Jitter: 0.5}
+//	for {
+//		if err := try(); err == nil {
+//			break
+//		}
+//		if err := backoff.Wait(ctx, b.Next()); err != nil {
+//			return err
+//		}
+//	}
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+	// Factor is the growth per attempt; values <= 1 mean 2.
+	Factor float64
+	// Jitter is the fraction of each delay that is random, from 0 (none) to
+	// 1 (anywhere between zero and the full delay).
+	Jitter float64
+
+	attempt int
+}
+
+// Next returns the delay before the next attempt and counts the attempt.
+func (b *Backoff) Next() time.Duration {
+	factor := b.Factor
+	if factor <= 1 {
+		factor = 2
+	}
+
+	delay := float64(b.Min)
+	for i := 0; i < b.attempt && (b.Max <= 0 || delay < float64(b.Max)); i++ {
+		delay *= factor
+	}
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	b.attempt++
+
+	jitter := b.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		delay -= delay * jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// Attempt is the number of delays handed out since the last Reset.
+func (b *Backoff) Attempt() int {
+	return b.attempt
+}
+
+// Reset starts over from Min, typically after a successful attempt.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// Wait sleeps for d or until ctx is done, whichever comes first, and returns
+// ctx.Err() in the latter case.
+func Wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -2,6 +2,7 @@ package func1
 
 import (
 	"api/internal/redis_gateway"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"log"
@@ -13,9 +14,19 @@ const (
 	KeyLength     = 4096
 	ValueLength   = 10000
 	BatchSize     = 100
+	// KeyTTL expires the test keys so repeated runs do not pile up.
+	KeyTTL        = time.Hour
+)
+
+// Load modes. Sequential issues one SET round trip per key; pipelined sends
+// each batch of BatchSize SETs in a single write.
+const (
+	ModeSequential = "sequential"
+	ModePipelined  = "pipelined"
 )
 
 type Func1Stats struct {
+	Mode            string
 	TotalKeys       int
 	SuccessfulKeys  int
 	FailedKeys      int
@@ -36,11 +47,42 @@ func generateRandomString(length int) string {
 	return string(b)
 }
 
-func Func1Run(client *redis_gateway.RedisClient) (*Func1Stats, error) {
+func ValidMode(mode string) bool {
+	return mode == ModeSequential || mode == ModePipelined
+}
+
+func generateKeyValue(i int) (string, string) {
+	keyPrefix := fmt.Sprintf("func_key_%d_", i)
+	remainingLength := KeyLength - len(keyPrefix)
+	if remainingLength < 0 {
+		remainingLength = 0
+	}
+	key := keyPrefix + generateRandomString(remainingLength)
+
+	// Ensure exact length
+	if len(key) > KeyLength {
+		key = key[:KeyLength]
+	} else if len(key) < KeyLength {
+		key = key + generateRandomString(KeyLength-len(key))
+	}
+
+	value := generateRandomString(ValueLength)
+	return key, value
+}
+
+func Func1Run(client *redis_gateway.RedisClient, mode string) (*Func1Stats, error) {
+	if mode == "" {
+		mode = ModeSequential
+	}
+	if !ValidMode(mode) {
+		return nil, fmt.Errorf("unknown func1 mode %q", mode)
+	}
+
 	log.Println("[FUNC-1] ========================================")
 	log.Println("[FUNC-1] STARTING Func 1")
 	log.Println("[FUNC-1] ========================================")
 	log.Printf("[FUNC-1] Configuration:")
+	log.Printf("[FUNC-1]   - Mode: %s", mode)
 	log.Printf("[FUNC-1]   - Total Keys: %d", KeyCount)
 	log.Printf("[FUNC-1]   - Key Length: %d characters", KeyLength)
 	log.Printf("[FUNC-1]   - Value Length: %d characters", ValueLength)
@@ -49,6 +91,7 @@ func Func1Run(client *redis_gateway.RedisClient) (*Func1Stats, error) {
 	log.Println("[FUNC-1] ========================================")
 
 	stats := &Func1Stats{
+		Mode:      mode,
 		TotalKeys: KeyCount,
 		Keys:      make([]string, 0, KeyCount),
 		Values:    make([]string, 0, KeyCount),
@@ -59,6 +102,42 @@ func Func1Run(client *redis_gateway.RedisClient) (*Func1Stats, error) {
 	log.Printf("[FUNC-1] Initializing keys array with capacity %d", KeyCount)
 	log.Printf("[FUNC-1] Initializing values array with capacity %d", KeyCount)
 
+	if mode == ModePipelined {
+		runPipelined(client, stats, startTime)
+	} else {
+		runSequential(client, stats, startTime)
+	}
+
+	duration := time.Since(startTime)
+	stats.DurationSeconds = duration.Seconds()
+	stats.KeysPerSecond = float64(stats.SuccessfulKeys) / stats.DurationSeconds
+
+	log.Println("[FUNC-1] ========================================")
+	log.Println("[FUNC-1] Func 1 COMPLETED")
+	log.Println("[FUNC-1] ========================================")
+	log.Printf("[FUNC-1] Results:")
+	log.Printf("[FUNC-1]   - Total Keys: %d", stats.TotalKeys)
+	log.Printf("[FUNC-1]   - Successful: %d", stats.SuccessfulKeys)
+	log.Printf("[FUNC-1]   - Failed: %d", stats.FailedKeys)
+	log.Printf("[FUNC-1]   - Keys Stored in Array: %d", len(stats.Keys))
+	log.Printf("[FUNC-1]   - Values Stored in Array: %d", len(stats.Values))
+	log.Printf("[FUNC-1]   - Total Data: %.2f MB", float64(stats.TotalBytes)/1024/1024)
+	log.Printf("[FUNC-1]   - Duration: %.2f seconds", stats.DurationSeconds)
+	log.Printf("[FUNC-1]   - Throughput: %.2f keys/second", stats.KeysPerSecond)
+	log.Printf("[FUNC-1]   - Data Rate: %.2f MB/second", float64(stats.TotalBytes)/1024/1024/stats.DurationSeconds)
+	log.Printf("[FUNC-1]   - Keys Array Memory: %.2f MB", float64(len(stats.Keys)*KeyLength)/1024/1024)
+	log.Printf("[FUNC-1]   - Values Array Memory: %.2f MB", float64(len(stats.Values)*ValueLength)/1024/1024)
+	log.Printf("[FUNC-1]   - Total Array Memory: %.2f MB", float64(len(stats.Keys)*KeyLength+len(stats.Values)*ValueLength)/1024/1024)
+	log.Println("[FUNC-1] ========================================")
+
+	if stats.FailedKeys > 0 {
+		return stats, fmt.Errorf("Func 1 completed with %d failures", stats.FailedKeys)
+	}
+
+	return stats, nil
+}
+
+func runSequential(client *redis_gateway.RedisClient, stats *Func1Stats, startTime time.Time) {
 	for i := 0; i < KeyCount; i++ {
 		batchNum := i / BatchSize
 		keyNum := i % BatchSize
@@ -68,26 +147,12 @@ func Func1Run(client *redis_gateway.RedisClient) (*Func1Stats, error) {
 				batchNum+1, (KeyCount+BatchSize-1)/BatchSize, i, min(i+BatchSize-1, KeyCount-1))
 		}
 
-		keyPrefix := fmt.Sprintf("func_key_%d_", i)
-		remainingLength := KeyLength - len(keyPrefix)
-		if remainingLength < 0 {
-			remainingLength = 0
-		}
-		key := keyPrefix + generateRandomString(remainingLength)
-		
-		// Ensure exact length
-		if len(key) > KeyLength {
-			key = key[:KeyLength]
-		} else if len(key) < KeyLength {
-			key = key + generateRandomString(KeyLength-len(key))
-		}
-		
-		value := generateRandomString(ValueLength)
+		key, value := generateKeyValue(i)
 
 		log.Printf("[FUNC-1] Setting key #%d (key_len=%d, val_len=%d)", i+1, len(key), len(value))
 		
 		setStart := time.Now()
-		err := client.Set(key, value)
+		_, err := client.SetWithOptions(context.Background(), key, value, redis_gateway.SetOptions{TTL: KeyTTL})
 		setDuration := time.Since(setStart)
 
 		if err != nil {
@@ -102,43 +167,68 @@ func Func1Run(client *redis_gateway.RedisClient) (*Func1Stats, error) {
 		}
 
 		if (i+1)%100 == 0 {
-			elapsed := time.Since(startTime).Seconds()
-			rate := float64(i+1) / elapsed
-			remaining := KeyCount - (i + 1)
-			eta := time.Duration(float64(remaining)/rate) * time.Second
-			
-			log.Printf("[FUNC-1] Progress: %d/%d keys (%.1f%%) | Rate: %.1f keys/sec | ETA: %v",
-				i+1, KeyCount, float64(i+1)/float64(KeyCount)*100, rate, eta)
+			logProgress(i+1, startTime)
 		}
 	}
+}
 
-	duration := time.Since(startTime)
-	stats.DurationSeconds = duration.Seconds()
-	stats.KeysPerSecond = float64(stats.SuccessfulKeys) / stats.DurationSeconds
+// runPipelined queues BatchSize SETs at a time and sends each batch with a
+// single pipeline flush, so a batch costs one round trip instead of
+// BatchSize of them.
+func runPipelined(client *redis_gateway.RedisClient, stats *Func1Stats, startTime time.Time) {
+	pipe := client.Pipeline()
+	totalBatches := (KeyCount + BatchSize - 1) / BatchSize
+
+	for start := 0; start < KeyCount; start += BatchSize {
+		end := min(start+BatchSize, KeyCount)
+		log.Printf("[FUNC-1] Processing batch %d/%d (keys %d-%d)",
+			start/BatchSize+1, totalBatches, start, end-1)
+
+		keys := make([]string, 0, end-start)
+		values := make([]string, 0, end-start)
+		for i := start; i < end; i++ {
+			key, value := generateKeyValue(i)
+			keys = append(keys, key)
+			values = append(values, value)
+			pipe.SetWithOptions(key, value, redis_gateway.SetOptions{TTL: KeyTTL})
+		}
 
-	log.Println("[FUNC-1] ========================================")
-	log.Println("[FUNC-1] Func 1 COMPLETED")
-	log.Println("[FUNC-1] ========================================")
-	log.Printf("[FUNC-1] Results:")
-	log.Printf("[FUNC-1]   - Total Keys: %d", stats.TotalKeys)
-	log.Printf("[FUNC-1]   - Successful: %d", stats.SuccessfulKeys)
-	log.Printf("[FUNC-1]   - Failed: %d", stats.FailedKeys)
-	log.Printf("[FUNC-1]   - Keys Stored in Array: %d", len(stats.Keys))
-	log.Printf("[FUNC-1]   - Values Stored in Array: %d", len(stats.Values))
-	log.Printf("[FUNC-1]   - Total Data: %.2f MB", float64(stats.TotalBytes)/1024/1024)
-	log.Printf("[FUNC-1]   - Duration: %.2f seconds", stats.DurationSeconds)
-	log.Printf("[FUNC-1]   - Throughput: %.2f keys/second", stats.KeysPerSecond)
-	log.Printf("[FUNC-1]   - Data Rate: %.2f MB/second", float64(stats.TotalBytes)/1024/1024/stats.DurationSeconds)
-	log.Printf("[FUNC-1]   - Keys Array Memory: %.2f MB", float64(len(stats.Keys)*KeyLength)/1024/1024)
-	log.Printf("[FUNC-1]   - Values Array Memory: %.2f MB", float64(len(stats.Values)*ValueLength)/1024/1024)
-	log.Printf("[FUNC-1]   - Total Array Memory: %.2f MB", float64(len(stats.Keys)*KeyLength+len(stats.Values)*ValueLength)/1024/1024)
-	log.Println("[FUNC-1] ========================================")
+		execStart := time.Now()
+		cmds, err := pipe.Exec(context.Background())
+		execDuration := time.Since(execStart)
+		if err != nil {
+			log.Printf("[FUNC-1] ERROR: Pipeline for batch %d reported an error: %v", start/BatchSize+1, err)
+		}
 
-	if stats.FailedKeys > 0 {
-		return stats, fmt.Errorf("Func 1 completed with %d failures", stats.FailedKeys)
+		for j, cmd := range cmds {
+			reply, err := cmd.String()
+			if err == nil && reply != "OK" {
+				err = fmt.Errorf("unexpected reply %q", reply)
+			}
+			if err != nil {
+				log.Printf("[FUNC-1] ERROR: Failed to set key #%d: %v", start+j+1, err)
+				stats.FailedKeys++
+				continue
+			}
+			stats.SuccessfulKeys++
+			stats.TotalBytes += int64(len(keys[j]) + len(values[j]))
+			stats.Keys = append(stats.Keys, keys[j])
+			stats.Values = append(stats.Values, values[j])
+		}
+
+		log.Printf("[FUNC-1] Batch of %d keys executed in %v", len(cmds), execDuration)
+		logProgress(end, startTime)
 	}
+}
 
-	return stats, nil
+func logProgress(done int, startTime time.Time) {
+	elapsed := time.Since(startTime).Seconds()
+	rate := float64(done) / elapsed
+	remaining := KeyCount - done
+	eta := time.Duration(float64(remaining)/rate) * time.Second
+
+	log.Printf("[FUNC-1] Progress: %d/%d keys (%.1f%%) | Rate: %.1f keys/sec | ETA: %v",
+		done, KeyCount, float64(done)/float64(KeyCount)*100, rate, eta)
 }
 
 func min(a, b int) int {
@@ -53,6 +53,29 @@ func (r *Registry) IncrementCounter(name string, labels map[string]string) {
 	log.Printf("[METRICS] New counter '%s' created with value 1", name)
 }
 
+// SetCounter sets a counter to a total kept elsewhere, such as a connection
+// pool's cumulative stats. The value must never decrease.
+func (r *Registry) SetCounter(name string, value float64, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log.Printf("[METRICS] Setting counter '%s' to %.2f with labels %v", name, value, labels)
+
+	for _, counter := range r.counters[name] {
+		if labelsMatch(counter.labels, labels) {
+			counter.value = value
+			return
+		}
+	}
+
+	newCounter := &Counter{
+		value:  value,
+		labels: labels,
+	}
+	r.counters[name] = append(r.counters[name], newCounter)
+	log.Printf("[METRICS] New counter '%s' created with value %.2f", name, value)
+}
+
 func (r *Registry) SetGauge(name string, value float64, labels map[string]string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -100,6 +123,57 @@ func (r *Registry) Export() string {
 		"user_operations_total": {
 			"help": "Total number of user operations by operation type and status",
 		},
+		"redis_reconnect_attempts_total": {
+			"help": "Total number of background Redis reconnect attempts by result",
+		},
+		"redis_retries_total": {
+			"help": "Total number of Redis commands retried after a failed dial or a lost connection",
+		},
+		"redis_pubsub_messages_total": {
+			"help": "Total number of Redis pub/sub messages received, by whether they were delivered or dropped for a slow consumer",
+		},
+		"redis_stream_messages_total": {
+			"help": "Total number of Redis stream entries processed by stream workers, by stream and result",
+		},
+		"postgres_reconnect_attempts_total": {
+			"help": "Total number of background PostgreSQL reconnect attempts by result",
+		},
+		"postgres_retries_total": {
+			"help": "Total number of PostgreSQL statements retried after a failed dial or a lost connection",
+		},
+		"redis_pool_hits_total": {
+			"help": "Total number of Redis connection checkouts served from the idle pool",
+		},
+		"redis_pool_misses_total": {
+			"help": "Total number of Redis connection checkouts that had to dial a new connection",
+		},
+		"redis_pool_exhausted_waits_total": {
+			"help": "Total number of Redis connection checkouts that found the pool exhausted and had to wait",
+		},
+		"redis_pool_wait_duration_seconds_total": {
+			"help": "Total time spent waiting for Redis connections in seconds",
+		},
+		"redis_pool_timeouts_total": {
+			"help": "Total number of Redis connection checkouts that timed out on an exhausted pool",
+		},
+		"redis_pool_stale_connections_total": {
+			"help": "Total number of Redis connections closed for exceeding idle timeout or max lifetime",
+		},
+		"postgres_pool_waits_total": {
+			"help": "Total number of PostgreSQL connection checkouts that had to wait",
+		},
+		"postgres_pool_wait_duration_seconds_total": {
+			"help": "Total time spent waiting for PostgreSQL connections in seconds",
+		},
+		"postgres_pool_wait_timeouts_total": {
+			"help": "Total number of PostgreSQL connection checkouts that timed out",
+		},
+		"postgres_pool_rejected_requests_total": {
+			"help": "Total number of PostgreSQL connection checkouts rejected because the wait queue was full",
+		},
+		"postgres_pool_closed_connections_total": {
+			"help": "Total number of PostgreSQL pool connections closed by reason",
+		},
 	}
 
 	counterCount := 0
@@ -184,6 +258,24 @@ func (r *Registry) Export() string {
 		"users_retrieved_count": {
 			"help": "Number of users retrieved in the last get operation",
 		},
+		"users_imported_count": {
+			"help": "Number of users inserted by the last bulk import",
+		},
+		"redis_pool_max_connections": {
+			"help": "Maximum number of Redis connections the pool may open",
+		},
+		"redis_pool_connections": {
+			"help": "Redis pool connections by state (open, in_use, idle)",
+		},
+		"postgres_pool_max_connections": {
+			"help": "Maximum number of PostgreSQL connections the pool may open",
+		},
+		"postgres_pool_connections": {
+			"help": "PostgreSQL pool connections by state (open, in_use, idle)",
+		},
+		"postgres_pool_waiting_requests": {
+			"help": "Number of callers currently waiting for a PostgreSQL connection",
+		},
 	}
 
 	gaugeCount := 0
@@ -0,0 +1,338 @@
+package pg_gateway
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Authentication request codes carried in the first int32 of an 'R' message.
+const (
+	authOK                = 0
+	authKerberosV5        = 2
+	authCleartextPassword = 3
+	authMD5Password       = 5
+	authSCMCredential     = 6
+	authGSS               = 7
+	authGSSContinue       = 8
+	authSSPI              = 9
+	authSASL              = 10
+	authSASLContinue      = 11
+	authSASLFinal         = 12
+)
+
+const scramSHA256 = "SCRAM-SHA-256"
+
+func authMethodName(authType uint32) string {
+	switch authType {
+	case authOK:
+		return "ok"
+	case authKerberosV5:
+		return "KerberosV5"
+	case authCleartextPassword:
+		return "cleartext password"
+	case authMD5Password:
+		return "MD5 password"
+	case authSCMCredential:
+		return "SCM credential"
+	case authGSS, authGSSContinue:
+		return "GSSAPI"
+	case authSSPI:
+		return "SSPI"
+	case authSASL, authSASLContinue, authSASLFinal:
+		return "SASL"
+	}
+	return "unknown"
+}
+
+// authenticate answers one AuthenticationRequest. data is the message payload
+// after the auth type. scram carries the SCRAM exchange across the
+// SASL, SASLContinue and SASLFinal messages. Once it has begun, only those
+// messages are accepted, and AuthenticationOK only after the server
+// signature was verified; otherwise a server that does not know the password
+// could skip the proof or downgrade to a weaker method.
+func (c *pgConn) authenticate(authType uint32, data []byte, scram **scramClient) error {
+	log.Printf("[POSTGRES] Authentication type: %d (%s)", authType, authMethodName(authType))
+
+	if *scram != nil && authType != authOK && authType != authSASLContinue && authType != authSASLFinal {
+		return fmt.Errorf("server requested %s authentication after the %s exchange began", authMethodName(authType), scramSHA256)
+	}
+
+	switch authType {
+	case authOK:
+		if *scram != nil && (*scram).state != scramVerified {
+			return fmt.Errorf("server sent AuthenticationOK before the %s exchange completed", scramSHA256)
+		}
+		log.Printf("[POSTGRES] Authentication successful")
+		return nil
+
+	case authCleartextPassword:
+		log.Printf("[POSTGRES] Clear text password authentication required")
+		if err := c.requirePassword(authType); err != nil {
+			return err
+		}
+		return c.writeAuthMessage(c.buildPasswordMessage(c.config.Password))
+
+	case authMD5Password:
+		log.Printf("[POSTGRES] MD5 password authentication required")
+		if err := c.requirePassword(authType); err != nil {
+			return err
+		}
+		if len(data) < 4 {
+			return fmt.Errorf("AuthenticationMD5Password message is missing the salt")
+		}
+		hashed := md5Password(c.config.User, c.config.Password, data[:4])
+		return c.writeAuthMessage(c.buildPasswordMessage(hashed))
+
+	case authSASL:
+		mechanisms := parseSASLMechanisms(data)
+		log.Printf("[POSTGRES] SASL authentication required, server mechanisms: %v", mechanisms)
+		supported := false
+		for _, mechanism := range mechanisms {
+			if mechanism == scramSHA256 {
+				supported = true
+			}
+		}
+		if !supported {
+			return fmt.Errorf("server offered SASL mechanisms %v, only %s is supported", mechanisms, scramSHA256)
+		}
+		if err := c.requirePassword(authType); err != nil {
+			return err
+		}
+
+		sc, err := newSCRAMClient("", c.config.Password)
+		if err != nil {
+			return err
+		}
+		*scram = sc
+		log.Printf("[POSTGRES] Sending SASLInitialResponse (%s)...", scramSHA256)
+		return c.writeAuthMessage(buildSASLInitialResponse(scramSHA256, sc.clientFirstMessage()))
+
+	case authSASLContinue:
+		if *scram == nil {
+			return fmt.Errorf("received AuthenticationSASLContinue without a SASL exchange in progress")
+		}
+		response, err := (*scram).handleServerFirst(data)
+		if err != nil {
+			return err
+		}
+		log.Printf("[POSTGRES] Sending SASLResponse with client proof...")
+		return c.writeAuthMessage(appendMessage(nil, 'p', response))
+
+	case authSASLFinal:
+		if *scram == nil {
+			return fmt.Errorf("received AuthenticationSASLFinal without a SASL exchange in progress")
+		}
+		if err := (*scram).verifyServerFinal(data); err != nil {
+			return err
+		}
+		log.Printf("[POSTGRES] Server signature verified")
+		return nil
+	}
+
+	return fmt.Errorf("unsupported authentication method %s (type %d) requested by server", authMethodName(authType), authType)
+}
+
+func (c *pgConn) requirePassword(authType uint32) error {
+	if c.config.Password == "" {
+		return fmt.Errorf("server requested %s authentication but no password is configured", authMethodName(authType))
+	}
+	return nil
+}
+
+func (c *pgConn) writeAuthMessage(msg []byte) error {
+	if err := c.writer.Send(msg); err != nil {
+		log.Printf("[POSTGRES] ERROR: Failed to send authentication message: %v", err)
+		return err
+	}
+	return nil
+}
+
+// md5Password computes the AuthenticationMD5Password response:
+// "md5" + md5hex(md5hex(password + user) + salt).
+func md5Password(user, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))
+	innerHex := hex.EncodeToString(inner[:])
+	outer := md5.Sum(append([]byte(innerHex), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// parseSASLMechanisms splits the NUL-terminated mechanism list of an
+// AuthenticationSASL message.
+func parseSASLMechanisms(data []byte) []string {
+	var mechanisms []string
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, 0)
+		if end <= 0 {
+			break
+		}
+		mechanisms = append(mechanisms, string(data[:end]))
+		data = data[end+1:]
+	}
+	return mechanisms
+}
+
+func buildSASLInitialResponse(mechanism string, data []byte) []byte {
+	var payload []byte
+	payload = appendCString(payload, mechanism)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(data)))
+	payload = append(payload, data...)
+	return appendMessage(nil, 'p', payload)
+}
+
+// States of a scramClient, in the order the exchange goes through them.
+const (
+	scramStarted = iota
+	scramProofSent
+	scramVerified
+)
+
+// scramClient runs the client side of SCRAM-SHA-256 (RFC 5802, RFC 7677)
+// without channel binding. The server ignores the SCRAM user name and uses
+// the one from the startup message, so it is normally left empty.
+type scramClient struct {
+	state           int
+	user            string
+	password        string
+	clientNonce     string
+	clientFirstBare string
+	saltedPassword  []byte
+	authMessage     string
+}
+
+func newSCRAMClient(user, password string) (*scramClient, error) {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate SCRAM nonce: %w", err)
+	}
+	return &scramClient{
+		user:        user,
+		password:    password,
+		clientNonce: base64.StdEncoding.EncodeToString(nonce),
+	}, nil
+}
+
+// clientFirstMessage returns "n,,n=<user>,r=<nonce>". The "n,," GS2 header
+// says the client does not support channel binding.
+func (s *scramClient) clientFirstMessage() []byte {
+	s.clientFirstBare = "n=" + s.user + ",r=" + s.clientNonce
+	return []byte("n,," + s.clientFirstBare)
+}
+
+// handleServerFirst parses "r=<nonce>,s=<salt>,i=<iterations>", derives the
+// keys and returns the client-final-message carrying the proof.
+func (s *scramClient) handleServerFirst(data []byte) ([]byte, error) {
+	if s.state != scramStarted {
+		return nil, fmt.Errorf("unexpected SCRAM server-first-message")
+	}
+	serverFirst := string(data)
+	attrs := parseSCRAMAttributes(serverFirst)
+
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, s.clientNonce) || len(nonce) == len(s.clientNonce) {
+		return nil, fmt.Errorf("SCRAM server nonce does not extend the client nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("SCRAM server sent an invalid salt")
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("SCRAM server sent an invalid iteration count %q", attrs["i"])
+	}
+
+	s.saltedPassword = pbkdf2SHA256([]byte(s.password), salt, iterations, sha256.Size)
+	clientKey := hmacSHA256(s.saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+
+	// "biws" is base64("n,,"), the GS2 header sent in the first message.
+	clientFinalWithoutProof := "c=biws,r=" + nonce
+	s.authMessage = s.clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof
+
+	clientSignature := hmacSHA256(storedKey[:], []byte(s.authMessage))
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	s.state = scramProofSent
+	return []byte(clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// verifyServerFinal checks "v=<signature>" so that a server which does not
+// know the password cannot complete the exchange.
+func (s *scramClient) verifyServerFinal(data []byte) error {
+	if s.state != scramProofSent {
+		return fmt.Errorf("unexpected SCRAM server-final-message")
+	}
+	attrs := parseSCRAMAttributes(string(data))
+	if msg, ok := attrs["e"]; ok {
+		return fmt.Errorf("SCRAM authentication failed: %s", msg)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("SCRAM server final message has no valid signature")
+	}
+
+	serverKey := hmacSHA256(s.saltedPassword, []byte("Server Key"))
+	expected := hmacSHA256(serverKey, []byte(s.authMessage))
+	if !hmac.Equal(signature, expected) {
+		return fmt.Errorf("SCRAM server signature mismatch")
+	}
+	s.state = scramVerified
+	return nil
+}
+
+func parseSCRAMAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, part := range strings.Split(msg, ",") {
+		if len(part) >= 2 && part[1] == '=' {
+			attrs[part[:1]] = part[2:]
+		}
+	}
+	return attrs
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// pbkdf2SHA256 implements PBKDF2 (RFC 8018) with HMAC-SHA-256.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	mac := hmac.New(sha256.New, password)
+	hashLen := mac.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	key := make([]byte, 0, numBlocks*hashLen)
+	block := make([]byte, 4)
+	for i := 1; i <= numBlocks; i++ {
+		binary.BigEndian.PutUint32(block, uint32(i))
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write(block)
+		u := mac.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+		for n := 1; n < iterations; n++ {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
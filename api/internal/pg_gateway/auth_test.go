@@ -0,0 +1,157 @@
+package pg_gateway
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// RFC 7677, section 3.
+const (
+	rfc7677User        = "user"
+	rfc7677Password    = "pencil"
+	rfc7677ClientNonce = "rOprNGfwEbeRWgbNEkqO"
+	rfc7677ServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	rfc7677ClientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	rfc7677ServerFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+)
+
+func newRFC7677Client() *scramClient {
+	return &scramClient{user: rfc7677User, password: rfc7677Password, clientNonce: rfc7677ClientNonce}
+}
+
+func TestSCRAMRFC7677(t *testing.T) {
+	sc := newRFC7677Client()
+	if got, want := string(sc.clientFirstMessage()), "n,,n=user,r="+rfc7677ClientNonce; got != want {
+		t.Fatalf("client-first-message = %q, want %q", got, want)
+	}
+	clientFinal, err := sc.handleServerFirst([]byte(rfc7677ServerFirst))
+	if err != nil {
+		t.Fatalf("handleServerFirst: %v", err)
+	}
+	if string(clientFinal) != rfc7677ClientFinal {
+		t.Fatalf("client-final-message = %q, want %q", clientFinal, rfc7677ClientFinal)
+	}
+	if err := sc.verifyServerFinal([]byte(rfc7677ServerFinal)); err != nil {
+		t.Fatalf("verifyServerFinal: %v", err)
+	}
+	if sc.state != scramVerified {
+		t.Fatalf("state = %d, want scramVerified", sc.state)
+	}
+}
+
+func TestSCRAMRejectsBadServerMessages(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverFirst string
+		serverFinal string
+	}{
+		{"nonce not extended", "r=" + rfc7677ClientNonce + ",s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096", ""},
+		{"foreign nonce", "r=other%hvYDpWUa2RaTCAfuxFIlj,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096", ""},
+		{"bad salt", "r=rOprNGfwEbeRWgbNEkqO%x,s=!!,i=4096", ""},
+		{"bad iterations", "r=rOprNGfwEbeRWgbNEkqO%x,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0", ""},
+		{"wrong signature", rfc7677ServerFirst, "v=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="},
+		{"missing signature", rfc7677ServerFirst, "x=1"},
+		{"server error", rfc7677ServerFirst, "e=invalid-proof"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := newRFC7677Client()
+			sc.clientFirstMessage()
+			_, err := sc.handleServerFirst([]byte(tt.serverFirst))
+			if tt.serverFinal == "" {
+				if err == nil {
+					t.Fatal("handleServerFirst succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handleServerFirst: %v", err)
+			}
+			if err := sc.verifyServerFinal([]byte(tt.serverFinal)); err == nil {
+				t.Fatal("verifyServerFinal succeeded, want error")
+			}
+			if sc.state == scramVerified {
+				t.Fatal("state is scramVerified after a failed verification")
+			}
+		})
+	}
+}
+
+func TestAuthenticateSCRAMState(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    int // -1: no SCRAM exchange
+		authType uint32
+		data     []byte
+		wantErr  string
+	}{
+		{"ok without SCRAM", -1, authOK, nil, ""},
+		{"ok after verified signature", scramVerified, authOK, nil, ""},
+		{"ok before server-first", scramStarted, authOK, nil, "before the SCRAM-SHA-256 exchange completed"},
+		{"ok before server-final", scramProofSent, authOK, nil, "before the SCRAM-SHA-256 exchange completed"},
+		{"cleartext after SASL", scramStarted, authCleartextPassword, nil, "after the SCRAM-SHA-256 exchange began"},
+		{"md5 after SASL", scramProofSent, authMD5Password, []byte{1, 2, 3, 4}, "after the SCRAM-SHA-256 exchange began"},
+		{"SASL restarted", scramStarted, authSASL, []byte("SCRAM-SHA-256\x00\x00"), "after the SCRAM-SHA-256 exchange began"},
+		{"final before continue", scramStarted, authSASLFinal, []byte(rfc7677ServerFinal), "unexpected SCRAM server-final-message"},
+		{"continue twice", scramProofSent, authSASLContinue, []byte(rfc7677ServerFirst), "unexpected SCRAM server-first-message"},
+		{"final without SASL", -1, authSASLFinal, []byte(rfc7677ServerFinal), "without a SASL exchange in progress"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &pgConn{
+				config: &Config{User: rfc7677User, Password: rfc7677Password},
+				writer: NewMessageWriter(&bytes.Buffer{}),
+			}
+			var scram *scramClient
+			if tt.state >= 0 {
+				scram = newRFC7677Client()
+				scram.state = tt.state
+			}
+			err := c.authenticate(tt.authType, tt.data, &scram)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("authenticate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("authenticate error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestAuthenticateSCRAMExchange drives a whole exchange through authenticate,
+// with the RFC 7677 nonce swapped in after the initial response.
+func TestAuthenticateSCRAMExchange(t *testing.T) {
+	var sent bytes.Buffer
+	c := &pgConn{
+		config: &Config{User: rfc7677User, Password: rfc7677Password},
+		writer: NewMessageWriter(&sent),
+	}
+	var scram *scramClient
+	if err := c.authenticate(authSASL, []byte("SCRAM-SHA-256\x00\x00"), &scram); err != nil {
+		t.Fatalf("AuthenticationSASL: %v", err)
+	}
+	scram.user = rfc7677User
+	scram.clientNonce = rfc7677ClientNonce
+	scram.clientFirstMessage()
+
+	sent.Reset()
+	if err := c.authenticate(authSASLContinue, []byte(rfc7677ServerFirst), &scram); err != nil {
+		t.Fatalf("AuthenticationSASLContinue: %v", err)
+	}
+	if !strings.HasSuffix(sent.String(), rfc7677ClientFinal) {
+		t.Fatalf("SASLResponse = %q, want it to end with %q", sent.String(), rfc7677ClientFinal)
+	}
+	if err := c.authenticate(authOK, nil, &scram); err == nil {
+		t.Fatal("AuthenticationOK accepted before AuthenticationSASLFinal")
+	}
+	if err := c.authenticate(authSASLFinal, []byte(rfc7677ServerFinal), &scram); err != nil {
+		t.Fatalf("AuthenticationSASLFinal: %v", err)
+	}
+	if err := c.authenticate(authOK, nil, &scram); err != nil {
+		t.Fatalf("AuthenticationOK: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package pg_gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	cancelRequestCode = 80877102
+
+	// cancelTimeout bounds dialing the side connection for a CancelRequest
+	// and waiting for the server to close it.
+	cancelTimeout = 5 * time.Second
+
+	// cancelGracePeriod is how long a cancelled query may take to reach
+	// ReadyForQuery. A server that does not answer by then is considered
+	// gone and the connection is closed.
+	cancelGracePeriod = 10 * time.Second
+)
+
+// cancelWatch sends a CancelRequest for c's backend if ctx is done while a
+// query is running. It never touches the main connection's message stream:
+// the query still ends with ErrorResponse and ReadyForQuery, which the
+// caller drains as usual.
+type cancelWatch struct {
+	c        *pgConn
+	done     chan struct{}
+	finished chan struct{}
+	once     sync.Once
+	canceled bool
+}
+
+func (c *pgConn) watchCancel(ctx context.Context) *cancelWatch {
+	w := &cancelWatch{c: c, done: make(chan struct{}), finished: make(chan struct{})}
+	if ctx.Done() == nil {
+		close(w.finished)
+		return w
+	}
+	go func() {
+		defer close(w.finished)
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			w.canceled = true
+			log.Printf("[POSTGRES] Context done (%v), cancelling query on connection #%d (backend pid %d)", ctx.Err(), c.id, c.processID)
+			if err := c.sendCancelRequest(); err != nil {
+				log.Printf("[POSTGRES] WARNING: Cancel request for connection #%d failed: %v", c.id, err)
+			}
+			c.conn.SetDeadline(time.Now().Add(cancelGracePeriod))
+		}
+	}()
+	return w
+}
+
+// stop ends the watch and reports whether a cancel was sent. It waits for a
+// cancel in flight, so a late CancelRequest can never hit the next query on
+// the same backend. Safe to call more than once.
+func (w *cancelWatch) stop() bool {
+	w.once.Do(func() {
+		close(w.done)
+		<-w.finished
+		if w.canceled {
+			w.c.conn.SetDeadline(time.Time{})
+		}
+	})
+	return w.canceled
+}
+
+// queryError reports err from a query that was (or was not) cancelled. After
+// a cancel the context error is wrapped too, so callers can match either
+// context.Canceled/DeadlineExceeded or the server's PGError.
+func (w *cancelWatch) queryError(ctx context.Context, err error) error {
+	if !w.stop() {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ctx.Err(), err)
+}
+
+// sendCancelRequest opens a side connection, sends the CancelRequest and
+// waits for the server to close it, which it does once the cancel has been
+// handed to the backend.
+func (c *pgConn) sendCancelRequest() error {
+	if c.processID == 0 && c.secretKey == 0 {
+		return fmt.Errorf("server sent no BackendKeyData, query cannot be cancelled")
+	}
+
+	// The side connection negotiates SSL like any other, so the key never
+	// crosses the network in the clear when the main connection is encrypted.
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+	conn, err := dialServer(ctx, c.config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cancelTimeout))
+
+	msg := make([]byte, 16)
+	binary.BigEndian.PutUint32(msg[0:4], 16)
+	binary.BigEndian.PutUint32(msg[4:8], cancelRequestCode)
+	binary.BigEndian.PutUint32(msg[8:12], c.processID)
+	binary.BigEndian.PutUint32(msg[12:16], c.secretKey)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	log.Printf("[POSTGRES] CancelRequest sent for backend pid %d", c.processID)
+
+	// The server replies nothing and closes the connection.
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package pg_gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// pgConn is a single physical connection to the server. It is not safe for
+// concurrent use; PGClient hands connections out through its Pool so that
+// only one goroutine talks on a socket at a time.
+type pgConn struct {
+	id         int64
+	conn       net.Conn
+	reader     *MessageReader
+	writer     *MessageWriter
+	config     *Config
+	createdAt  time.Time
+	lastUsedAt time.Time
+	broken     bool
+	// txStatus is the status byte of the last ReadyForQuery: 'I' idle, 'T'
+	// in a transaction block, 'E' in a failed transaction block.
+	txStatus byte
+	// processID and secretKey come from BackendKeyData and identify the
+	// backend in a CancelRequest.
+	processID uint32
+	secretKey uint32
+}
+
+func newPGConn(ctx context.Context, id int64, config *Config) (*pgConn, error) {
+	c := &pgConn{
+		id:     id,
+		config: config,
+	}
+	if err := c.connect(ctx); err != nil {
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		return nil, err
+	}
+	now := time.Now()
+	c.createdAt = now
+	c.lastUsedAt = now
+	return c, nil
+}
+
+func (c *pgConn) connect(ctx context.Context) error {
+	addr := c.config.Host + ":" + c.config.Port
+	log.Printf("[POSTGRES] Dialing TCP connection to %s...", addr)
+	startDial := time.Now()
+	
+	conn, err := dialServer(ctx, c.config)
+	if err != nil {
+		log.Printf("[POSTGRES] ERROR: Failed to dial: %v", err)
+		return err
+	}
+	c.conn = conn
+	c.reader = NewMessageReader(conn, c.config.MaxMessageSize)
+	c.writer = NewMessageWriter(conn)
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	log.Printf("[POSTGRES] TCP connection established in %v", time.Since(startDial))
+	log.Printf("[POSTGRES] Local address: %s", conn.LocalAddr())
+	log.Printf("[POSTGRES] Remote address: %s", conn.RemoteAddr())
+
+	log.Printf("[POSTGRES] Building startup message...")
+	startupMsg := c.buildStartupMessage()
+	log.Printf("[POSTGRES] Startup message size: %d bytes", len(startupMsg))
+	log.Printf("[POSTGRES] Sending startup message...")
+	
+	if err := c.writer.Send(startupMsg); err != nil {
+		log.Printf("[POSTGRES] ERROR: Failed to send startup message: %v", err)
+		return err
+	}
+	log.Printf("[POSTGRES] Sent %d bytes", len(startupMsg))
+
+	log.Printf("[POSTGRES] Reading authentication response...")
+	msgCount := 0
+	var scram *scramClient
+
+	for {
+		msgType, payload, err := c.reader.ReadMessage()
+		if err != nil {
+			log.Printf("[POSTGRES] ERROR: Failed to read message: %v", err)
+			return err
+		}
+		msgCount++
+		log.Printf("[POSTGRES] Message #%d: type='%c' (0x%02x), payload %d bytes", msgCount, msgType, msgType, len(payload))
+
+		switch msgType {
+		case 'E':
+			// The server closes the connection after a startup error.
+			pgErr := parseErrorFields(payload)
+			logPGError(pgErr)
+			return pgErr
+		case 'N':
+			logNotice(payload)
+		case 'R':
+			if len(payload) < 4 {
+				return fmt.Errorf("authentication message too short (%d bytes)", len(payload))
+			}
+			authType := binary.BigEndian.Uint32(payload[:4])
+			if err := c.authenticate(authType, payload[4:], &scram); err != nil {
+				log.Printf("[POSTGRES] ERROR: Authentication failed: %v", err)
+				return err
+			}
+		case 'S':
+			log.Printf("[POSTGRES] Received backend parameter status message")
+		case 'K':
+			if len(payload) < 8 {
+				return fmt.Errorf("BackendKeyData message too short (%d bytes)", len(payload))
+			}
+			c.processID = binary.BigEndian.Uint32(payload[0:4])
+			c.secretKey = binary.BigEndian.Uint32(payload[4:8])
+			log.Printf("[POSTGRES] Received backend key data (pid %d)", c.processID)
+		case 'Z':
+			if len(payload) > 0 {
+				c.txStatus = payload[0]
+			}
+			log.Printf("[POSTGRES] Received ReadyForQuery message - connection established")
+			log.Printf("[POSTGRES] Connection handshake completed successfully")
+			return nil
+		}
+	}
+}
+
+func (c *pgConn) buildStartupMessage() []byte {
+	log.Printf("[POSTGRES] Building startup message with user='%s', database='%s'", c.config.User, c.config.Database)
+	params := fmt.Sprintf("user\x00%s\x00database\x00%s\x00\x00", c.config.User, c.config.Database)
+	length := len(params) + 8
+	
+	msg := make([]byte, length)
+	msg[0] = byte(length >> 24)
+	msg[1] = byte(length >> 16)
+	msg[2] = byte(length >> 8)
+	msg[3] = byte(length)
+	msg[4] = 0x00
+	msg[5] = 0x03
+	msg[6] = 0x00
+	msg[7] = 0x00
+	copy(msg[8:], params)
+	
+	log.Printf("[POSTGRES] Startup message built: %d bytes total", length)
+	return msg
+}
+
+func (c *pgConn) buildPasswordMessage(password string) []byte {
+	log.Printf("[POSTGRES] Building password message")
+	password += "\x00"
+	length := len(password) + 5
+	
+	msg := make([]byte, length)
+	msg[0] = 'p'
+	msg[1] = byte(length >> 24)
+	msg[2] = byte(length >> 16)
+	msg[3] = byte(length >> 8)
+	msg[4] = byte(length)
+	copy(msg[5:], password)
+	
+	log.Printf("[POSTGRES] Password message built: %d bytes total", length)
+	return msg
+}
+
+// extendedQuery sends Parse/Bind/Describe/Execute/Sync for the unnamed
+// statement and portal and buffers the result. resultFormat selects text or
+// binary encoding for every result column. If ctx is done before the result
+// is complete the query is cancelled on the server and the connection is
+// drained back to ReadyForQuery, so it stays usable.
+func (c *pgConn) extendedQuery(ctx context.Context, query string, args []interface{}, resultFormat int16) (*Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	params, err := encodeParams(args)
+	if err != nil {
+		log.Printf("[POSTGRES] ERROR: Failed to encode query parameters: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[POSTGRES] Building extended query messages (%d parameters)...", len(params))
+	var msg []byte
+	msg = append(msg, buildParseMessage("", query)...)
+	msg = append(msg, buildBindMessage("", "", params, resultFormat)...)
+	msg = append(msg, buildDescribeMessage('P', "")...)
+	msg = append(msg, buildExecuteMessage("", 0)...)
+	msg = append(msg, buildSyncMessage()...)
+	log.Printf("[POSTGRES] Extended query messages size: %d bytes", len(msg))
+	log.Printf("[POSTGRES] Sending query to PostgreSQL...")
+
+	watch := c.watchCancel(ctx)
+	defer watch.stop()
+
+	startWrite := time.Now()
+	if err := c.writer.Send(msg); err != nil {
+		log.Printf("[POSTGRES] ERROR: Failed to write query: %v", err)
+		c.broken = true
+		return nil, watch.queryError(ctx, err)
+	}
+	log.Printf("[POSTGRES] Wrote %d bytes in %v", len(msg), time.Since(startWrite))
+
+	log.Printf("[POSTGRES] Reading query response...")
+	rows := &Rows{}
+	msgCount := 0
+	// After an ErrorResponse the server skips to Sync, so keep reading until
+	// ReadyForQuery and return the error once the connection is idle again.
+	// A message that cannot be decoded is handled the same way, except that
+	// the connection is marked broken as well.
+	var pgErr *PGError
+	var protoErr *ProtocolError
+	protocolError := func(format string, args ...interface{}) {
+		if protoErr == nil {
+			protoErr = &ProtocolError{Message: fmt.Sprintf(format, args...)}
+			log.Printf("[POSTGRES] ERROR: %v", protoErr)
+		}
+		c.broken = true
+	}
+
+	for {
+		msgType, payload, err := c.reader.ReadMessage()
+		if err != nil {
+			log.Printf("[POSTGRES] ERROR: Failed to read message: %v", err)
+			c.broken = true
+			return nil, watch.queryError(ctx, err)
+		}
+		msgCount++
+		log.Printf("[POSTGRES] Response message #%d: type='%c' (0x%02x), payload %d bytes", msgCount, msgType, msgType, len(payload))
+
+		switch msgType {
+		case '1':
+			log.Printf("[POSTGRES] ParseComplete")
+		case '2':
+			log.Printf("[POSTGRES] BindComplete")
+		case 'T':
+			fields, err := parseRowDescription(payload)
+			if err != nil {
+				protocolError("malformed RowDescription: %v", err)
+				continue
+			}
+			rows.fields = fields
+			log.Printf("[POSTGRES] Row description received: %d columns", len(fields))
+		case 'n':
+			log.Printf("[POSTGRES] No data returned by statement")
+		case 'D':
+			values, err := parseDataRow(payload)
+			if err != nil {
+				protocolError("malformed DataRow: %v", err)
+				continue
+			}
+			if len(values) != len(rows.fields) {
+				protocolError("DataRow has %d columns, RowDescription has %d", len(values), len(rows.fields))
+				continue
+			}
+			rows.rows = append(rows.rows, values)
+		case 'C':
+			rows.commandTag = strings.TrimRight(string(payload), "\x00")
+			log.Printf("[POSTGRES] CommandComplete: %s", rows.commandTag)
+		case 'E':
+			if pgErr == nil {
+				pgErr = parseErrorFields(payload)
+				logPGError(pgErr)
+			}
+		case 'N':
+			logNotice(payload)
+		case 'Z':
+			c.lastUsedAt = time.Now()
+			if len(payload) > 0 {
+				c.txStatus = payload[0]
+			}
+			if protoErr != nil {
+				return nil, protoErr
+			}
+			if pgErr != nil {
+				return nil, watch.queryError(ctx, pgErr)
+			}
+			// A cancel that lost the race with the result changes nothing.
+			watch.stop()
+			log.Printf("[POSTGRES] Query completed successfully, %d rows", rows.Len())
+			return rows, nil
+		}
+	}
+}
+
+func buildQueryMessage(query string) []byte {
+	length := len(query) + 5
+	msg := make([]byte, length+1)
+	msg[0] = 'Q'
+	msg[1] = byte(length >> 24)
+	msg[2] = byte(length >> 16)
+	msg[3] = byte(length >> 8)
+	msg[4] = byte(length)
+	copy(msg[5:], query)
+	msg[length] = 0x00
+	
+	return msg
+}
+
+
+// ping checks that the connection still answers queries.
+func (c *pgConn) ping(ctx context.Context) error {
+	_, err := c.extendedQuery(ctx, "SELECT 1", nil, TextFormat)
+	return err
+}
+
+func (c *pgConn) close() error {
+	log.Printf("[POSTGRES] Closing connection #%d...", c.id)
+	if c.conn != nil {
+		log.Printf("[POSTGRES] Sending termination message...")
+		terminateMsg := []byte{'X', 0x00, 0x00, 0x00, 0x04}
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writer.Send(terminateMsg)
+		
+		err := c.conn.Close()
+		if err != nil {
+			log.Printf("[POSTGRES] ERROR: Failed to close connection: %v", err)
+			return err
+		}
+		log.Printf("[POSTGRES] Connection closed successfully")
+		return nil
+	}
+	log.Printf("[POSTGRES] No active connection to close")
+	return nil
+}
@@ -0,0 +1,275 @@
+package pg_gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// copyChunkSize is the largest CopyData message CopyFrom sends.
+const copyChunkSize = 64 * 1024
+
+// CopyFrom runs a COPY ... FROM STDIN statement and streams r to the server
+// as CopyData messages. The data must be in the format the statement names
+// (text, csv or binary). If reading r fails, a CopyFail is sent so the server
+// aborts the COPY and nothing is inserted; the read error is returned.
+// It returns the number of rows copied.
+//
+//	n, err := client.CopyFrom(ctx, "COPY users (user_id, first_name) FROM STDIN WITH (FORMAT csv)", body)
+func (p *PGClient) CopyFrom(ctx context.Context, query string, r io.Reader) (int64, error) {
+	operationStart := time.Now()
+	var n int64
+	err := p.withConn(ctx, false, func(c *pgConn) error {
+		var err error
+		n, err = c.copyFrom(ctx, query, r)
+		return err
+	})
+
+	totalLatency := time.Since(operationStart)
+	log.Printf("[POSTGRES] COPY FROM completed: %d rows (total latency: %v)", n, totalLatency)
+	if p.metricsRegistry != nil {
+		p.metricsRegistry.SetGauge("postgres_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "copy_from"})
+	}
+	return n, err
+}
+
+// CopyTo runs a COPY ... TO STDOUT statement and writes every CopyData
+// message to w. If w fails, the rest of the output is drained and discarded
+// so the connection stays usable, and the write error is returned. It
+// returns the number of rows copied.
+func (p *PGClient) CopyTo(ctx context.Context, query string, w io.Writer) (int64, error) {
+	operationStart := time.Now()
+	var n int64
+	err := p.withConn(ctx, false, func(c *pgConn) error {
+		var err error
+		n, err = c.copyTo(ctx, query, w)
+		return err
+	})
+
+	totalLatency := time.Since(operationStart)
+	log.Printf("[POSTGRES] COPY TO completed: %d rows (total latency: %v)", n, totalLatency)
+	if p.metricsRegistry != nil {
+		p.metricsRegistry.SetGauge("postgres_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "copy_to"})
+	}
+	return n, err
+}
+
+// CopyFrom is PGClient.CopyFrom inside the transaction.
+func (tx *Tx) CopyFrom(ctx context.Context, query string, r io.Reader) (int64, error) {
+	if tx.done {
+		return 0, ErrTxDone
+	}
+	if tx.cn.txStatus == 'E' {
+		return 0, ErrTxAborted
+	}
+	return tx.cn.copyFrom(ctx, query, r)
+}
+
+// CopyTo is PGClient.CopyTo inside the transaction.
+func (tx *Tx) CopyTo(ctx context.Context, query string, w io.Writer) (int64, error) {
+	if tx.done {
+		return 0, ErrTxDone
+	}
+	if tx.cn.txStatus == 'E' {
+		return 0, ErrTxAborted
+	}
+	return tx.cn.copyTo(ctx, query, w)
+}
+
+// copyFrom sends query as a simple Query message. The server answers with
+// CopyInResponse, after which the frontend owns the stream until CopyDone or
+// CopyFail.
+func (c *pgConn) copyFrom(ctx context.Context, query string, r io.Reader) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	watch := c.watchCancel(ctx)
+	defer watch.stop()
+
+	log.Printf("[POSTGRES] Starting COPY FROM STDIN on connection #%d", c.id)
+	log.Printf("[POSTGRES] Query: %s", query)
+	if err := c.writer.Send(buildQueryMessage(query)); err != nil {
+		log.Printf("[POSTGRES] ERROR: Failed to write COPY query: %v", err)
+		c.broken = true
+		return 0, watch.queryError(ctx, err)
+	}
+
+	var (
+		pgErr   *PGError
+		readErr error
+		tag     string
+		copyIn  bool
+	)
+	for {
+		msgType, payload, err := c.reader.ReadMessage()
+		if err != nil {
+			log.Printf("[POSTGRES] ERROR: Failed to read message: %v", err)
+			c.broken = true
+			return 0, watch.queryError(ctx, err)
+		}
+
+		switch msgType {
+		case 'G':
+			copyIn = true
+			log.Printf("[POSTGRES] CopyInResponse received, streaming data...")
+			var sent int64
+			sent, readErr, err = c.sendCopyData(ctx, r)
+			if err != nil {
+				log.Printf("[POSTGRES] ERROR: Failed to write COPY data: %v", err)
+				c.broken = true
+				return 0, watch.queryError(ctx, err)
+			}
+			if readErr != nil {
+				log.Printf("[POSTGRES] WARNING: COPY source failed after %d bytes, sent CopyFail: %v", sent, readErr)
+			} else {
+				log.Printf("[POSTGRES] Sent %d bytes of COPY data", sent)
+			}
+		case 'H', 'W':
+			// Not a COPY FROM STDIN statement. The server is about to stream
+			// data we have nowhere to put, so give up on the connection.
+			log.Printf("[POSTGRES] ERROR: Expected CopyInResponse, got '%c'", msgType)
+			c.broken = true
+			return 0, fmt.Errorf("pg_gateway: CopyFrom requires a COPY ... FROM STDIN statement")
+		case 'C':
+			tag = strings.TrimRight(string(payload), "\x00")
+			log.Printf("[POSTGRES] CommandComplete: %s", tag)
+		case 'E':
+			if pgErr == nil {
+				pgErr = parseErrorFields(payload)
+				logPGError(pgErr)
+			}
+		case 'N':
+			logNotice(payload)
+		case 'Z':
+			c.lastUsedAt = time.Now()
+			if len(payload) > 0 {
+				c.txStatus = payload[0]
+			}
+			if readErr != nil {
+				return 0, readErr
+			}
+			if pgErr != nil {
+				return 0, watch.queryError(ctx, pgErr)
+			}
+			if !copyIn {
+				return 0, fmt.Errorf("pg_gateway: CopyFrom requires a COPY ... FROM STDIN statement")
+			}
+			return (&Rows{commandTag: tag}).RowsAffected(), nil
+		}
+	}
+}
+
+// sendCopyData streams r as CopyData messages and finishes with CopyDone, or
+// with CopyFail if r returns an error other than io.EOF or ctx is done.
+// readErr is that source error; err is a failure to write to the server.
+func (c *pgConn) sendCopyData(ctx context.Context, r io.Reader) (sent int64, readErr error, err error) {
+	buf := make([]byte, copyChunkSize)
+	for {
+		n, rerr := r.Read(buf)
+		if rerr == nil {
+			rerr = ctx.Err()
+		}
+		if n > 0 {
+			if err := c.writer.WriteMessage('d', buf[:n]); err != nil {
+				return sent, nil, err
+			}
+			sent += int64(n)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			msg := appendCString(nil, rerr.Error())
+			return sent, rerr, c.writer.Send(appendMessage(nil, 'f', msg))
+		}
+	}
+	return sent, nil, c.writer.Send(appendMessage(nil, 'c', nil))
+}
+
+// copyTo sends query as a simple Query message and copies every CopyData
+// message to w until CopyDone.
+func (c *pgConn) copyTo(ctx context.Context, query string, w io.Writer) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	watch := c.watchCancel(ctx)
+	defer watch.stop()
+
+	log.Printf("[POSTGRES] Starting COPY TO STDOUT on connection #%d", c.id)
+	log.Printf("[POSTGRES] Query: %s", query)
+	if err := c.writer.Send(buildQueryMessage(query)); err != nil {
+		log.Printf("[POSTGRES] ERROR: Failed to write COPY query: %v", err)
+		c.broken = true
+		return 0, watch.queryError(ctx, err)
+	}
+
+	var (
+		pgErr    *PGError
+		writeErr error
+		tag      string
+		copyOut  bool
+		written  int64
+	)
+	for {
+		msgType, payload, err := c.reader.ReadMessage()
+		if err != nil {
+			log.Printf("[POSTGRES] ERROR: Failed to read message: %v", err)
+			c.broken = true
+			return 0, watch.queryError(ctx, err)
+		}
+
+		switch msgType {
+		case 'H':
+			copyOut = true
+			log.Printf("[POSTGRES] CopyOutResponse received, receiving data...")
+		case 'd':
+			// After a failed write keep reading: the server cannot be stopped
+			// mid-COPY and the stream has to reach ReadyForQuery.
+			if writeErr == nil {
+				if _, err := w.Write(payload); err != nil {
+					log.Printf("[POSTGRES] WARNING: COPY destination failed after %d bytes, discarding the rest: %v", written, err)
+					writeErr = err
+				}
+				written += int64(len(payload))
+			}
+		case 'c':
+			log.Printf("[POSTGRES] CopyDone received (%d bytes)", written)
+		case 'G':
+			// COPY FROM STDIN by mistake: abort it.
+			log.Printf("[POSTGRES] ERROR: Expected CopyOutResponse, got CopyInResponse")
+			msg := appendCString(nil, "pg_gateway: CopyTo requires a COPY ... TO STDOUT statement")
+			if err := c.writer.Send(appendMessage(nil, 'f', msg)); err != nil {
+				c.broken = true
+				return 0, err
+			}
+		case 'C':
+			tag = strings.TrimRight(string(payload), "\x00")
+			log.Printf("[POSTGRES] CommandComplete: %s", tag)
+		case 'E':
+			if pgErr == nil {
+				pgErr = parseErrorFields(payload)
+				logPGError(pgErr)
+			}
+		case 'N':
+			logNotice(payload)
+		case 'Z':
+			c.lastUsedAt = time.Now()
+			if len(payload) > 0 {
+				c.txStatus = payload[0]
+			}
+			if writeErr != nil {
+				return 0, writeErr
+			}
+			if pgErr != nil {
+				return 0, watch.queryError(ctx, pgErr)
+			}
+			if !copyOut {
+				return 0, fmt.Errorf("pg_gateway: CopyTo requires a COPY ... TO STDOUT statement")
+			}
+			return (&Rows{commandTag: tag}).RowsAffected(), nil
+		}
+	}
+}
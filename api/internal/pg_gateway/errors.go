@@ -0,0 +1,157 @@
+package pg_gateway
+
+import (
+	"fmt"
+	"log"
+)
+
+// SQLSTATE codes the application checks for. The full list is in the
+// PostgreSQL manual, appendix A.
+const (
+	SQLStateStringDataRightTruncation = "22001"
+	SQLStateNumericValueOutOfRange    = "22003"
+	SQLStateInvalidTextRepresentation = "22P02"
+	SQLStateNotNullViolation          = "23502"
+	SQLStateForeignKeyViolation       = "23503"
+	SQLStateUniqueViolation           = "23505"
+	SQLStateCheckViolation            = "23514"
+	SQLStateInvalidPassword           = "28P01"
+	SQLStateUndefinedTable            = "42P01"
+	SQLStateQueryCanceled             = "57014"
+)
+
+// PGError is an ErrorResponse (or NoticeResponse) sent by the server. Fields
+// the server did not include are left empty.
+type PGError struct {
+	Severity         string
+	Code             string
+	Message          string
+	Detail           string
+	Hint             string
+	Position         string
+	InternalPosition string
+	InternalQuery    string
+	Where            string
+	SchemaName       string
+	TableName        string
+	ColumnName       string
+	DataTypeName     string
+	ConstraintName   string
+	File             string
+	Line             string
+	Routine          string
+}
+
+func (e *PGError) Error() string {
+	msg := fmt.Sprintf("%s: %s (SQLSTATE %s)", e.Severity, e.Message, e.Code)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// Class returns the two-character SQLSTATE class, e.g. "23" for integrity
+// constraint violations.
+func (e *PGError) Class() string {
+	if len(e.Code) < 2 {
+		return ""
+	}
+	return e.Code[:2]
+}
+
+// ProtocolError reports a message from the server that could not be
+// decoded. The connection it arrived on is marked broken and discarded, since
+// nothing it returns afterwards can be trusted.
+type ProtocolError struct {
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return "postgres protocol error: " + e.Message
+}
+
+// parseErrorFields decodes the field list shared by ErrorResponse and
+// NoticeResponse: a sequence of (type byte, C string) pairs ending in a zero
+// byte.
+func parseErrorFields(payload []byte) *PGError {
+	e := &PGError{}
+	var localizedSeverity string
+	for len(payload) > 0 && payload[0] != 0 {
+		fieldType := payload[0]
+		payload = payload[1:]
+		end := 0
+		for end < len(payload) && payload[end] != 0 {
+			end++
+		}
+		value := string(payload[:end])
+		if end < len(payload) {
+			end++
+		}
+		payload = payload[end:]
+
+		switch fieldType {
+		case 'S':
+			localizedSeverity = value
+		case 'V':
+			e.Severity = value
+		case 'C':
+			e.Code = value
+		case 'M':
+			e.Message = value
+		case 'D':
+			e.Detail = value
+		case 'H':
+			e.Hint = value
+		case 'P':
+			e.Position = value
+		case 'p':
+			e.InternalPosition = value
+		case 'q':
+			e.InternalQuery = value
+		case 'W':
+			e.Where = value
+		case 's':
+			e.SchemaName = value
+		case 't':
+			e.TableName = value
+		case 'c':
+			e.ColumnName = value
+		case 'd':
+			e.DataTypeName = value
+		case 'n':
+			e.ConstraintName = value
+		case 'F':
+			e.File = value
+		case 'L':
+			e.Line = value
+		case 'R':
+			e.Routine = value
+		}
+	}
+	// 'V' (never localized) only exists since 9.6.
+	if e.Severity == "" {
+		e.Severity = localizedSeverity
+	}
+	return e
+}
+
+func logNotice(payload []byte) {
+	notice := parseErrorFields(payload)
+	log.Printf("[POSTGRES] NOTICE: %s: %s (SQLSTATE %s)", notice.Severity, notice.Message, notice.Code)
+	if notice.Detail != "" {
+		log.Printf("[POSTGRES] NOTICE detail: %s", notice.Detail)
+	}
+}
+
+func logPGError(e *PGError) {
+	log.Printf("[POSTGRES] ERROR: %s: %s (SQLSTATE %s)", e.Severity, e.Message, e.Code)
+	if e.Detail != "" {
+		log.Printf("[POSTGRES] ERROR detail: %s", e.Detail)
+	}
+	if e.Hint != "" {
+		log.Printf("[POSTGRES] ERROR hint: %s", e.Hint)
+	}
+	if e.ConstraintName != "" || e.ColumnName != "" {
+		log.Printf("[POSTGRES] ERROR table=%s column=%s constraint=%s", e.TableName, e.ColumnName, e.ConstraintName)
+	}
+}
@@ -0,0 +1,410 @@
+package pg_gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api/internal/backoff"
+)
+
+// ErrListenerClosed is returned by Listen and Unlisten after Close.
+var ErrListenerClosed = errors.New("pg_gateway: listener is closed")
+
+const (
+	// DefaultNotificationBuffer is the capacity of Listener.Notifications.
+	DefaultNotificationBuffer = 256
+
+	listenerMinBackoff = 500 * time.Millisecond
+	listenerMaxBackoff = 30 * time.Second
+)
+
+// listenerConnID numbers listener connections. They live outside the pool.
+var listenerConnID int64
+
+// Notification is a NotificationResponse: a NOTIFY (or pg_notify) on a
+// channel the listener is subscribed to.
+type Notification struct {
+	// PID is the process ID of the notifying backend.
+	PID     uint32
+	Channel string
+	Payload string
+}
+
+// Listener holds a dedicated connection for LISTEN and delivers
+// notifications on a channel. If the connection drops it reconnects with
+// backoff and re-issues LISTEN for every channel. Notifications sent while it
+// was disconnected are lost; a nil *Notification is delivered after each
+// reconnect so consumers can resynchronize.
+//
+//	l := pg_gateway.NewListener(config)
+//	defer l.Close()
+//	l.Listen(ctx, "users_changes")
+//	for n := range l.Notifications() { ... }
+type Listener struct {
+	config        Config
+	notifications chan *Notification
+	done          chan struct{}
+	stopped       chan struct{}
+
+	// cmdMu keeps one LISTEN/UNLISTEN in flight at a time.
+	cmdMu sync.Mutex
+
+	mu       sync.Mutex
+	cn       *pgConn
+	channels map[string]struct{}
+	// pending holds one reply slot per command sent on cn, in order. The
+	// read loop answers the oldest at each ReadyForQuery.
+	pending []chan error
+	closed  bool
+}
+
+// NewListener starts a listener for config in the background. Pool settings
+// are ignored.
+func NewListener(config Config) *Listener {
+	l := &Listener{
+		config:        config,
+		notifications: make(chan *Notification, DefaultNotificationBuffer),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		channels:      make(map[string]struct{}),
+	}
+	log.Printf("[POSTGRES] Starting listener for %s:%s/%s", config.Host, config.Port, config.Database)
+	go l.run()
+	return l
+}
+
+// NewListener starts a listener with the client's configuration.
+func (p *PGClient) NewListener() *Listener {
+	return NewListener(p.config)
+}
+
+// Notifications is closed once the listener is closed.
+func (l *Listener) Notifications() <-chan *Notification {
+	return l.notifications
+}
+
+// Listen subscribes to channel. If the listener is between connections the
+// channel is recorded and subscribed to as soon as it reconnects.
+func (l *Listener) Listen(ctx context.Context, channel string) error {
+	return l.command(ctx, channel, true)
+}
+
+// Unlisten unsubscribes from channel.
+func (l *Listener) Unlisten(ctx context.Context, channel string) error {
+	return l.command(ctx, channel, false)
+}
+
+func (l *Listener) command(ctx context.Context, channel string, listen bool) error {
+	l.cmdMu.Lock()
+	defer l.cmdMu.Unlock()
+
+	stmt := "UNLISTEN " + quoteIdentifier(channel)
+	if listen {
+		stmt = "LISTEN " + quoteIdentifier(channel)
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrListenerClosed
+	}
+	if listen {
+		l.channels[channel] = struct{}{}
+	} else {
+		delete(l.channels, channel)
+	}
+	cn := l.cn
+	if cn == nil {
+		l.mu.Unlock()
+		log.Printf("[POSTGRES] Listener not connected, %s deferred until reconnect", stmt)
+		return nil
+	}
+	reply := make(chan error, 1)
+	l.pending = append(l.pending, reply)
+	err := cn.writer.Send(buildQueryMessage(stmt))
+	l.mu.Unlock()
+	if err != nil {
+		// The read loop sees the broken socket too and answers reply.
+		log.Printf("[POSTGRES] ERROR: Listener failed to send %s: %v", stmt, err)
+	} else {
+		log.Printf("[POSTGRES] Listener sent %s", stmt)
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.done:
+		return ErrListenerClosed
+	}
+}
+
+// Close stops the listener, closes its connection and then the
+// Notifications channel.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	cn := l.cn
+	l.mu.Unlock()
+
+	log.Printf("[POSTGRES] Closing listener...")
+	if cn != nil {
+		// Wake the read loop; it closes the connection on its way out.
+		cn.conn.SetReadDeadline(time.Now())
+	}
+	<-l.stopped
+	log.Printf("[POSTGRES] Listener closed")
+	return nil
+}
+
+func (l *Listener) run() {
+	defer close(l.stopped)
+	defer close(l.notifications)
+
+	bo := backoff.Backoff{Min: listenerMinBackoff, Max: listenerMaxBackoff, Jitter: 0.5}
+	connected := false
+	for {
+		cn, err := l.connect()
+		if err == nil {
+			bo.Reset()
+			if connected && !l.deliver(nil) {
+				return
+			}
+			connected = true
+			err = l.readLoop(cn)
+			l.disconnect(cn, err)
+		}
+		if l.isClosed() {
+			return
+		}
+
+		delay := bo.Next()
+		log.Printf("[POSTGRES] WARNING: Listener connection lost: %v (reconnecting in %v)", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// connect opens a connection and subscribes it to every channel before
+// publishing it, so a Listen racing with the reconnect is never lost.
+func (l *Listener) connect() (*pgConn, error) {
+	id := atomic.AddInt64(&listenerConnID, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go func() {
+		select {
+		case <-l.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	cn, err := newPGConn(ctx, id, &l.config)
+	if err != nil {
+		return nil, err
+	}
+
+	var listened []string
+	for {
+		l.mu.Lock()
+		channels := l.channelList()
+		if l.closed || equalStrings(channels, listened) {
+			if !l.closed {
+				l.cn = cn
+			}
+			l.mu.Unlock()
+			break
+		}
+		l.mu.Unlock()
+
+		// Start from a clean slate so channels dropped meanwhile go away.
+		stmts := []string{"UNLISTEN *"}
+		for _, channel := range channels {
+			stmts = append(stmts, "LISTEN "+quoteIdentifier(channel))
+		}
+		if err := l.resubscribe(cn, strings.Join(stmts, "; ")); err != nil {
+			cn.close()
+			return nil, err
+		}
+		listened = channels
+	}
+	if l.isClosed() {
+		cn.close()
+		return nil, ErrListenerClosed
+	}
+	log.Printf("[POSTGRES] Listener connection #%d ready, listening on %v", cn.id, listened)
+	return cn, nil
+}
+
+// resubscribe runs stmt on a connection nobody else can see yet.
+// Notifications that arrive meanwhile are delivered as usual.
+func (l *Listener) resubscribe(cn *pgConn, stmt string) error {
+	if err := cn.writer.Send(buildQueryMessage(stmt)); err != nil {
+		return err
+	}
+	var pgErr *PGError
+	for {
+		msgType, payload, err := cn.reader.ReadMessage()
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case 'A':
+			if n, err := parseNotification(payload); err == nil && !l.deliver(n) {
+				return ErrListenerClosed
+			}
+		case 'E':
+			if pgErr == nil {
+				pgErr = parseErrorFields(payload)
+				logPGError(pgErr)
+			}
+		case 'N':
+			logNotice(payload)
+		case 'Z':
+			if pgErr != nil {
+				return pgErr
+			}
+			return nil
+		}
+	}
+}
+
+// readLoop owns all reads on cn until it fails.
+func (l *Listener) readLoop(cn *pgConn) error {
+	var pgErr *PGError
+	for {
+		msgType, payload, err := cn.reader.ReadMessage()
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case 'A':
+			n, err := parseNotification(payload)
+			if err != nil {
+				log.Printf("[POSTGRES] WARNING: %v", err)
+				continue
+			}
+			log.Printf("[POSTGRES] Notification on '%s' from backend %d (%d bytes)", n.Channel, n.PID, len(n.Payload))
+			if !l.deliver(n) {
+				return ErrListenerClosed
+			}
+		case 'E':
+			if pgErr == nil {
+				pgErr = parseErrorFields(payload)
+				logPGError(pgErr)
+			}
+		case 'N':
+			logNotice(payload)
+		case 'S':
+			// ParameterStatus can arrive at any time.
+		case 'Z':
+			var reply error
+			if pgErr != nil {
+				reply = pgErr
+			}
+			pgErr = nil
+			l.mu.Lock()
+			if len(l.pending) > 0 {
+				l.pending[0] <- reply
+				l.pending = l.pending[1:]
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *Listener) disconnect(cn *pgConn, err error) {
+	l.mu.Lock()
+	if l.cn == cn {
+		l.cn = nil
+	}
+	for _, reply := range l.pending {
+		reply <- err
+	}
+	l.pending = nil
+	l.mu.Unlock()
+	cn.close()
+}
+
+// deliver blocks until n is queued or the listener is closed. Blocking only
+// delays reading the socket; the server queues notifications meanwhile.
+func (l *Listener) deliver(n *Notification) bool {
+	select {
+	case l.notifications <- n:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *Listener) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// channelList returns the channels sorted. Called with l.mu held.
+func (l *Listener) channelList() []string {
+	channels := make([]string, 0, len(l.channels))
+	for channel := range l.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseNotification decodes a NotificationResponse: int32 process ID, then
+// the channel name and payload as C strings.
+func parseNotification(payload []byte) (*Notification, error) {
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("NotificationResponse too short (%d bytes)", len(payload))
+	}
+	n := &Notification{PID: binary.BigEndian.Uint32(payload[:4])}
+	rest := payload[4:]
+	end := bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return nil, fmt.Errorf("NotificationResponse channel is not terminated")
+	}
+	n.Channel = string(rest[:end])
+	rest = rest[end+1:]
+	end = bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return nil, fmt.Errorf("NotificationResponse payload is not terminated")
+	}
+	n.Payload = string(rest[:end])
+	return n, nil
+}
+
+// quoteIdentifier quotes a channel or other identifier for use in SQL.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
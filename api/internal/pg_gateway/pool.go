@@ -0,0 +1,508 @@
+package pg_gateway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPoolClosed    = errors.New("pg_gateway: connection pool is closed")
+	ErrPoolExhausted = errors.New("pg_gateway: connection pool wait queue is full")
+	ErrPoolTimeout   = errors.New("pg_gateway: timed out waiting for a pooled connection")
+)
+
+// PoolConfig controls how many connections the pool keeps and for how long.
+type PoolConfig struct {
+	// MinConns connections are opened up front and re-established by the
+	// reaper whenever the pool drops below it.
+	MinConns int
+	// MaxConns caps the number of open connections, idle or in use.
+	MaxConns int
+	// IdleTimeout closes connections that sat idle for longer, as long as
+	// MinConns stay open. Zero disables idle reaping.
+	IdleTimeout time.Duration
+	// MaxLifetime closes connections older than this on checkout, release or
+	// reap. Zero means connections live forever.
+	MaxLifetime time.Duration
+	// MaxWaiters bounds how many callers may queue for a connection once
+	// MaxConns are in use; further callers get ErrPoolExhausted. Zero means
+	// no limit.
+	MaxWaiters int
+	// WaitTimeout bounds how long a queued caller waits. Zero waits until
+	// the caller's context is done.
+	WaitTimeout time.Duration
+	// HealthCheckAfter pings connections that have been idle for at least
+	// this long before handing them out. Zero pings on every checkout.
+	HealthCheckAfter time.Duration
+	// ReapInterval is how often idle connections are reaped and pool stats
+	// are published.
+	ReapInterval time.Duration
+}
+
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MinConns:         2,
+		MaxConns:         10,
+		IdleTimeout:      5 * time.Minute,
+		MaxLifetime:      30 * time.Minute,
+		MaxWaiters:       100,
+		WaitTimeout:      30 * time.Second,
+		HealthCheckAfter: 30 * time.Second,
+		ReapInterval:     10 * time.Second,
+	}
+}
+
+// PoolStats is a point-in-time view of the pool.
+type PoolStats struct {
+	MaxConns        int
+	OpenConns       int
+	InUse           int
+	Idle            int
+	Waiting         int
+	WaitCount       int64
+	WaitDuration    time.Duration
+	Timeouts        int64
+	Rejected        int64
+	IdleClosed      int64
+	LifetimeClosed  int64
+	UnhealthyClosed int64
+}
+
+// Pool hands out pgConns to one goroutine at a time. Callers that find every
+// connection busy wait in a FIFO queue; a released connection, or the slot
+// freed by a closed one, goes to the oldest waiter first.
+type Pool struct {
+	config PoolConfig
+	dial   func(ctx context.Context, id int64) (*pgConn, error)
+
+	mu      sync.Mutex
+	idle    []*pgConn
+	numOpen int
+	nextID  int64
+	// waiters receive either a connection or nil, which means a slot has
+	// been reserved for them and they should dial their own.
+	waiters []chan *pgConn
+	closed  bool
+	stop    chan struct{}
+
+	waitCount       int64
+	waitDuration    time.Duration
+	timeouts        int64
+	rejected        int64
+	idleClosed      int64
+	lifetimeClosed  int64
+	unhealthyClosed int64
+
+	metricsRegistry interface {
+		SetGauge(name string, value float64, labels map[string]string)
+		SetCounter(name string, value float64, labels map[string]string)
+	}
+}
+
+func NewPool(dial func(ctx context.Context, id int64) (*pgConn, error), config PoolConfig) *Pool {
+	if config.MaxConns <= 0 {
+		config.MaxConns = 1
+	}
+	if config.MinConns > config.MaxConns {
+		config.MinConns = config.MaxConns
+	}
+	if config.ReapInterval <= 0 {
+		config.ReapInterval = DefaultPoolConfig().ReapInterval
+	}
+
+	p := &Pool{
+		config: config,
+		dial:   dial,
+		stop:   make(chan struct{}),
+	}
+	go p.reapLoop()
+	return p
+}
+
+func (p *Pool) SetMetricsRegistry(registry interface {
+	SetGauge(name string, value float64, labels map[string]string)
+	SetCounter(name string, value float64, labels map[string]string)
+}) {
+	p.mu.Lock()
+	p.metricsRegistry = registry
+	p.mu.Unlock()
+	p.publishStats()
+}
+
+// warmUp opens MinConns connections, and at least one, so that a bad
+// address or credentials fail at startup instead of on the first request.
+func (p *Pool) warmUp(ctx context.Context) error {
+	want := p.config.MinConns
+	if want < 1 {
+		want = 1
+	}
+	conns := make([]*pgConn, 0, want)
+	defer func() {
+		for _, c := range conns {
+			p.release(c)
+		}
+	}()
+	for i := 0; i < want; i++ {
+		c, err := p.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, c)
+	}
+	return nil
+}
+
+func (p *Pool) acquire(ctx context.Context) (*pgConn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		if n := len(p.idle); n > 0 {
+			c := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			if reason := p.expiredReason(c, time.Now()); reason != "" {
+				p.destroyLocked(c, reason)
+				p.mu.Unlock()
+				continue
+			}
+			p.mu.Unlock()
+
+			if time.Since(c.lastUsedAt) >= p.config.HealthCheckAfter {
+				if err := c.ping(ctx); err != nil {
+					log.Printf("[POSTGRES] WARNING: Connection #%d failed health check: %v", c.id, err)
+					p.mu.Lock()
+					p.destroyLocked(c, "unhealthy")
+					p.mu.Unlock()
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+					continue
+				}
+			}
+			return c, nil
+		}
+
+		if p.numOpen < p.config.MaxConns {
+			p.numOpen++
+			p.mu.Unlock()
+			return p.openReserved(ctx)
+		}
+
+		if p.config.MaxWaiters > 0 && len(p.waiters) >= p.config.MaxWaiters {
+			p.rejected++
+			p.mu.Unlock()
+			log.Printf("[POSTGRES] WARNING: Pool exhausted, %d callers already waiting", p.config.MaxWaiters)
+			return nil, ErrPoolExhausted
+		}
+		ch := make(chan *pgConn, 1)
+		p.waiters = append(p.waiters, ch)
+		p.waitCount++
+		p.mu.Unlock()
+
+		waitStart := time.Now()
+		c, err := p.wait(ctx, ch)
+		p.mu.Lock()
+		p.waitDuration += time.Since(waitStart)
+		p.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			return p.openReserved(ctx)
+		}
+		return c, nil
+	}
+}
+
+func (p *Pool) wait(ctx context.Context, ch chan *pgConn) (*pgConn, error) {
+	var timeout <-chan time.Time
+	if p.config.WaitTimeout > 0 {
+		timer := time.NewTimer(p.config.WaitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case c, ok := <-ch:
+		if !ok {
+			return nil, ErrPoolClosed
+		}
+		return c, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrPoolTimeout
+	}
+
+	p.mu.Lock()
+	if err == ErrPoolTimeout {
+		p.timeouts++
+	}
+	removed := p.removeWaiterLocked(ch)
+	p.mu.Unlock()
+
+	if !removed {
+		// A connection or slot was handed over while we gave up; pass it on.
+		c, ok := <-ch
+		if !ok {
+			return nil, err
+		}
+		if c != nil {
+			p.release(c)
+		} else {
+			p.mu.Lock()
+			p.numOpen--
+			p.handOffSlotLocked()
+			p.mu.Unlock()
+		}
+	}
+	return nil, err
+}
+
+// openReserved dials a connection for a slot already counted in numOpen.
+func (p *Pool) openReserved(ctx context.Context) (*pgConn, error) {
+	p.mu.Lock()
+	p.nextID++
+	id := p.nextID
+	p.mu.Unlock()
+
+	c, err := p.dial(ctx, id)
+	if err != nil {
+		p.mu.Lock()
+		p.numOpen--
+		p.handOffSlotLocked()
+		p.mu.Unlock()
+		return nil, err
+	}
+	log.Printf("[POSTGRES] Pool opened connection #%d", id)
+	return c, nil
+}
+
+func (p *Pool) release(c *pgConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c.lastUsedAt = time.Now()
+	switch {
+	case p.closed:
+		p.destroyLocked(c, "closed")
+		return
+	case c.broken:
+		p.destroyLocked(c, "unhealthy")
+		return
+	case c.txStatus != 'I':
+		// A connection left inside a transaction block would leak its
+		// transaction into the next caller.
+		log.Printf("[POSTGRES] WARNING: Connection #%d released with transaction status '%c'", c.id, c.txStatus)
+		p.destroyLocked(c, "open_transaction")
+		return
+	}
+	if p.config.MaxLifetime > 0 && time.Since(c.createdAt) > p.config.MaxLifetime {
+		p.destroyLocked(c, "lifetime")
+		return
+	}
+
+	if len(p.waiters) > 0 {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		w <- c
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (p *Pool) expiredReason(c *pgConn, now time.Time) string {
+	if p.config.MaxLifetime > 0 && now.Sub(c.createdAt) > p.config.MaxLifetime {
+		return "lifetime"
+	}
+	if c.broken {
+		return "unhealthy"
+	}
+	return ""
+}
+
+// destroyLocked closes c in the background and gives its slot to the next
+// waiter, if any.
+func (p *Pool) destroyLocked(c *pgConn, reason string) {
+	switch reason {
+	case "idle":
+		p.idleClosed++
+	case "lifetime":
+		p.lifetimeClosed++
+	case "unhealthy":
+		p.unhealthyClosed++
+	}
+	log.Printf("[POSTGRES] Pool closing connection #%d (reason: %s)", c.id, reason)
+	p.numOpen--
+	p.handOffSlotLocked()
+	go c.close()
+}
+
+func (p *Pool) handOffSlotLocked() {
+	if p.closed || len(p.waiters) == 0 || p.numOpen >= p.config.MaxConns {
+		return
+	}
+	w := p.waiters[0]
+	p.waiters = p.waiters[1:]
+	p.numOpen++
+	w <- nil
+}
+
+func (p *Pool) removeWaiterLocked(ch chan *pgConn) bool {
+	for i, w := range p.waiters {
+		if w == ch {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// purgeIdle closes every idle connection and returns how many there were.
+// It is called when the server went away: the idle connections are just as
+// dead, and handing them out would fail one caller each.
+func (p *Pool) purgeIdle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	idle := p.idle
+	p.idle = nil
+	for _, c := range idle {
+		p.destroyLocked(c, "unhealthy")
+	}
+	return len(idle)
+}
+
+func (p *Pool) reapLoop() {
+	ticker := time.NewTicker(p.config.ReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.reap()
+			p.publishStats()
+		}
+	}
+}
+
+// reap closes expired idle connections and tops the pool back up to
+// MinConns.
+func (p *Pool) reap() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	kept := p.idle[:0]
+	// idle is used as a stack, so the oldest connections come first.
+	for _, c := range p.idle {
+		if reason := p.expiredReason(c, now); reason != "" {
+			p.destroyLocked(c, reason)
+			continue
+		}
+		if p.config.IdleTimeout > 0 && now.Sub(c.lastUsedAt) > p.config.IdleTimeout && p.numOpen > p.config.MinConns {
+			p.destroyLocked(c, "idle")
+			continue
+		}
+		kept = append(kept, c)
+	}
+	for i := len(kept); i < len(p.idle); i++ {
+		p.idle[i] = nil
+	}
+	p.idle = kept
+
+	missing := p.config.MinConns - p.numOpen
+	if missing > 0 {
+		p.numOpen += missing
+	}
+	p.mu.Unlock()
+
+	for i := 0; i < missing; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), p.config.ReapInterval)
+		c, err := p.openReserved(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("[POSTGRES] WARNING: Pool could not replenish minimum connections: %v", err)
+			continue
+		}
+		p.release(c)
+	}
+}
+
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return PoolStats{
+		MaxConns:        p.config.MaxConns,
+		OpenConns:       p.numOpen,
+		InUse:           p.numOpen - len(p.idle),
+		Idle:            len(p.idle),
+		Waiting:         len(p.waiters),
+		WaitCount:       p.waitCount,
+		WaitDuration:    p.waitDuration,
+		Timeouts:        p.timeouts,
+		Rejected:        p.rejected,
+		IdleClosed:      p.idleClosed,
+		LifetimeClosed:  p.lifetimeClosed,
+		UnhealthyClosed: p.unhealthyClosed,
+	}
+}
+
+func (p *Pool) publishStats() {
+	p.mu.Lock()
+	registry := p.metricsRegistry
+	p.mu.Unlock()
+	if registry == nil {
+		return
+	}
+
+	stats := p.Stats()
+	registry.SetGauge("postgres_pool_max_connections", float64(stats.MaxConns), map[string]string{})
+	registry.SetGauge("postgres_pool_connections", float64(stats.OpenConns), map[string]string{"state": "open"})
+	registry.SetGauge("postgres_pool_connections", float64(stats.InUse), map[string]string{"state": "in_use"})
+	registry.SetGauge("postgres_pool_connections", float64(stats.Idle), map[string]string{"state": "idle"})
+	registry.SetGauge("postgres_pool_waiting_requests", float64(stats.Waiting), map[string]string{})
+	registry.SetCounter("postgres_pool_waits_total", float64(stats.WaitCount), map[string]string{})
+	registry.SetCounter("postgres_pool_wait_duration_seconds_total", stats.WaitDuration.Seconds(), map[string]string{})
+	registry.SetCounter("postgres_pool_wait_timeouts_total", float64(stats.Timeouts), map[string]string{})
+	registry.SetCounter("postgres_pool_rejected_requests_total", float64(stats.Rejected), map[string]string{})
+	registry.SetCounter("postgres_pool_closed_connections_total", float64(stats.IdleClosed), map[string]string{"reason": "idle"})
+	registry.SetCounter("postgres_pool_closed_connections_total", float64(stats.LifetimeClosed), map[string]string{"reason": "lifetime"})
+	registry.SetCounter("postgres_pool_closed_connections_total", float64(stats.UnhealthyClosed), map[string]string{"reason": "unhealthy"})
+}
+
+// Close closes idle connections and stops the reaper. Connections still in
+// use are closed as they are released.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	idle := p.idle
+	p.idle = nil
+	for _, c := range idle {
+		p.destroyLocked(c, "closed")
+	}
+	waiters := p.waiters
+	p.waiters = nil
+	p.mu.Unlock()
+
+	// Closing a waiter's channel tells it the pool is gone.
+	for _, w := range waiters {
+		close(w)
+	}
+}
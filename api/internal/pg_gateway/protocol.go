@@ -0,0 +1,138 @@
+package pg_gateway
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// appendMessage frames a frontend message: type byte, int32 length
+// (including the length itself) and the payload.
+func appendMessage(dst []byte, msgType byte, payload []byte) []byte {
+	dst = append(dst, msgType)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(payload)+4))
+	return append(dst, payload...)
+}
+
+func appendCString(dst []byte, s string) []byte {
+	dst = append(dst, s...)
+	return append(dst, 0x00)
+}
+
+// buildParseMessage prepares a statement. Parameter types are left
+// unspecified so the server infers them from the query.
+func buildParseMessage(statement, query string) []byte {
+	var payload []byte
+	payload = appendCString(payload, statement)
+	payload = appendCString(payload, query)
+	payload = binary.BigEndian.AppendUint16(payload, 0)
+	return appendMessage(nil, 'P', payload)
+}
+
+// buildBindMessage binds text-format parameters to a statement. A nil
+// parameter is sent as SQL NULL. All result columns are requested in
+// resultFormat.
+func buildBindMessage(portal, statement string, params [][]byte, resultFormat int16) []byte {
+	var payload []byte
+	payload = appendCString(payload, portal)
+	payload = appendCString(payload, statement)
+	payload = binary.BigEndian.AppendUint16(payload, 0)
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(params)))
+	for _, param := range params {
+		if param == nil {
+			payload = binary.BigEndian.AppendUint32(payload, 0xFFFFFFFF)
+			continue
+		}
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(param)))
+		payload = append(payload, param...)
+	}
+	if resultFormat == TextFormat {
+		payload = binary.BigEndian.AppendUint16(payload, 0)
+	} else {
+		// A single format code applies to every result column.
+		payload = binary.BigEndian.AppendUint16(payload, 1)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(resultFormat))
+	}
+	return appendMessage(nil, 'B', payload)
+}
+
+// buildDescribeMessage describes a portal ('P') or a statement ('S').
+func buildDescribeMessage(kind byte, name string) []byte {
+	payload := []byte{kind}
+	payload = appendCString(payload, name)
+	return appendMessage(nil, 'D', payload)
+}
+
+func buildExecuteMessage(portal string, maxRows int) []byte {
+	var payload []byte
+	payload = appendCString(payload, portal)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(maxRows))
+	return appendMessage(nil, 'E', payload)
+}
+
+func buildSyncMessage() []byte {
+	return appendMessage(nil, 'S', nil)
+}
+
+// encodeParams converts Go values to the text representation PostgreSQL
+// expects in a Bind message.
+func encodeParams(args []interface{}) ([][]byte, error) {
+	params := make([][]byte, len(args))
+	for i, arg := range args {
+		param, err := encodeParam(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parameter $%d: %v", i+1, err)
+		}
+		params[i] = param
+	}
+	return params, nil
+}
+
+func encodeParam(arg interface{}) ([]byte, error) {
+	switch v := arg.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(v), nil
+	case []byte:
+		if v == nil {
+			return nil, nil
+		}
+		// bytea hex format
+		return []byte(`\x` + hex.EncodeToString(v)), nil
+	case bool:
+		return []byte(strconv.FormatBool(v)), nil
+	case int:
+		return []byte(strconv.FormatInt(int64(v), 10)), nil
+	case int8:
+		return []byte(strconv.FormatInt(int64(v), 10)), nil
+	case int16:
+		return []byte(strconv.FormatInt(int64(v), 10)), nil
+	case int32:
+		return []byte(strconv.FormatInt(int64(v), 10)), nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case uint:
+		return []byte(strconv.FormatUint(uint64(v), 10)), nil
+	case uint8:
+		return []byte(strconv.FormatUint(uint64(v), 10)), nil
+	case uint16:
+		return []byte(strconv.FormatUint(uint64(v), 10)), nil
+	case uint32:
+		return []byte(strconv.FormatUint(uint64(v), 10)), nil
+	case uint64:
+		return []byte(strconv.FormatUint(v, 10)), nil
+	case float32:
+		return []byte(strconv.FormatFloat(float64(v), 'g', -1, 32)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	case time.Time:
+		return []byte(v.Format("2006-01-02 15:04:05.999999999Z07:00")), nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", arg)
+	}
+}
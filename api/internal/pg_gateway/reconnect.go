@@ -0,0 +1,247 @@
+package pg_gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"api/internal/backoff"
+)
+
+const (
+	// DefaultMaxRetries is the MaxRetries NewPGClient uses.
+	DefaultMaxRetries = 3
+
+	defaultMinRetryBackoff = 50 * time.Millisecond
+	defaultMaxRetryBackoff = 2 * time.Second
+
+	reconnectMinBackoff = 250 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+	reconnectTimeout    = 10 * time.Second
+)
+
+// withConn checks a connection out of the pool for the duration of fn.
+// Failed dials are retried for any statement since nothing reached the
+// server; if the connection is lost while fn runs, fn is retried on a new
+// connection only when idempotent is set.
+func (p *PGClient) withConn(ctx context.Context, idempotent bool, fn func(c *pgConn) error) error {
+	bo := p.retryBackoff()
+	for {
+		c, err := p.acquire(ctx, bo)
+		if err != nil {
+			return err
+		}
+		err = fn(c)
+		lost := err != nil && c.broken && isConnLost(err)
+		p.pool.release(c)
+		if !lost {
+			return err
+		}
+		p.connectionLost(err)
+		if !idempotent || !p.waitRetry(ctx, bo, err) {
+			return err
+		}
+	}
+}
+
+// acquire checks a connection out of the pool, retrying failed dials.
+func (p *PGClient) acquire(ctx context.Context, bo *backoff.Backoff) (*pgConn, error) {
+	for {
+		c, err := p.pool.acquire(ctx)
+		if err == nil {
+			return c, nil
+		}
+		log.Printf("[POSTGRES] ERROR: Failed to acquire connection: %v", err)
+		if !isDialError(ctx, err) {
+			return nil, err
+		}
+		p.connectionLost(err)
+		if !p.waitRetry(ctx, bo, err) {
+			return nil, err
+		}
+	}
+}
+
+func (p *PGClient) retryBackoff() *backoff.Backoff {
+	bo := &backoff.Backoff{Min: p.config.MinRetryBackoff, Max: p.config.MaxRetryBackoff, Jitter: 0.5}
+	if bo.Min <= 0 {
+		bo.Min = defaultMinRetryBackoff
+	}
+	if bo.Max <= 0 {
+		bo.Max = defaultMaxRetryBackoff
+	}
+	return bo
+}
+
+// waitRetry sleeps before the next attempt. It returns false when the
+// retries are used up or ctx is done.
+func (p *PGClient) waitRetry(ctx context.Context, bo *backoff.Backoff, err error) bool {
+	if bo.Attempt() >= p.config.MaxRetries || ctx.Err() != nil {
+		return false
+	}
+	delay := bo.Next()
+	log.Printf("[POSTGRES] WARNING: %v, retrying in %v (attempt %d/%d)", err, delay, bo.Attempt(), p.config.MaxRetries)
+	p.incrementCounter("postgres_retries_total", map[string]string{})
+	return backoff.Wait(ctx, delay) == nil
+}
+
+// connectionLost marks the server as down, drops the idle connections (they
+// point at the same dead server) and starts redialing in the background.
+func (p *PGClient) connectionLost(err error) {
+	p.mu.Lock()
+	if p.reconnecting {
+		p.mu.Unlock()
+		return
+	}
+	p.reconnecting = true
+	p.mu.Unlock()
+
+	log.Printf("[POSTGRES] ERROR: Connection to %s:%s lost: %v", p.config.Host, p.config.Port, err)
+	p.setConnected(false)
+	if n := p.pool.purgeIdle(); n > 0 {
+		log.Printf("[POSTGRES] Dropped %d idle connections", n)
+	}
+	go p.reconnectLoop()
+}
+
+// reconnectLoop pings the server with jittered exponential backoff until it
+// answers or the client is closed.
+func (p *PGClient) reconnectLoop() {
+	bo := backoff.Backoff{Min: reconnectMinBackoff, Max: reconnectMaxBackoff, Jitter: 0.5}
+	lostAt := time.Now()
+	for {
+		delay := bo.Next()
+		log.Printf("[POSTGRES] Reconnecting in %v (attempt %d)...", delay, bo.Attempt())
+		select {
+		case <-time.After(delay):
+		case <-p.pool.stop:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), reconnectTimeout)
+		err := p.pingServer(ctx)
+		cancel()
+		if errors.Is(err, ErrPoolClosed) {
+			return
+		}
+
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		p.incrementCounter("postgres_reconnect_attempts_total", map[string]string{"result": result})
+		if err != nil {
+			log.Printf("[POSTGRES] WARNING: Reconnect attempt %d failed: %v", bo.Attempt(), err)
+			continue
+		}
+
+		log.Printf("[POSTGRES] Reconnected after %d attempts (down for %v)", bo.Attempt(), time.Since(lostAt))
+		p.mu.Lock()
+		p.reconnecting = false
+		p.mu.Unlock()
+		p.setConnected(true)
+		return
+	}
+}
+
+func (p *PGClient) pingServer(ctx context.Context) error {
+	c, err := p.pool.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.pool.release(c)
+	return c.ping(ctx)
+}
+
+// setConnected records whether the server is reachable and publishes it as
+// postgres_connection_status.
+func (p *PGClient) setConnected(connected bool) {
+	p.mu.Lock()
+	p.connected = connected
+	p.mu.Unlock()
+	p.publishStatus()
+}
+
+// Connected reports whether the server answered the last time it was
+// contacted. It turns false when a connection is lost and true again once a
+// background reconnect succeeds.
+func (p *PGClient) Connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected
+}
+
+func (p *PGClient) publishStatus() {
+	p.mu.Lock()
+	registry, connected := p.metricsRegistry, p.connected
+	p.mu.Unlock()
+	if registry == nil {
+		return
+	}
+	status := 0.0
+	if connected {
+		status = 1
+	}
+	registry.SetGauge("postgres_connection_status", status, map[string]string{})
+}
+
+func (p *PGClient) incrementCounter(name string, labels map[string]string) {
+	p.mu.Lock()
+	registry := p.metricsRegistry
+	p.mu.Unlock()
+	if registry != nil {
+		registry.IncrementCounter(name, labels)
+	}
+}
+
+// isReadOnly reports whether query is a single statement that cannot change
+// anything, so running it again after a lost connection is harmless. It only
+// looks at the leading keyword: a SELECT that calls a function with side
+// effects is the caller's responsibility.
+func isReadOnly(query string) bool {
+	fields := strings.Fields(query)
+	if len(fields) == 0 || strings.Contains(query, ";") {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "SHOW", "VALUES", "TABLE":
+		return true
+	}
+	return false
+}
+
+// isDialError reports whether an acquire error came from opening a new
+// connection, as opposed to the pool itself or the caller's context. Server
+// errors during startup are final, except "the database system is starting
+// up".
+func isDialError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrPoolClosed) || errors.Is(err, ErrPoolExhausted) || errors.Is(err, ErrPoolTimeout) {
+		return false
+	}
+	var pgErr *PGError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "57P03"
+	}
+	return true
+}
+
+// isConnLost reports whether err means the server went away, as opposed to
+// a failed statement or a timeout on a live connection.
+func isConnLost(err error) bool {
+	var pgErr *PGError
+	if errors.As(err, &pgErr) {
+		// admin_shutdown, crash_shutdown, cannot_connect_now
+		return strings.HasPrefix(pgErr.Code, "57P0")
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && !netErr.Timeout()
+}
@@ -0,0 +1,416 @@
+package pg_gateway
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldDescription is one column of a RowDescription message.
+type FieldDescription struct {
+	Name                 string
+	TableOID             uint32
+	TableAttributeNumber uint16
+	DataTypeOID          uint32
+	DataTypeSize         int16
+	TypeModifier         int32
+	Format               int16
+}
+
+func parseRowDescription(payload []byte) ([]FieldDescription, error) {
+	if len(payload) < 2 {
+		return nil, fmt.Errorf("payload too short for RowDescription")
+	}
+	numFields := int(binary.BigEndian.Uint16(payload[0:2]))
+	pos := 2
+	fields := make([]FieldDescription, 0, numFields)
+
+	for i := 0; i < numFields; i++ {
+		end := pos
+		for end < len(payload) && payload[end] != 0 {
+			end++
+		}
+		if end+19 > len(payload) {
+			return nil, fmt.Errorf("not enough data for field %d description", i)
+		}
+		field := FieldDescription{Name: string(payload[pos:end])}
+		pos = end + 1
+		field.TableOID = binary.BigEndian.Uint32(payload[pos:])
+		field.TableAttributeNumber = binary.BigEndian.Uint16(payload[pos+4:])
+		field.DataTypeOID = binary.BigEndian.Uint32(payload[pos+6:])
+		field.DataTypeSize = int16(binary.BigEndian.Uint16(payload[pos+10:]))
+		field.TypeModifier = int32(binary.BigEndian.Uint32(payload[pos+12:]))
+		field.Format = int16(binary.BigEndian.Uint16(payload[pos+16:]))
+		pos += 18
+		fields = append(fields, field)
+	}
+	if pos != len(payload) {
+		return nil, fmt.Errorf("%d trailing bytes after %d field descriptions", len(payload)-pos, numFields)
+	}
+	return fields, nil
+}
+
+// parseDataRow splits a DataRow payload into raw column values. NULL columns
+// are returned as nil slices.
+func parseDataRow(payload []byte) ([][]byte, error) {
+	// DataRow format: int16 (num columns) followed by column data
+	// Each column: int32 (length) + data bytes
+	if len(payload) < 2 {
+		return nil, fmt.Errorf("payload too short for DataRow")
+	}
+
+	numCols := int(binary.BigEndian.Uint16(payload[0:2]))
+	pos := 2
+	values := make([][]byte, 0, numCols)
+
+	for col := 0; col < numCols; col++ {
+		if pos+4 > len(payload) {
+			return nil, fmt.Errorf("not enough data for column %d length", col)
+		}
+		fieldLen := int(int32(binary.BigEndian.Uint32(payload[pos : pos+4])))
+		pos += 4
+
+		if fieldLen < 0 {
+			// NULL value
+			values = append(values, nil)
+			continue
+		}
+		if pos+fieldLen > len(payload) {
+			return nil, fmt.Errorf("not enough data for column %d value (need %d, have %d)", col, fieldLen, len(payload)-pos)
+		}
+		values = append(values, payload[pos:pos+fieldLen:pos+fieldLen])
+		pos += fieldLen
+	}
+	if pos != len(payload) {
+		return nil, fmt.Errorf("%d trailing bytes after %d columns", len(payload)-pos, numCols)
+	}
+
+	return values, nil
+}
+
+// Rows is a fully buffered result set. Column types come from the
+// RowDescription message, so values are decoded into Go types rather than
+// handed back as strings. Iterate with Next and read each row with Scan,
+// ScanStruct or Values.
+type Rows struct {
+	fields     []FieldDescription
+	rows       [][][]byte
+	pos        int
+	current    [][]byte
+	commandTag string
+}
+
+func (r *Rows) FieldDescriptions() []FieldDescription {
+	return r.fields
+}
+
+func (r *Rows) Columns() []string {
+	names := make([]string, len(r.fields))
+	for i, field := range r.fields {
+		names[i] = field.Name
+	}
+	return names
+}
+
+// Len returns the total number of rows in the result.
+func (r *Rows) Len() int {
+	return len(r.rows)
+}
+
+// CommandTag is the CommandComplete tag, e.g. "INSERT 0 1" or "SELECT 5".
+func (r *Rows) CommandTag() string {
+	return r.commandTag
+}
+
+// RowsAffected parses the row count out of the command tag. Commands that
+// do not report one return 0.
+func (r *Rows) RowsAffected() int64 {
+	parts := strings.Fields(r.commandTag)
+	if len(parts) < 2 {
+		return 0
+	}
+	n, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func (r *Rows) Next() bool {
+	if r.pos >= len(r.rows) {
+		r.current = nil
+		return false
+	}
+	r.current = r.rows[r.pos]
+	r.pos++
+	return true
+}
+
+// Close releases the buffered rows. Rows never holds a connection, so Close
+// is optional.
+func (r *Rows) Close() error {
+	r.rows = nil
+	r.current = nil
+	return nil
+}
+
+// Values decodes every column of the current row.
+func (r *Rows) Values() ([]interface{}, error) {
+	if r.current == nil {
+		return nil, fmt.Errorf("Values called without a current row")
+	}
+	values := make([]interface{}, len(r.current))
+	for i, raw := range r.current {
+		value, err := decodeValue(r.fields[i], raw)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Scan copies the current row into dest, one pointer per column. Supported
+// destinations are pointers to strings, integers, floats, bools, time.Time,
+// []byte, json.RawMessage, interface{}, any sql.Scanner, and pointers to
+// those for nullable columns.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.current == nil {
+		return fmt.Errorf("Scan called without a current row")
+	}
+	if len(dest) != len(r.current) {
+		return fmt.Errorf("Scan expected %d destinations, got %d", len(r.current), len(dest))
+	}
+	values, err := r.Values()
+	if err != nil {
+		return err
+	}
+	for i, value := range values {
+		if err := assignValue(dest[i], value); err != nil {
+			return fmt.Errorf("column %q: %w", r.fields[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// ScanStruct copies the current row into the struct dest points to. A column
+// goes to the field whose `db` tag equals its name, or else to the field
+// whose name matches case-insensitively. Fields tagged `db:"-"` and columns
+// without a matching field are skipped.
+func (r *Rows) ScanStruct(dest interface{}) error {
+	if r.current == nil {
+		return fmt.Errorf("ScanStruct called without a current row")
+	}
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ScanStruct expects a pointer to a struct, got %T", dest)
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	values, err := r.Values()
+	if err != nil {
+		return err
+	}
+	for i, field := range r.fields {
+		index := structFieldIndex(t, field.Name)
+		if index < 0 {
+			continue
+		}
+		if err := assignReflect(v.Field(index), values[i]); err != nil {
+			return fmt.Errorf("column %q into field %s: %w", field.Name, t.Field(index).Name, err)
+		}
+	}
+	return nil
+}
+
+func structFieldIndex(t reflect.Type, column string) int {
+	fallback := -1
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		if tag == column {
+			return i
+		}
+		if tag == "" && fallback < 0 && strings.EqualFold(sf.Name, column) {
+			fallback = i
+		}
+	}
+	return fallback
+}
+
+func assignValue(dest interface{}, src interface{}) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(driverValue(src))
+	}
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("destination must be a non-nil pointer, got %T", dest)
+	}
+	return assignReflect(dv.Elem(), src)
+}
+
+func assignReflect(dv reflect.Value, src interface{}) error {
+	if dv.CanAddr() {
+		if scanner, ok := dv.Addr().Interface().(sql.Scanner); ok {
+			return scanner.Scan(driverValue(src))
+		}
+	}
+
+	switch dv.Kind() {
+	case reflect.Ptr:
+		if src == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+		if dv.IsNil() {
+			dv.Set(reflect.New(dv.Type().Elem()))
+		}
+		return assignReflect(dv.Elem(), src)
+	case reflect.Interface:
+		if src == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+		sv := reflect.ValueOf(src)
+		if !sv.Type().AssignableTo(dv.Type()) {
+			return fmt.Errorf("cannot scan %T into %s", src, dv.Type())
+		}
+		dv.Set(sv)
+		return nil
+	}
+
+	if src == nil {
+		return fmt.Errorf("cannot scan NULL into %s", dv.Type())
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dv.Type()) {
+		dv.Set(sv)
+		return nil
+	}
+
+	switch dv.Kind() {
+	case reflect.String:
+		switch v := src.(type) {
+		case string:
+			dv.SetString(v)
+		case []byte:
+			dv.SetString(string(v))
+		case json.RawMessage:
+			dv.SetString(string(v))
+		case int64:
+			dv.SetString(strconv.FormatInt(v, 10))
+		case float64:
+			dv.SetString(strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			dv.SetString(strconv.FormatBool(v))
+		case time.Time:
+			dv.SetString(v.Format(time.RFC3339Nano))
+		default:
+			return fmt.Errorf("cannot scan %T into %s", src, dv.Type())
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := srcInt64(src)
+		if err != nil {
+			return err
+		}
+		if dv.OverflowInt(n) {
+			return fmt.Errorf("value %d overflows %s", n, dv.Type())
+		}
+		dv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := srcInt64(src)
+		if err != nil {
+			return err
+		}
+		if n < 0 || dv.OverflowUint(uint64(n)) {
+			return fmt.Errorf("value %d overflows %s", n, dv.Type())
+		}
+		dv.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch v := src.(type) {
+		case float64:
+			f = v
+		case int64:
+			f = float64(v)
+		case string:
+			var err error
+			if f, err = strconv.ParseFloat(v, 64); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("cannot scan %T into %s", src, dv.Type())
+		}
+		dv.SetFloat(f)
+		return nil
+	case reflect.Bool:
+		switch v := src.(type) {
+		case bool:
+			dv.SetBool(v)
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			dv.SetBool(b)
+		default:
+			return fmt.Errorf("cannot scan %T into %s", src, dv.Type())
+		}
+		return nil
+	case reflect.Slice:
+		if dv.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		switch v := src.(type) {
+		case string:
+			dv.SetBytes([]byte(v))
+			return nil
+		case []byte:
+			dv.SetBytes(append([]byte(nil), v...))
+			return nil
+		case json.RawMessage:
+			dv.SetBytes(append([]byte(nil), v...))
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot scan %T into %s", src, dv.Type())
+}
+
+func srcInt64(src interface{}) (int64, error) {
+	switch v := src.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, fmt.Errorf("value %v is not an integer", v)
+		}
+		return int64(v), nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to an integer", src)
+}
+
+// driverValue narrows a decoded value to the types database/sql scanners
+// expect.
+func driverValue(src interface{}) interface{} {
+	if raw, ok := src.(json.RawMessage); ok {
+		return []byte(raw)
+	}
+	return src
+}
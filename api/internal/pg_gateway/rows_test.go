@@ -0,0 +1,182 @@
+package pg_gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// rowDescription builds a RowDescription payload with one text column per
+// name.
+func rowDescription(names ...string) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(len(names)))
+	for i, name := range names {
+		payload = appendCString(payload, name)
+		payload = binary.BigEndian.AppendUint32(payload, 16384)       // table OID
+		payload = binary.BigEndian.AppendUint16(payload, uint16(i+1)) // attribute number
+		payload = binary.BigEndian.AppendUint32(payload, TextOID)
+		payload = binary.BigEndian.AppendUint16(payload, 0xFFFF) // size -1
+		payload = binary.BigEndian.AppendUint32(payload, 0xFFFFFFFF)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(TextFormat))
+	}
+	return payload
+}
+
+// dataRow builds a DataRow payload; a nil value is sent as NULL.
+func dataRow(values ...[]byte) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(len(values)))
+	for _, v := range values {
+		if v == nil {
+			payload = binary.BigEndian.AppendUint32(payload, 0xFFFFFFFF)
+			continue
+		}
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(v)))
+		payload = append(payload, v...)
+	}
+	return payload
+}
+
+func TestParseRowDescription(t *testing.T) {
+	valid := rowDescription("user_id", "age")
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []string
+		wantErr bool
+	}{
+		{"two columns", valid, []string{"user_id", "age"}, false},
+		{"no columns", rowDescription(), []string{}, false},
+		{"empty payload", nil, nil, true},
+		{"truncated field", valid[:len(valid)-3], nil, true},
+		{"missing field", rowDescription("a")[:2+len("a")+1], nil, true},
+		{"more fields announced", append([]byte{0, 3}, valid[2:]...), nil, true},
+		{"trailing bytes", append(append([]byte(nil), valid...), 0), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := parseRowDescription(tt.payload)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseRowDescription succeeded with %d fields, want error", len(fields))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRowDescription: %v", err)
+			}
+			names := make([]string, len(fields))
+			for i, f := range fields {
+				names[i] = f.Name
+				if f.DataTypeOID != TextOID || f.Format != TextFormat || f.DataTypeSize != -1 || f.TypeModifier != -1 {
+					t.Errorf("field %d = %+v", i, f)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Fatalf("names = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDataRow(t *testing.T) {
+	valid := dataRow([]byte("u1"), nil, []byte(""))
+	tests := []struct {
+		name    string
+		payload []byte
+		want    [][]byte
+		wantErr bool
+	}{
+		{"values, NULL and empty", valid, [][]byte{[]byte("u1"), nil, {}}, false},
+		{"no columns", dataRow(), [][]byte{}, false},
+		{"empty payload", nil, nil, true},
+		{"truncated length", valid[:4], nil, true},
+		{"truncated value", dataRow([]byte("hello"))[:8], nil, true},
+		{"more columns announced", append([]byte{0, 4}, valid[2:]...), nil, true},
+		{"trailing bytes", append(append([]byte(nil), valid...), 'x'), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseDataRow(tt.payload)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseDataRow succeeded with %q, want error", values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDataRow: %v", err)
+			}
+			if len(values) != len(tt.want) {
+				t.Fatalf("got %d values, want %d", len(values), len(tt.want))
+			}
+			for i := range values {
+				if (values[i] == nil) != (tt.want[i] == nil) || !bytes.Equal(values[i], tt.want[i]) {
+					t.Errorf("value %d = %q, want %q", i, values[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// queryConn returns a pgConn that reads the given backend messages and
+// discards what it writes.
+func queryConn(msgs ...[]byte) *pgConn {
+	var in []byte
+	for _, msg := range msgs {
+		in = append(in, msg...)
+	}
+	return &pgConn{
+		reader: NewMessageReader(bytes.NewReader(in), 1<<20),
+		writer: NewMessageWriter(io.Discard),
+		config: &Config{},
+	}
+}
+
+func TestExtendedQueryMalformedRows(t *testing.T) {
+	readyForQuery := appendMessage(nil, 'Z', []byte{'I'})
+	commandComplete := appendMessage(nil, 'C', []byte("SELECT 2\x00"))
+	parseBind := append(appendMessage(nil, '1', nil), appendMessage(nil, '2', nil)...)
+	description := appendMessage(nil, 'T', rowDescription("a", "b"))
+	goodRow := appendMessage(nil, 'D', dataRow([]byte("1"), []byte("2")))
+
+	tests := []struct {
+		name    string
+		msgs    [][]byte
+		wantErr bool
+	}{
+		{"valid", [][]byte{parseBind, description, goodRow, goodRow, commandComplete, readyForQuery}, false},
+		{"malformed RowDescription", [][]byte{parseBind, appendMessage(nil, 'T', []byte{0, 1, 'a'}), goodRow, commandComplete, readyForQuery}, true},
+		{"malformed DataRow", [][]byte{parseBind, description, goodRow, appendMessage(nil, 'D', []byte{0, 2, 0, 0}), commandComplete, readyForQuery}, true},
+		{"column count mismatch", [][]byte{parseBind, description, goodRow, appendMessage(nil, 'D', dataRow([]byte("1"))), commandComplete, readyForQuery}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := queryConn(tt.msgs...)
+			rows, err := c.extendedQuery(context.Background(), "SELECT a, b FROM t", nil, TextFormat)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("extendedQuery: %v", err)
+				}
+				if rows.Len() != 2 || c.broken {
+					t.Fatalf("got %d rows, broken=%v; want 2 rows on a healthy conn", rows.Len(), c.broken)
+				}
+				return
+			}
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) {
+				t.Fatalf("extendedQuery error = %v, want *ProtocolError", err)
+			}
+			if !c.broken {
+				t.Fatal("connection not marked broken")
+			}
+			// The whole response, up to ReadyForQuery, has been consumed.
+			if _, _, err := c.reader.ReadMessage(); err != io.EOF {
+				t.Fatalf("reading past the response: %v, want io.EOF", err)
+			}
+		})
+	}
+}
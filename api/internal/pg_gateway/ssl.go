@@ -0,0 +1,194 @@
+package pg_gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+const sslRequestCode = 80877103
+
+// SSLMode has the meaning of libpq's sslmode.
+type SSLMode string
+
+const (
+	// SSLDisable never uses TLS.
+	SSLDisable SSLMode = "disable"
+	// SSLPrefer uses TLS if the server supports it, without verifying the
+	// certificate, and falls back to plain TCP otherwise. It is the default.
+	SSLPrefer SSLMode = "prefer"
+	// SSLRequire fails if the server does not support TLS. The certificate is
+	// only verified when SSLRootCert is set, as with verify-ca.
+	SSLRequire SSLMode = "require"
+	// SSLVerifyCA also checks that the certificate chains to SSLRootCert (or
+	// the system roots), but not the host name.
+	SSLVerifyCA SSLMode = "verify-ca"
+	// SSLVerifyFull also checks that the certificate matches Host.
+	SSLVerifyFull SSLMode = "verify-full"
+)
+
+// ParseSSLMode validates an sslmode value. An empty string is SSLPrefer.
+func ParseSSLMode(s string) (SSLMode, error) {
+	switch mode := SSLMode(s); mode {
+	case "":
+		return SSLPrefer, nil
+	case SSLDisable, SSLPrefer, SSLRequire, SSLVerifyCA, SSLVerifyFull:
+		return mode, nil
+	}
+	return "", fmt.Errorf("pg_gateway: unknown sslmode %q", s)
+}
+
+// dialServer opens a connection to the server and, unless SSLMode is
+// disable, negotiates TLS with an SSLRequest before anything else is sent.
+// Both regular connections and CancelRequest side connections go through it.
+func dialServer(ctx context.Context, config *Config) (net.Conn, error) {
+	mode, err := ParseSSLMode(string(config.SSLMode))
+	if err != nil {
+		return nil, err
+	}
+
+	addr := config.Host + ":" + config.Port
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if mode == SSLDisable {
+		return conn, nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	tlsConn, err := negotiateSSL(conn, config, mode)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// negotiateSSL sends an SSLRequest and reads the single-byte answer: 'S' to
+// start a TLS handshake, 'N' to continue in plain text. The answer is read
+// straight from the socket, so bytes a server (or anyone in the middle)
+// sends after it can only ever reach the TLS handshake, never the protocol
+// stream.
+func negotiateSSL(conn net.Conn, config *Config, mode SSLMode) (net.Conn, error) {
+	log.Printf("[POSTGRES] Requesting SSL (sslmode=%s)...", mode)
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint32(msg[0:4], 8)
+	binary.BigEndian.PutUint32(msg[4:8], sslRequestCode)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	var answer [1]byte
+	if _, err := io.ReadFull(conn, answer[:]); err != nil {
+		return nil, fmt.Errorf("reading SSLRequest response: %w", err)
+	}
+	switch answer[0] {
+	case 'S':
+	case 'N':
+		if mode != SSLPrefer {
+			return nil, fmt.Errorf("pg_gateway: server does not support SSL (sslmode=%s)", mode)
+		}
+		log.Printf("[POSTGRES] WARNING: Server does not support SSL, continuing without encryption")
+		return conn, nil
+	default:
+		// Servers before 7.0 answer with an ErrorResponse; nothing else is
+		// valid here.
+		return nil, fmt.Errorf("pg_gateway: unexpected SSLRequest response 0x%02x", answer[0])
+	}
+
+	tlsConfig, err := buildTLSConfig(config, mode)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	startHandshake := time.Now()
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("[POSTGRES] ERROR: TLS handshake failed: %v", err)
+		return nil, err
+	}
+	state := tlsConn.ConnectionState()
+	log.Printf("[POSTGRES] TLS established in %v (%s, %s)", time.Since(startHandshake),
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	return tlsConn, nil
+}
+
+// buildTLSConfig builds the client TLS settings for mode from the
+// certificate files in config.
+func buildTLSConfig(config *Config, mode SSLMode) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: config.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if config.SSLCert != "" || config.SSLKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.SSLCert, config.SSLKey)
+		if err != nil {
+			return nil, fmt.Errorf("pg_gateway: loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	var roots *x509.CertPool
+	if config.SSLRootCert != "" {
+		pem, err := os.ReadFile(config.SSLRootCert)
+		if err != nil {
+			return nil, fmt.Errorf("pg_gateway: reading sslrootcert: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("pg_gateway: no certificates found in %s", config.SSLRootCert)
+		}
+	}
+
+	switch {
+	case mode == SSLVerifyFull:
+		tlsConfig.RootCAs = roots
+	case mode == SSLVerifyCA || (mode == SSLRequire && roots != nil):
+		// crypto/tls cannot verify the chain without the host name, so
+		// do it by hand.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(rawCerts, roots)
+		}
+	default:
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// verifyChain checks that the leaf certificate chains to roots (the system
+// roots if nil), ignoring the host name.
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("pg_gateway: server sent no certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
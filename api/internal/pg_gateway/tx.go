@@ -0,0 +1,237 @@
+package pg_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+var (
+	// ErrTxDone is returned by any operation on a transaction or savepoint
+	// that has already been committed or rolled back.
+	ErrTxDone = errors.New("pg_gateway: transaction has already been committed or rolled back")
+
+	// ErrTxAborted is returned for statements issued after an earlier
+	// statement in the same transaction failed, and by Commit of such a
+	// transaction. The server ignores everything but ROLLBACK in that state.
+	ErrTxAborted = errors.New("pg_gateway: current transaction is aborted, commands ignored until end of transaction block")
+)
+
+// IsoLevel is a transaction isolation level as spelled in SQL.
+type IsoLevel string
+
+const (
+	ReadUncommitted IsoLevel = "READ UNCOMMITTED"
+	ReadCommitted   IsoLevel = "READ COMMITTED"
+	RepeatableRead  IsoLevel = "REPEATABLE READ"
+	Serializable    IsoLevel = "SERIALIZABLE"
+)
+
+// TxOptions map to the modes of BEGIN. The zero value uses the server
+// defaults.
+type TxOptions struct {
+	IsoLevel   IsoLevel
+	ReadOnly   bool
+	Deferrable bool
+}
+
+func (o TxOptions) beginStatement() (string, error) {
+	stmt := "BEGIN"
+	switch o.IsoLevel {
+	case "":
+	case ReadUncommitted, ReadCommitted, RepeatableRead, Serializable:
+		stmt += " ISOLATION LEVEL " + string(o.IsoLevel)
+	default:
+		return "", fmt.Errorf("pg_gateway: unknown isolation level %q", o.IsoLevel)
+	}
+	if o.ReadOnly {
+		stmt += " READ ONLY"
+	}
+	if o.Deferrable {
+		stmt += " DEFERRABLE"
+	}
+	return stmt, nil
+}
+
+// Tx is a transaction holding one connection until it is committed or
+// rolled back. Begin on a Tx opens a savepoint and returns a nested Tx whose
+// Commit releases the savepoint and whose Rollback rolls back to it.
+//
+// The transaction state comes from the status byte of each ReadyForQuery
+// message. Once a statement fails the transaction is aborted: further
+// statements return ErrTxAborted without a round trip, and only Rollback (of
+// the transaction or of an enclosing savepoint) recovers.
+type Tx struct {
+	cn        *pgConn
+	release   func()
+	parent    *Tx
+	child     *Tx
+	savepoint string
+	// nextSavepoint is shared by the whole tree so names stay unique.
+	nextSavepoint *int
+	done          bool
+}
+
+// Begin starts a transaction with default options.
+func (p *PGClient) Begin(ctx context.Context) (*Tx, error) {
+	return p.BeginTx(ctx, TxOptions{})
+}
+
+// BeginTx checks a connection out of the pool and starts a transaction on
+// it. The connection goes back to the pool when the transaction ends.
+// A connection lost while sending BEGIN is retried like a failed dial:
+// nothing ran yet.
+func (p *PGClient) BeginTx(ctx context.Context, opts TxOptions) (*Tx, error) {
+	bo := p.retryBackoff()
+	for {
+		c, err := p.acquire(ctx, bo)
+		if err != nil {
+			return nil, err
+		}
+		tx, err := beginTx(ctx, c, opts, func() { p.pool.release(c) })
+		if err == nil {
+			return tx, nil
+		}
+		lost := c.broken && isConnLost(err)
+		p.pool.release(c)
+		if !lost {
+			return nil, err
+		}
+		p.connectionLost(err)
+		if !p.waitRetry(ctx, bo, err) {
+			return nil, err
+		}
+	}
+}
+
+func beginTx(ctx context.Context, c *pgConn, opts TxOptions, release func()) (*Tx, error) {
+	stmt, err := opts.beginStatement()
+	if err != nil {
+		return nil, err
+	}
+	if c.txStatus != 'I' {
+		return nil, fmt.Errorf("pg_gateway: connection #%d is already in a transaction (status '%c')", c.id, c.txStatus)
+	}
+
+	log.Printf("[POSTGRES] Starting transaction on connection #%d: %s", c.id, stmt)
+	if _, err := c.extendedQuery(ctx, stmt, nil, TextFormat); err != nil {
+		return nil, err
+	}
+	return &Tx{cn: c, release: release, nextSavepoint: new(int)}, nil
+}
+
+// Exec runs a statement inside the transaction.
+func (tx *Tx) Exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := tx.query(ctx, query, args, TextFormat)
+	return err
+}
+
+// Query runs a statement inside the transaction and returns its rows.
+func (tx *Tx) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return tx.query(ctx, query, args, TextFormat)
+}
+
+func (tx *Tx) query(ctx context.Context, query string, args []interface{}, format int16) (*Rows, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if tx.cn.txStatus == 'E' {
+		return nil, ErrTxAborted
+	}
+	return tx.cn.extendedQuery(ctx, query, args, format)
+}
+
+// Aborted reports whether a statement has failed and the transaction (or the
+// innermost savepoint) must be rolled back.
+func (tx *Tx) Aborted() bool {
+	return tx.cn.txStatus == 'E'
+}
+
+// Begin opens a savepoint and returns it as a nested transaction.
+func (tx *Tx) Begin(ctx context.Context) (*Tx, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if tx.child != nil && !tx.child.done {
+		return nil, fmt.Errorf("pg_gateway: savepoint %s is still open", tx.child.savepoint)
+	}
+
+	*tx.nextSavepoint++
+	name := fmt.Sprintf("sp_%d", *tx.nextSavepoint)
+	if _, err := tx.query(ctx, "SAVEPOINT "+name, nil, TextFormat); err != nil {
+		return nil, err
+	}
+	child := &Tx{cn: tx.cn, parent: tx, savepoint: name, nextSavepoint: tx.nextSavepoint}
+	tx.child = child
+	return child, nil
+}
+
+// Commit commits the transaction, or releases the savepoint of a nested Tx.
+// Committing an aborted transaction rolls it back and returns ErrTxAborted.
+func (tx *Tx) Commit(ctx context.Context) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	if tx.parent != nil {
+		if tx.cn.txStatus == 'E' {
+			return ErrTxAborted
+		}
+		tx.finish()
+		_, err := tx.cn.extendedQuery(ctx, "RELEASE SAVEPOINT "+tx.savepoint, nil, TextFormat)
+		return err
+	}
+
+	defer tx.end()
+	rows, err := tx.cn.extendedQuery(ctx, "COMMIT", nil, TextFormat)
+	if err != nil {
+		return err
+	}
+	// The server answers COMMIT of a failed transaction with a ROLLBACK tag.
+	if strings.HasPrefix(rows.CommandTag(), "ROLLBACK") {
+		log.Printf("[POSTGRES] WARNING: Transaction on connection #%d was aborted and has been rolled back", tx.cn.id)
+		return ErrTxAborted
+	}
+	log.Printf("[POSTGRES] Transaction on connection #%d committed", tx.cn.id)
+	return nil
+}
+
+// Rollback aborts the transaction, or rolls back to the savepoint of a
+// nested Tx, which also clears an aborted state caused inside it.
+func (tx *Tx) Rollback(ctx context.Context) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	if tx.parent != nil {
+		tx.finish()
+		if _, err := tx.cn.extendedQuery(ctx, "ROLLBACK TO SAVEPOINT "+tx.savepoint, nil, TextFormat); err != nil {
+			return err
+		}
+		_, err := tx.cn.extendedQuery(ctx, "RELEASE SAVEPOINT "+tx.savepoint, nil, TextFormat)
+		return err
+	}
+
+	defer tx.end()
+	if _, err := tx.cn.extendedQuery(ctx, "ROLLBACK", nil, TextFormat); err != nil {
+		return err
+	}
+	log.Printf("[POSTGRES] Transaction on connection #%d rolled back", tx.cn.id)
+	return nil
+}
+
+// finish marks tx and every savepoint nested in it as done.
+func (tx *Tx) finish() {
+	for t := tx; t != nil; t = t.child {
+		t.done = true
+	}
+}
+
+func (tx *Tx) end() {
+	tx.finish()
+	if tx.release != nil {
+		tx.release()
+	}
+}
@@ -0,0 +1,256 @@
+package pg_gateway
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type OIDs from pg_type for the types decodeValue understands. Columns of
+// any other type are returned as a string (text format) or []byte (binary).
+const (
+	BoolOID        = 16
+	ByteaOID       = 17
+	NameOID        = 19
+	Int8OID        = 20
+	Int2OID        = 21
+	Int4OID        = 23
+	TextOID        = 25
+	OIDOID         = 26
+	JSONOID        = 114
+	Float4OID      = 700
+	Float8OID      = 701
+	BPCharOID      = 1042
+	VarcharOID     = 1043
+	DateOID        = 1082
+	TimestampOID   = 1114
+	TimestamptzOID = 1184
+	NumericOID     = 1700
+	UUIDOID        = 2950
+	JSONBOID       = 3802
+)
+
+// Wire formats for parameters and result columns.
+const (
+	TextFormat   int16 = 0
+	BinaryFormat int16 = 1
+)
+
+// postgresEpoch is the zero point of binary date and timestamp values.
+var postgresEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var timestamptzLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07:00:00",
+}
+
+// decodeValue converts one column value to a Go value:
+//
+//	int2, int4, int8, oid         -> int64
+//	float4, float8                -> float64
+//	bool                          -> bool
+//	text, varchar, bpchar, name   -> string
+//	timestamp, timestamptz, date  -> time.Time
+//	numeric                       -> string (exact decimal representation)
+//	uuid                          -> string
+//	json, jsonb                   -> json.RawMessage
+//	bytea                         -> []byte
+//
+// NULL (raw == nil) decodes to nil.
+func decodeValue(field FieldDescription, raw []byte) (interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var (
+		value interface{}
+		err   error
+	)
+	if field.Format == BinaryFormat {
+		value, err = decodeBinary(field.DataTypeOID, raw)
+	} else {
+		value, err = decodeText(field.DataTypeOID, string(raw))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("column %q (type oid %d): %w", field.Name, field.DataTypeOID, err)
+	}
+	return value, nil
+}
+
+func decodeText(oid uint32, s string) (interface{}, error) {
+	switch oid {
+	case Int2OID, Int4OID, Int8OID, OIDOID:
+		return strconv.ParseInt(s, 10, 64)
+	case Float4OID, Float8OID:
+		return strconv.ParseFloat(s, 64)
+	case BoolOID:
+		switch s {
+		case "t", "true":
+			return true, nil
+		case "f", "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean %q", s)
+	case TimestampOID:
+		return time.ParseInLocation("2006-01-02 15:04:05.999999999", s, time.UTC)
+	case TimestamptzOID:
+		var lastErr error
+		for _, layout := range timestamptzLayouts {
+			t, err := time.Parse(layout, s)
+			if err == nil {
+				return t, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	case DateOID:
+		return time.ParseInLocation("2006-01-02", s, time.UTC)
+	case JSONOID, JSONBOID:
+		return json.RawMessage(s), nil
+	case ByteaOID:
+		if strings.HasPrefix(s, `\x`) {
+			return hex.DecodeString(s[2:])
+		}
+		return nil, fmt.Errorf("unsupported bytea output format (set bytea_output = 'hex')")
+	}
+	return s, nil
+}
+
+func decodeBinary(oid uint32, b []byte) (interface{}, error) {
+	switch oid {
+	case Int2OID:
+		if len(b) != 2 {
+			return nil, fmt.Errorf("invalid int2 length %d", len(b))
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case Int4OID:
+		if len(b) != 4 {
+			return nil, fmt.Errorf("invalid int4 length %d", len(b))
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case OIDOID:
+		if len(b) != 4 {
+			return nil, fmt.Errorf("invalid oid length %d", len(b))
+		}
+		return int64(binary.BigEndian.Uint32(b)), nil
+	case Int8OID:
+		if len(b) != 8 {
+			return nil, fmt.Errorf("invalid int8 length %d", len(b))
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case Float4OID:
+		if len(b) != 4 {
+			return nil, fmt.Errorf("invalid float4 length %d", len(b))
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case Float8OID:
+		if len(b) != 8 {
+			return nil, fmt.Errorf("invalid float8 length %d", len(b))
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case BoolOID:
+		if len(b) != 1 {
+			return nil, fmt.Errorf("invalid bool length %d", len(b))
+		}
+		return b[0] != 0, nil
+	case TextOID, VarcharOID, BPCharOID, NameOID:
+		return string(b), nil
+	case TimestampOID, TimestamptzOID:
+		if len(b) != 8 {
+			return nil, fmt.Errorf("invalid timestamp length %d", len(b))
+		}
+		micros := int64(binary.BigEndian.Uint64(b))
+		if micros == math.MaxInt64 || micros == math.MinInt64 {
+			return nil, fmt.Errorf("infinite timestamp cannot be represented as time.Time")
+		}
+		return postgresEpoch.Add(time.Duration(micros) * time.Microsecond), nil
+	case DateOID:
+		if len(b) != 4 {
+			return nil, fmt.Errorf("invalid date length %d", len(b))
+		}
+		days := int32(binary.BigEndian.Uint32(b))
+		if days == math.MaxInt32 || days == math.MinInt32 {
+			return nil, fmt.Errorf("infinite date cannot be represented as time.Time")
+		}
+		return postgresEpoch.AddDate(0, 0, int(days)), nil
+	case NumericOID:
+		return decodeBinaryNumeric(b)
+	case UUIDOID:
+		if len(b) != 16 {
+			return nil, fmt.Errorf("invalid uuid length %d", len(b))
+		}
+		h := hex.EncodeToString(b)
+		return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32], nil
+	case JSONOID:
+		return json.RawMessage(append([]byte(nil), b...)), nil
+	case JSONBOID:
+		// Binary jsonb is a version byte followed by the JSON text.
+		if len(b) == 0 || b[0] != 1 {
+			return nil, fmt.Errorf("unsupported jsonb binary version")
+		}
+		return json.RawMessage(append([]byte(nil), b[1:]...)), nil
+	}
+	return b, nil
+}
+
+// decodeBinaryNumeric renders the base-10000 binary numeric format as a
+// decimal string so no precision is lost.
+func decodeBinaryNumeric(b []byte) (interface{}, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("invalid numeric length %d", len(b))
+	}
+	ndigits := int(binary.BigEndian.Uint16(b[0:2]))
+	weight := int(int16(binary.BigEndian.Uint16(b[2:4])))
+	sign := binary.BigEndian.Uint16(b[4:6])
+	dscale := int(binary.BigEndian.Uint16(b[6:8]))
+	if len(b) != 8+2*ndigits {
+		return nil, fmt.Errorf("invalid numeric length %d for %d digits", len(b), ndigits)
+	}
+
+	switch sign {
+	case 0xC000:
+		return "NaN", nil
+	case 0xD000:
+		return "Infinity", nil
+	case 0xF000:
+		return "-Infinity", nil
+	}
+
+	digits := make([]int, ndigits)
+	for i := range digits {
+		digits[i] = int(binary.BigEndian.Uint16(b[8+2*i:]))
+	}
+	digit := func(i int) int {
+		if i >= 0 && i < len(digits) {
+			return digits[i]
+		}
+		return 0
+	}
+
+	var sb strings.Builder
+	if sign == 0x4000 {
+		sb.WriteByte('-')
+	}
+	if weight < 0 {
+		sb.WriteByte('0')
+	} else {
+		sb.WriteString(strconv.Itoa(digit(0)))
+		for i := 1; i <= weight; i++ {
+			fmt.Fprintf(&sb, "%04d", digit(i))
+		}
+	}
+	if dscale > 0 {
+		var frac strings.Builder
+		for i := weight + 1; frac.Len() < dscale; i++ {
+			fmt.Fprintf(&frac, "%04d", digit(i))
+		}
+		sb.WriteByte('.')
+		sb.WriteString(frac.String()[:dscale])
+	}
+	return sb.String(), nil
+}
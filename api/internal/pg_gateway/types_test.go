@@ -0,0 +1,159 @@
+package pg_gateway
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func be16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func be64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// numeric builds a binary numeric from its header and base-10000 digits.
+func numeric(weight int16, sign, dscale uint16, digits ...uint16) []byte {
+	b := be16(uint16(len(digits)))
+	b = append(b, be16(uint16(weight))...)
+	b = append(b, be16(sign)...)
+	b = append(b, be16(dscale)...)
+	for _, d := range digits {
+		b = append(b, be16(d)...)
+	}
+	return b
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name    string
+		oid     uint32
+		in      string
+		want    interface{}
+		wantErr bool
+	}{
+		{"int4", Int4OID, "-42", int64(-42), false},
+		{"int8", Int8OID, "9223372036854775807", int64(math.MaxInt64), false},
+		{"int2 invalid", Int2OID, "x", nil, true},
+		{"float8", Float8OID, "1.5", 1.5, false},
+		{"float8 invalid", Float8OID, "1.5.1", nil, true},
+		{"bool t", BoolOID, "t", true, false},
+		{"bool false", BoolOID, "false", false, false},
+		{"bool invalid", BoolOID, "yes", nil, true},
+		{"text", TextOID, "hello", "hello", false},
+		{"numeric stays exact", NumericOID, "12345678901234567890.0001", "12345678901234567890.0001", false},
+		{"timestamp", TimestampOID, "2024-02-29 12:34:56.789", time.Date(2024, 2, 29, 12, 34, 56, 789000000, time.UTC), false},
+		{"timestamptz", TimestamptzOID, "2024-02-29 12:34:56+02", time.Date(2024, 2, 29, 10, 34, 56, 0, time.UTC), false},
+		{"timestamptz minutes offset", TimestamptzOID, "2024-02-29 12:34:56+05:30", time.Date(2024, 2, 29, 7, 4, 56, 0, time.UTC), false},
+		{"timestamptz invalid", TimestamptzOID, "yesterday", nil, true},
+		{"date", DateOID, "2024-02-29", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), false},
+		{"json", JSONBOID, `{"a":1}`, json.RawMessage(`{"a":1}`), false},
+		{"bytea hex", ByteaOID, `\xdeadbeef`, []byte{0xde, 0xad, 0xbe, 0xef}, false},
+		{"bytea escape", ByteaOID, `abc`, nil, true},
+		{"bytea bad hex", ByteaOID, `\xzz`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeText(tt.oid, tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeText(%d, %q) = %v, want error", tt.oid, tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeText(%d, %q): %v", tt.oid, tt.in, err)
+			}
+			if tm, ok := tt.want.(time.Time); ok {
+				if !got.(time.Time).Equal(tm) {
+					t.Fatalf("decodeText(%d, %q) = %v, want %v", tt.oid, tt.in, got, tm)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("decodeText(%d, %q) = %#v, want %#v", tt.oid, tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBinary(t *testing.T) {
+	tests := []struct {
+		name    string
+		oid     uint32
+		in      []byte
+		want    interface{}
+		wantErr bool
+	}{
+		{"int2", Int2OID, be16(0xFFFE), int64(-2), false},
+		{"int4", Int4OID, be32(0xFFFFFFFF), int64(-1), false},
+		{"int4 short", Int4OID, be16(1), nil, true},
+		{"oid is unsigned", OIDOID, be32(0xFFFFFFFF), int64(math.MaxUint32), false},
+		{"int8", Int8OID, be64(1 << 40), int64(1 << 40), false},
+		{"int8 short", Int8OID, be32(1), nil, true},
+		{"float4", Float4OID, be32(math.Float32bits(0.5)), 0.5, false},
+		{"float8", Float8OID, be64(math.Float64bits(-2.25)), -2.25, false},
+		{"float8 short", Float8OID, be32(0), nil, true},
+		{"bool", BoolOID, []byte{1}, true, false},
+		{"bool long", BoolOID, []byte{1, 0}, nil, true},
+		{"varchar", VarcharOID, []byte("héllo"), "héllo", false},
+		{"timestamp", TimestampOID, be64(uint64(86400 * 1e6)), time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{"timestamp infinity", TimestamptzOID, be64(math.MaxInt64), nil, true},
+		{"date before epoch", DateOID, be32(uint32(0xFFFFFFFF)), time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC), false},
+		{"date -infinity", DateOID, be32(uint32(0x80000000)), nil, true},
+		{"uuid", UUIDOID, []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}, "12345678-9abc-def0-1234-56789abcdef0", false},
+		{"uuid short", UUIDOID, []byte{1, 2, 3}, nil, true},
+		{"jsonb", JSONBOID, []byte("\x01[1]"), json.RawMessage("[1]"), false},
+		{"jsonb unknown version", JSONBOID, []byte("\x02[1]"), nil, true},
+		{"unknown oid stays raw", 600, []byte{1, 2}, []byte{1, 2}, false},
+		{"numeric 12345.678", NumericOID, numeric(1, 0, 3, 1, 2345, 6780), "12345.678", false},
+		{"numeric -0.0012", NumericOID, numeric(-1, 0x4000, 4, 12), "-0.0012", false},
+		{"numeric 10000", NumericOID, numeric(1, 0, 0, 1), "10000", false},
+		{"numeric zero", NumericOID, numeric(0, 0, 2), "0.00", false},
+		{"numeric NaN", NumericOID, numeric(0, 0xC000, 0), "NaN", false},
+		{"numeric -Infinity", NumericOID, numeric(0, 0xF000, 0), "-Infinity", false},
+		{"numeric short header", NumericOID, []byte{0, 1}, nil, true},
+		{"numeric missing digits", NumericOID, numeric(0, 0, 0, 1)[:9], nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBinary(tt.oid, tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeBinary(%d, %x) = %v, want error", tt.oid, tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeBinary(%d, %x): %v", tt.oid, tt.in, err)
+			}
+			if tm, ok := tt.want.(time.Time); ok {
+				if !got.(time.Time).Equal(tm) {
+					t.Fatalf("decodeBinary(%d, %x) = %v, want %v", tt.oid, tt.in, got, tm)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("decodeBinary(%d, %x) = %#v, want %#v", tt.oid, tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	field := FieldDescription{Name: "age", DataTypeOID: Int4OID, Format: TextFormat}
+	if v, err := decodeValue(field, nil); v != nil || err != nil {
+		t.Fatalf("NULL decoded to %v, %v", v, err)
+	}
+	if v, err := decodeValue(field, []byte("7")); v != int64(7) || err != nil {
+		t.Fatalf("text int4 decoded to %#v, %v", v, err)
+	}
+	field.Format = BinaryFormat
+	if v, err := decodeValue(field, be32(7)); v != int64(7) || err != nil {
+		t.Fatalf("binary int4 decoded to %#v, %v", v, err)
+	}
+	if _, err := decodeValue(field, []byte("7")); err == nil {
+		t.Fatal("binary int4 of 1 byte decoded without error")
+	}
+}
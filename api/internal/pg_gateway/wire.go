@@ -0,0 +1,94 @@
+package pg_gateway
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxMessageSize caps a single backend message. It bounds the memory
+// a misbehaving server, or a desynchronized stream that decodes garbage as a
+// length, can make us allocate.
+const DefaultMaxMessageSize = 128 * 1024 * 1024
+
+// ErrMessageTooLarge is returned by ReadMessage when a message header
+// announces a payload above the configured maximum. The stream cannot be
+// trusted afterwards and the connection must be closed.
+var ErrMessageTooLarge = errors.New("pg_gateway: backend message too large")
+
+// MessageReader frames backend messages on top of one buffered reader that
+// lives as long as the connection, so bytes read ahead for one message are
+// never lost before the next.
+type MessageReader struct {
+	reader         *bufio.Reader
+	maxMessageSize int
+	header         [5]byte
+}
+
+func NewMessageReader(r io.Reader, maxMessageSize int) *MessageReader {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+	return &MessageReader{
+		reader:         bufio.NewReader(r),
+		maxMessageSize: maxMessageSize,
+	}
+}
+
+// ReadMessage reads one backend message: a type byte, an int32 length that
+// includes itself, and the payload. Both parts are read with io.ReadFull so
+// frames split across TCP segments are always read completely.
+func (mr *MessageReader) ReadMessage() (byte, []byte, error) {
+	if _, err := io.ReadFull(mr.reader, mr.header[:]); err != nil {
+		return 0, nil, err
+	}
+	msgType := mr.header[0]
+	msgLen := int(binary.BigEndian.Uint32(mr.header[1:5]))
+	if msgLen < 4 {
+		return 0, nil, fmt.Errorf("invalid message length %d for message type '%c'", msgLen, msgType)
+	}
+	if msgLen-4 > mr.maxMessageSize {
+		return 0, nil, fmt.Errorf("%w: type '%c', %d bytes (limit %d)", ErrMessageTooLarge, msgType, msgLen-4, mr.maxMessageSize)
+	}
+
+	payload := make([]byte, msgLen-4)
+	if _, err := io.ReadFull(mr.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	return msgType, payload, nil
+}
+
+// MessageWriter batches frontend messages in one buffer so a whole
+// Parse/Bind/Execute/Sync sequence goes out in a single write.
+type MessageWriter struct {
+	writer *bufio.Writer
+}
+
+func NewMessageWriter(w io.Writer) *MessageWriter {
+	return &MessageWriter{writer: bufio.NewWriter(w)}
+}
+
+// Write buffers an already framed message.
+func (mw *MessageWriter) Write(msg []byte) error {
+	_, err := mw.writer.Write(msg)
+	return err
+}
+
+// WriteMessage frames payload with msgType and buffers it.
+func (mw *MessageWriter) WriteMessage(msgType byte, payload []byte) error {
+	return mw.Write(appendMessage(nil, msgType, payload))
+}
+
+func (mw *MessageWriter) Flush() error {
+	return mw.writer.Flush()
+}
+
+// Send buffers msg and flushes everything pending.
+func (mw *MessageWriter) Send(msg []byte) error {
+	if err := mw.Write(msg); err != nil {
+		return err
+	}
+	return mw.Flush()
+}
@@ -0,0 +1,227 @@
+package redis_gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// redisConn is a single socket with its own buffered reader and writer. It
+// is owned by exactly one goroutine between Pool.get and Pool.put, so replies
+// are never split across readers.
+type redisConn struct {
+	id         int64
+	conn       net.Conn
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	createdAt  time.Time
+	lastUsedAt time.Time
+	broken     bool
+	protocol   int
+}
+
+func dialRedisConn(ctx context.Context, id int64, config *Config) (*redisConn, error) {
+	log.Printf("[REDIS] Dialing TCP connection #%d to %s...", id, config.Addr)
+	startTime := time.Now()
+
+	dialer := net.Dialer{Timeout: config.DialTimeout}
+	var conn net.Conn
+	var err error
+	if config.TLS != nil {
+		// Certificate files are reloaded on every dial so rotated
+		// certificates are picked up by new connections.
+		tlsConfig, tlsErr := buildTLSConfig(config.TLS, config.Addr)
+		if tlsErr != nil {
+			log.Printf("[REDIS] ERROR: Invalid TLS configuration: %v", tlsErr)
+			return nil, tlsErr
+		}
+		tlsDialer := tls.Dialer{NetDialer: &dialer, Config: tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", config.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", config.Addr)
+	}
+	if err != nil {
+		log.Printf("[REDIS] ERROR: Failed to dial %s: %v", config.Addr, err)
+		return nil, err
+	}
+
+	log.Printf("[REDIS] TCP connection #%d established in %v", id, time.Since(startTime))
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		log.Printf("[REDIS] TLS established on connection #%d (%s, %s, server name %q)", id,
+			tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), state.ServerName)
+	}
+	log.Printf("[REDIS] Local address: %s", conn.LocalAddr())
+	log.Printf("[REDIS] Remote address: %s", conn.RemoteAddr())
+
+	now := time.Now()
+	cn := &redisConn{
+		id:         id,
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		writer:     bufio.NewWriter(conn),
+		createdAt:  now,
+		lastUsedAt: now,
+	}
+
+	cn.setDeadlines(ctx, config.ReadTimeout, config.WriteTimeout)
+	if err := cn.initConn(config); err != nil {
+		log.Printf("[REDIS] ERROR: Connection #%d setup failed: %v", id, err)
+		conn.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+// setDeadlines arms the read and write deadlines for one round trip. A
+// context deadline that comes sooner than the configured timeout wins.
+func (cn *redisConn) setDeadlines(ctx context.Context, readTimeout, writeTimeout time.Duration) {
+	ctxDeadline, hasCtxDeadline := ctx.Deadline()
+	pick := func(timeout time.Duration) time.Time {
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		if hasCtxDeadline && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+			deadline = ctxDeadline
+		}
+		return deadline
+	}
+	cn.conn.SetReadDeadline(pick(readTimeout))
+	cn.conn.SetWriteDeadline(pick(writeTimeout))
+}
+
+// writeCommand buffers one command and flushes it to the socket.
+func (cn *redisConn) writeCommand(args ...interface{}) error {
+	if err := cn.bufferCommand(args); err != nil {
+		return err
+	}
+	return cn.flush()
+}
+
+// bufferCommand encodes a command into the write buffer without flushing,
+// so several commands can go out in a single write.
+func (cn *redisConn) bufferCommand(args []interface{}) error {
+	if len(args) == 0 {
+		return fmt.Errorf("redis: empty command")
+	}
+	return writeArgs(cn.writer, args)
+}
+
+func (cn *redisConn) flush() error {
+	if err := cn.writer.Flush(); err != nil {
+		cn.broken = true
+		return err
+	}
+	return nil
+}
+
+// readReply reads the next reply addressed to the caller, skipping RESP3
+// push frames (invalidations and the like) that arrive in between. Only I/O
+// and protocol errors are returned as errors; they leave the connection
+// unusable.
+func (cn *redisConn) readReply() (interface{}, error) {
+	for {
+		reply, err := readReply(cn.reader)
+		if err != nil {
+			cn.broken = true
+			return nil, err
+		}
+		if push, ok := reply.(*Push); ok {
+			log.Printf("[REDIS] Connection #%d received push frame '%s'", cn.id, push.Kind)
+			continue
+		}
+		return reply, nil
+	}
+}
+
+// do runs one command on this connection and turns an error reply into an
+// error.
+func (cn *redisConn) do(args ...interface{}) (interface{}, error) {
+	if err := cn.writeCommand(args...); err != nil {
+		return nil, err
+	}
+	reply, err := cn.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if redisErr, ok := reply.(*RedisError); ok {
+		return nil, redisErr
+	}
+	return reply, nil
+}
+
+// initConn prepares a fresh connection: HELLO 3 when Protocol is 3 (with
+// AUTH and SETNAME folded in), otherwise AUTH and CLIENT SETNAME as separate
+// commands, then SELECT. It runs for every connection the pool dials, so a
+// reconnect always comes back authenticated and on the configured database.
+// Servers that do not know HELLO fall back to RESP2.
+func (cn *redisConn) initConn(config *Config) error {
+	cn.protocol = 2
+	authed, named := false, false
+
+	if config.Protocol == 3 {
+		args := []interface{}{"HELLO", 3}
+		if config.Password != "" {
+			username := config.Username
+			if username == "" {
+				username = "default"
+			}
+			args = append(args, "AUTH", username, config.Password)
+		}
+		if config.ClientName != "" {
+			args = append(args, "SETNAME", config.ClientName)
+		}
+
+		reply, err := cn.do(args...)
+		var redisErr *RedisError
+		switch {
+		case err == nil:
+			cn.protocol = 3
+			authed, named = true, true
+			info, _ := reply.(map[string]interface{})
+			log.Printf("[REDIS] Connection #%d negotiated RESP3 (server=%v, version=%v, mode=%v)",
+				cn.id, info["server"], info["version"], info["mode"])
+		case errors.As(err, &redisErr) && redisErr.Code == "WRONGPASS":
+			return fmt.Errorf("AUTH failed: %w", err)
+		case errors.As(err, &redisErr):
+			// Unknown command (Redis < 6) or NOPROTO: the AUTH and SETNAME
+			// in HELLO were not applied either.
+			log.Printf("[REDIS] WARNING: HELLO 3 rejected (%v), staying on RESP2", err)
+		default:
+			return err
+		}
+	}
+
+	if !authed && config.Password != "" {
+		args := []interface{}{"AUTH", config.Password}
+		if config.Username != "" {
+			args = []interface{}{"AUTH", config.Username, config.Password}
+		}
+		if _, err := cn.do(args...); err != nil {
+			return fmt.Errorf("AUTH failed: %w", err)
+		}
+		log.Printf("[REDIS] Connection #%d authenticated (user=%q)", cn.id, config.Username)
+	}
+	if !named && config.ClientName != "" {
+		if _, err := cn.do("CLIENT", "SETNAME", config.ClientName); err != nil {
+			return fmt.Errorf("CLIENT SETNAME failed: %w", err)
+		}
+	}
+	if config.DB != 0 {
+		if _, err := cn.do("SELECT", config.DB); err != nil {
+			return fmt.Errorf("SELECT %d failed: %w", config.DB, err)
+		}
+		log.Printf("[REDIS] Connection #%d selected database %d", cn.id, config.DB)
+	}
+	return nil
+}
+
+func (cn *redisConn) close() error {
+	return cn.conn.Close()
+}
@@ -0,0 +1,114 @@
+package redis_gateway
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// hsetArgs builds HSET key field value ... with the fields in sorted order,
+// so the same values always produce the same command.
+func hsetArgs(key string, values map[string]interface{}) []interface{} {
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	args := make([]interface{}, 0, 2*len(values)+2)
+	args = append(args, "HSET", key)
+	for _, field := range fields {
+		args = append(args, field, values[field])
+	}
+	return args
+}
+
+// HSet sets the given fields of the hash at key, creating it if needed, and
+// returns how many fields were added rather than updated. Values are
+// formatted like any other command argument.
+func (r *RedisClient) HSet(ctx context.Context, key string, values map[string]interface{}) (int64, error) {
+	if len(values) == 0 {
+		return 0, fmt.Errorf("redis: HSET of key '%s' needs at least one field", key)
+	}
+	n, err := toInt64(r.Do(ctx, hsetArgs(key, values)...))
+	if err != nil {
+		log.Printf("[REDIS] ERROR: HSET key='%s' failed: %v", key, err)
+		return 0, err
+	}
+	log.Printf("[REDIS] HSET key='%s' set %d fields (%d new)", key, len(values), n)
+	return n, nil
+}
+
+// HGet returns one field of the hash at key, or ErrNil if the key or the
+// field does not exist.
+func (r *RedisClient) HGet(ctx context.Context, key, field string) (string, error) {
+	return toString(r.Do(ctx, "HGET", key, field))
+}
+
+// HGetAll returns every field of the hash at key. A missing key yields an
+// empty map, not an error.
+func (r *RedisClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	fields, err := toStringMap(r.Do(ctx, "HGETALL", key))
+	if err != nil {
+		log.Printf("[REDIS] ERROR: HGETALL key='%s' failed: %v", key, err)
+		return nil, err
+	}
+	return fields, nil
+}
+
+// HMGet returns one entry per field, in order: the value as a string, or nil
+// when the field does not exist.
+func (r *RedisClient) HMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	if len(fields) == 0 {
+		return []interface{}{}, nil
+	}
+	args := make([]interface{}, 0, len(fields)+2)
+	args = append(args, "HMGET", key)
+	for _, field := range fields {
+		args = append(args, field)
+	}
+
+	reply, err := r.Do(ctx, args...)
+	if err != nil {
+		log.Printf("[REDIS] ERROR: HMGET key='%s' failed: %v", key, err)
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(fields) {
+		return nil, fmt.Errorf("redis: unexpected HMGET reply %T", reply)
+	}
+	return values, nil
+}
+
+// HDel removes fields from the hash at key and returns how many existed.
+// Redis deletes the key once its last field is gone.
+func (r *RedisClient) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, 0, len(fields)+2)
+	args = append(args, "HDEL", key)
+	for _, field := range fields {
+		args = append(args, field)
+	}
+	n, err := toInt64(r.Do(ctx, args...))
+	if err != nil {
+		log.Printf("[REDIS] ERROR: HDEL key='%s' failed: %v", key, err)
+		return 0, err
+	}
+	log.Printf("[REDIS] HDEL key='%s' removed %d of %d fields", key, n, len(fields))
+	return n, nil
+}
+
+// HIncrBy adds incr to an integer field of the hash at key and returns the
+// new value. A missing field starts at 0. It is never retried after a lost
+// connection, since the increment may already have been applied.
+func (r *RedisClient) HIncrBy(ctx context.Context, key, field string, incr int64) (int64, error) {
+	n, err := toInt64(r.Do(ctx, "HINCRBY", key, field, incr))
+	if err != nil {
+		log.Printf("[REDIS] ERROR: HINCRBY key='%s' field='%s' failed: %v", key, field, err)
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,205 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrNotSet is returned by SetWithOptions when NX or XX prevented the write.
+var ErrNotSet = errors.New("redis: key not set (NX/XX condition not met)")
+
+// NoTTL is what TTL returns for a key without an expiry.
+const NoTTL time.Duration = -1
+
+// SetOptions maps to the options of SET. Zero values leave an option out;
+// conflicting options (TTL with KeepTTL, NX with XX, ...) are rejected by the
+// server.
+type SetOptions struct {
+	// TTL expires the key after the given duration: EX when it is a whole
+	// number of seconds, PX otherwise, rounded up to the next millisecond.
+	TTL time.Duration
+	// ExpireAt expires the key at a point in time (EXAT).
+	ExpireAt time.Time
+	// KeepTTL keeps the expiry of the key being overwritten.
+	KeepTTL bool
+	// NX only sets a key that does not exist, XX only one that does.
+	NX bool
+	XX bool
+	// Get returns the value the key held before.
+	Get bool
+}
+
+func setArgs(key, value string, opts SetOptions) []interface{} {
+	args := []interface{}{"SET", key, value}
+	switch {
+	case opts.TTL > 0 && opts.TTL%time.Second == 0:
+		args = append(args, "EX", int64(opts.TTL/time.Second))
+	case opts.TTL > 0:
+		args = append(args, "PX", ceilMillis(opts.TTL))
+	}
+	if !opts.ExpireAt.IsZero() {
+		args = append(args, "EXAT", opts.ExpireAt.Unix())
+	}
+	if opts.KeepTTL {
+		args = append(args, "KEEPTTL")
+	}
+	if opts.NX {
+		args = append(args, "NX")
+	}
+	if opts.XX {
+		args = append(args, "XX")
+	}
+	if opts.Get {
+		args = append(args, "GET")
+	}
+	return args
+}
+
+// SetWithOptions runs SET with opts. With Get it returns the previous value,
+// or ErrNil if the key did not exist. Without Get it returns ErrNotSet when
+// NX or XX prevented the write.
+func (r *RedisClient) SetWithOptions(ctx context.Context, key, value string, opts SetOptions) (string, error) {
+	operationStart := time.Now()
+
+	log.Printf("[REDIS] Sending SET key='%s' (%d bytes) with options %+v", key, len(value), opts)
+	reply, err := r.Do(ctx, setArgs(key, value, opts)...)
+	if err != nil {
+		log.Printf("[REDIS] ERROR: SET failed: %v", err)
+		return "", err
+	}
+
+	totalLatency := time.Since(operationStart)
+	r.setLatency("set", totalLatency)
+
+	if opts.Get {
+		previous, err := toString(reply, nil)
+		if err == ErrNil {
+			log.Printf("[REDIS] SET completed, key had no previous value (total latency: %v)", totalLatency)
+		}
+		return previous, err
+	}
+	if reply == nil {
+		log.Printf("[REDIS] SET key='%s' not applied, NX/XX condition not met", key)
+		return "", ErrNotSet
+	}
+	log.Printf("[REDIS] SET operation successful (total latency: %v)", totalLatency)
+	return "", nil
+}
+
+// ceilMillis rounds a positive duration up to whole milliseconds, so that a
+// TTL under 1ms becomes PX 1 instead of PX 0, which the server rejects, or
+// PEXPIRE 0, which deletes the key.
+func ceilMillis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// Expire sets a timeout on key (EXPIRE, or PEXPIRE for sub-second
+// precision, rounded up to the next millisecond). A ttl of zero or less
+// deletes the key, as in Redis. It returns false if the key does not exist.
+func (r *RedisClient) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	args := []interface{}{"PEXPIRE", key, ceilMillis(ttl)}
+	if ttl <= 0 || ttl%time.Second == 0 {
+		args = []interface{}{"EXPIRE", key, int64(ttl / time.Second)}
+	}
+	n, err := toInt64(r.Do(ctx, args...))
+	if err != nil {
+		log.Printf("[REDIS] ERROR: EXPIRE key='%s' failed: %v", key, err)
+		return false, err
+	}
+	log.Printf("[REDIS] EXPIRE key='%s' ttl=%v applied=%v", key, ttl, n == 1)
+	return n == 1, nil
+}
+
+// TTL returns the remaining time to live of key with millisecond precision,
+// NoTTL if it has no expiry, or ErrNil if it does not exist.
+func (r *RedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ms, err := toInt64(r.Do(ctx, "PTTL", key))
+	if err != nil {
+		return 0, err
+	}
+	switch ms {
+	case -2:
+		return 0, ErrNil
+	case -1:
+		return NoTTL, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Persist removes the expiry of key. It returns false if the key does not
+// exist or had no expiry.
+func (r *RedisClient) Persist(ctx context.Context, key string) (bool, error) {
+	n, err := toInt64(r.Do(ctx, "PERSIST", key))
+	if err != nil {
+		log.Printf("[REDIS] ERROR: PERSIST key='%s' failed: %v", key, err)
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Del removes keys and returns how many existed.
+func (r *RedisClient) Del(ctx context.Context, keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	n, err := toInt64(r.Do(ctx, args...))
+	if err != nil {
+		log.Printf("[REDIS] ERROR: DEL of %d keys failed: %v", len(keys), err)
+		return 0, err
+	}
+	log.Printf("[REDIS] DEL removed %d of %d keys", n, len(keys))
+	return n, nil
+}
+
+// Exists returns how many of keys exist.
+func (r *RedisClient) Exists(ctx context.Context, keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "EXISTS")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return toInt64(r.Do(ctx, args...))
+}
+
+// MSet sets every key in values in one atomic command.
+func (r *RedisClient) MSet(ctx context.Context, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, 2*len(values)+1)
+	args = append(args, "MSET")
+	for key, value := range values {
+		args = append(args, key, value)
+	}
+	if _, err := r.Do(ctx, args...); err != nil {
+		log.Printf("[REDIS] ERROR: MSET of %d keys failed: %v", len(values), err)
+		return err
+	}
+	log.Printf("[REDIS] MSET stored %d keys", len(values))
+	return nil
+}
+
+// GetContext is Get bound to ctx. It returns ErrNil when the key does not
+// exist.
+func (r *RedisClient) GetContext(ctx context.Context, key string) (string, error) {
+	return toString(r.Do(ctx, "GET", key))
+}
+
+func (r *RedisClient) setLatency(operation string, latency time.Duration) {
+	r.mu.Lock()
+	registry := r.metricsRegistry
+	r.mu.Unlock()
+	if registry != nil {
+		registry.SetGauge("redis_operation_latency_seconds", latency.Seconds(), map[string]string{"operation": operation})
+	}
+}
@@ -0,0 +1,26 @@
+package redis_gateway
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetArgsTTL(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want []interface{}
+	}{
+		{0, []interface{}{"SET", "k", "v"}},
+		{2 * time.Second, []interface{}{"SET", "k", "v", "EX", int64(2)}},
+		{1500 * time.Millisecond, []interface{}{"SET", "k", "v", "PX", int64(1500)}},
+		{time.Microsecond, []interface{}{"SET", "k", "v", "PX", int64(1)}},
+		{time.Millisecond + time.Nanosecond, []interface{}{"SET", "k", "v", "PX", int64(2)}},
+	}
+	for _, tt := range tests {
+		got := setArgs("k", "v", SetOptions{TTL: tt.ttl})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("setArgs with TTL %v = %v, want %v", tt.ttl, got, tt.want)
+		}
+	}
+}
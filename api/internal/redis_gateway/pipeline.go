@@ -0,0 +1,183 @@
+package redis_gateway
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Cmd is a command queued on a Pipeline. Its reply, or the error for that
+// command alone, is filled in by Exec.
+type Cmd struct {
+	args []interface{}
+	val  interface{}
+	err  error
+}
+
+func (c *Cmd) Args() []interface{} {
+	return c.args
+}
+
+func (c *Cmd) Val() interface{} {
+	return c.val
+}
+
+func (c *Cmd) Err() error {
+	return c.err
+}
+
+func (c *Cmd) Result() (interface{}, error) {
+	return c.val, c.err
+}
+
+// String returns the reply as a string, or ErrNil for a null reply.
+func (c *Cmd) String() (string, error) {
+	return toString(c.val, c.err)
+}
+
+func (c *Cmd) Int64() (int64, error) {
+	return toInt64(c.val, c.err)
+}
+
+// StringMap returns a key/value reply such as the one of HGETALL.
+func (c *Cmd) StringMap() (map[string]string, error) {
+	return toStringMap(c.val, c.err)
+}
+
+// Pipeline queues commands and sends them to the server in a single write
+// when Exec is called. Replies are read back in order and matched to their
+// commands, so one round trip covers the whole batch. A Pipeline is not safe
+// for concurrent use.
+type Pipeline struct {
+	client *RedisClient
+	exec   func(ctx context.Context, cmds []*Cmd) error
+	cmds   []*Cmd
+}
+
+func (r *RedisClient) Pipeline() *Pipeline {
+	return &Pipeline{
+		client: r,
+		exec:   r.processPipeline,
+	}
+}
+
+// Do queues an arbitrary command.
+func (p *Pipeline) Do(args ...interface{}) *Cmd {
+	cmd := &Cmd{args: args}
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *Pipeline) Set(key, value string) *Cmd {
+	return p.Do("SET", key, value)
+}
+
+func (p *Pipeline) SetWithOptions(key, value string, opts SetOptions) *Cmd {
+	return p.Do(setArgs(key, value, opts)...)
+}
+
+func (p *Pipeline) Get(key string) *Cmd {
+	return p.Do("GET", key)
+}
+
+func (p *Pipeline) HSet(key string, values map[string]interface{}) *Cmd {
+	return p.Do(hsetArgs(key, values)...)
+}
+
+func (p *Pipeline) HGetAll(key string) *Cmd {
+	return p.Do("HGETALL", key)
+}
+
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Discard drops all queued commands.
+func (p *Pipeline) Discard() {
+	p.cmds = nil
+}
+
+// Exec sends the queued commands and waits for every reply. It returns the
+// commands in queue order together with the first error encountered, which
+// may be a per-command error reply; inspect each Cmd for the rest. The
+// pipeline is empty afterwards and can be reused.
+func (p *Pipeline) Exec(ctx context.Context) ([]*Cmd, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	if len(cmds) == 0 {
+		return cmds, nil
+	}
+
+	if err := p.exec(ctx, cmds); err != nil {
+		return cmds, err
+	}
+	return cmds, firstCmdError(cmds)
+}
+
+func (r *RedisClient) processPipeline(ctx context.Context, cmds []*Cmd) error {
+	operationStart := time.Now()
+
+	err := r.withConn(ctx, allIdempotent(cmds), func(cn *redisConn) error {
+		return cn.pipeline(cmds)
+	})
+	if err != nil {
+		setCmdsError(cmds, err)
+		log.Printf("[REDIS] ERROR: Pipeline of %d commands failed: %v", len(cmds), err)
+		return err
+	}
+
+	totalLatency := time.Since(operationStart)
+	log.Printf("[REDIS] Pipeline of %d commands completed in %v", len(cmds), totalLatency)
+	if r.metricsRegistry != nil {
+		r.metricsRegistry.SetGauge("redis_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "pipeline"})
+	}
+	return nil
+}
+
+// pipeline writes every command into the buffer, flushes once and reads the
+// replies back in order. Commands that fail to encode are not sent and get
+// their own error. An I/O error is returned and leaves the connection
+// broken.
+func (cn *redisConn) pipeline(cmds []*Cmd) error {
+	sent := make([]*Cmd, 0, len(cmds))
+	for _, cmd := range cmds {
+		if err := cn.bufferCommand(cmd.args); err != nil {
+			cmd.err = err
+			continue
+		}
+		sent = append(sent, cmd)
+	}
+	if err := cn.flush(); err != nil {
+		return err
+	}
+
+	for _, cmd := range sent {
+		reply, err := cn.readReply()
+		if err != nil {
+			return err
+		}
+		if redisErr, ok := reply.(*RedisError); ok {
+			cmd.err = redisErr
+			continue
+		}
+		cmd.val = reply
+	}
+	return nil
+}
+
+func setCmdsError(cmds []*Cmd, err error) {
+	for _, cmd := range cmds {
+		if cmd.err == nil && cmd.val == nil {
+			cmd.err = err
+		}
+	}
+}
+
+func firstCmdError(cmds []*Cmd) error {
+	for _, cmd := range cmds {
+		if cmd.err != nil {
+			return cmd.err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPoolClosed  = errors.New("redis_gateway: connection pool is closed")
+	ErrPoolTimeout = errors.New("redis_gateway: connection pool exhausted, timed out waiting for a connection")
+)
+
+// PoolConfig sizes the connection pool behind RedisClient.
+type PoolConfig struct {
+	// MaxConns caps the number of open connections, idle or in use.
+	MaxConns int
+	// MinIdleConns connections are kept open even when nothing uses them.
+	MinIdleConns int
+	// PoolTimeout is how long a caller waits for a connection once MaxConns
+	// are in use before giving up with ErrPoolTimeout.
+	PoolTimeout time.Duration
+	// IdleTimeout closes connections idle for longer. Zero disables it.
+	IdleTimeout time.Duration
+	// MaxLifetime closes connections older than this. Zero disables it.
+	MaxLifetime time.Duration
+	// ReapInterval is how often idle connections are reaped and pool stats
+	// are published.
+	ReapInterval time.Duration
+}
+
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxConns:     20,
+		MinIdleConns: 2,
+		PoolTimeout:  5 * time.Second,
+		IdleTimeout:  5 * time.Minute,
+		ReapInterval: 10 * time.Second,
+	}
+}
+
+// PoolStats is a point-in-time view of the pool.
+type PoolStats struct {
+	MaxConns     int
+	TotalConns   int
+	IdleConns    int
+	InUse        int
+	Hits         int64
+	Misses       int64
+	Waits        int64
+	WaitDuration time.Duration
+	Timeouts     int64
+	StaleConns   int64
+}
+
+// Pool limits concurrent connections with a semaphore sized MaxConns and
+// keeps released connections on an idle stack for reuse.
+type Pool struct {
+	config PoolConfig
+	dial   func(ctx context.Context, id int64) (*redisConn, error)
+	queue  chan struct{}
+
+	mu         sync.Mutex
+	idle       []*redisConn
+	numOpen    int
+	nextID     int64
+	closed     bool
+	stop       chan struct{}
+	hits       int64
+	misses     int64
+	waits      int64
+	waitTime   time.Duration
+	timeouts   int64
+	staleConns int64
+
+	metricsRegistry interface {
+		SetGauge(name string, value float64, labels map[string]string)
+		SetCounter(name string, value float64, labels map[string]string)
+	}
+}
+
+func NewPool(dial func(ctx context.Context, id int64) (*redisConn, error), config PoolConfig) *Pool {
+	if config.MaxConns <= 0 {
+		config.MaxConns = 1
+	}
+	if config.MinIdleConns > config.MaxConns {
+		config.MinIdleConns = config.MaxConns
+	}
+	if config.ReapInterval <= 0 {
+		config.ReapInterval = DefaultPoolConfig().ReapInterval
+	}
+
+	p := &Pool{
+		config: config,
+		dial:   dial,
+		queue:  make(chan struct{}, config.MaxConns),
+		stop:   make(chan struct{}),
+	}
+	go p.reapLoop()
+	return p
+}
+
+func (p *Pool) SetMetricsRegistry(registry interface {
+	SetGauge(name string, value float64, labels map[string]string)
+	SetCounter(name string, value float64, labels map[string]string)
+}) {
+	p.mu.Lock()
+	p.metricsRegistry = registry
+	p.mu.Unlock()
+	p.publishStats()
+}
+
+// warmUp opens MinIdleConns connections, and at least one, so that an
+// unreachable server is reported at startup.
+func (p *Pool) warmUp(ctx context.Context) error {
+	want := p.config.MinIdleConns
+	if want < 1 {
+		want = 1
+	}
+	conns := make([]*redisConn, 0, want)
+	defer func() {
+		for _, cn := range conns {
+			p.put(cn)
+		}
+	}()
+	for i := 0; i < want; i++ {
+		cn, err := p.get(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, cn)
+	}
+	return nil
+}
+
+func (p *Pool) get(ctx context.Context) (*redisConn, error) {
+	if err := p.waitTurn(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.queue
+		return nil, ErrPoolClosed
+	}
+	for len(p.idle) > 0 {
+		cn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.isStale(cn, time.Now()) {
+			p.staleConns++
+			p.numOpen--
+			go cn.close()
+			continue
+		}
+		p.hits++
+		p.mu.Unlock()
+		return cn, nil
+	}
+	p.misses++
+	p.numOpen++
+	p.nextID++
+	id := p.nextID
+	p.mu.Unlock()
+
+	cn, err := p.dial(ctx, id)
+	if err != nil {
+		p.mu.Lock()
+		p.numOpen--
+		p.mu.Unlock()
+		<-p.queue
+		return nil, err
+	}
+	return cn, nil
+}
+
+// waitTurn takes a slot from the semaphore, waiting up to PoolTimeout when
+// every connection is busy.
+func (p *Pool) waitTurn(ctx context.Context) error {
+	select {
+	case p.queue <- struct{}{}:
+		return nil
+	default:
+	}
+
+	p.mu.Lock()
+	p.waits++
+	p.mu.Unlock()
+	waitStart := time.Now()
+	defer func() {
+		p.mu.Lock()
+		p.waitTime += time.Since(waitStart)
+		p.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if p.config.PoolTimeout > 0 {
+		timer := time.NewTimer(p.config.PoolTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p.queue <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		p.mu.Lock()
+		p.timeouts++
+		p.mu.Unlock()
+		log.Printf("[REDIS] WARNING: Pool exhausted, no connection available after %v", p.config.PoolTimeout)
+		return ErrPoolTimeout
+	}
+}
+
+func (p *Pool) put(cn *redisConn) {
+	cn.lastUsedAt = time.Now()
+
+	p.mu.Lock()
+	if p.closed || cn.broken || p.isStale(cn, cn.lastUsedAt) {
+		p.numOpen--
+		p.mu.Unlock()
+		if cn.broken {
+			log.Printf("[REDIS] Discarding broken connection #%d", cn.id)
+		}
+		cn.close()
+		<-p.queue
+		return
+	}
+	p.idle = append(p.idle, cn)
+	p.mu.Unlock()
+	<-p.queue
+}
+
+func (p *Pool) isStale(cn *redisConn, now time.Time) bool {
+	if p.config.MaxLifetime > 0 && now.Sub(cn.createdAt) > p.config.MaxLifetime {
+		return true
+	}
+	return p.config.IdleTimeout > 0 && now.Sub(cn.lastUsedAt) > p.config.IdleTimeout
+}
+
+// purgeIdle closes every idle connection and returns how many there were.
+// It is called when the server went away: the idle connections are just as
+// dead, and handing them out would fail one caller each.
+func (p *Pool) purgeIdle() int {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.numOpen -= len(idle)
+	p.mu.Unlock()
+
+	for _, cn := range idle {
+		cn.close()
+	}
+	return len(idle)
+}
+
+func (p *Pool) reapLoop() {
+	ticker := time.NewTicker(p.config.ReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.reap()
+			p.publishStats()
+		}
+	}
+}
+
+// reap drops stale idle connections and tops the idle stack back up to
+// MinIdleConns.
+func (p *Pool) reap() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	kept := p.idle[:0]
+	for _, cn := range p.idle {
+		if p.isStale(cn, now) {
+			p.staleConns++
+			p.numOpen--
+			go cn.close()
+			continue
+		}
+		kept = append(kept, cn)
+	}
+	for i := len(kept); i < len(p.idle); i++ {
+		p.idle[i] = nil
+	}
+	p.idle = kept
+	missing := p.config.MinIdleConns - len(p.idle)
+	p.mu.Unlock()
+
+	for i := 0; i < missing; i++ {
+		if err := p.addIdle(); err != nil {
+			log.Printf("[REDIS] WARNING: Pool could not replenish idle connections: %v", err)
+			return
+		}
+	}
+}
+
+// addIdle dials one connection straight onto the idle stack. It bypasses
+// get and put so that refilling does not count as a hit, miss or wait, and
+// does nothing once MaxConns connections are open.
+func (p *Pool) addIdle() error {
+	p.mu.Lock()
+	if p.closed || p.numOpen >= p.config.MaxConns {
+		p.mu.Unlock()
+		return nil
+	}
+	p.numOpen++
+	p.nextID++
+	id := p.nextID
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.ReapInterval)
+	cn, err := p.dial(ctx, id)
+	cancel()
+	if err != nil {
+		p.mu.Lock()
+		p.numOpen--
+		p.mu.Unlock()
+		return err
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.numOpen--
+		p.mu.Unlock()
+		cn.close()
+		return nil
+	}
+	p.idle = append(p.idle, cn)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return PoolStats{
+		MaxConns:     p.config.MaxConns,
+		TotalConns:   p.numOpen,
+		IdleConns:    len(p.idle),
+		InUse:        p.numOpen - len(p.idle),
+		Hits:         p.hits,
+		Misses:       p.misses,
+		Waits:        p.waits,
+		WaitDuration: p.waitTime,
+		Timeouts:     p.timeouts,
+		StaleConns:   p.staleConns,
+	}
+}
+
+func (p *Pool) publishStats() {
+	p.mu.Lock()
+	registry := p.metricsRegistry
+	p.mu.Unlock()
+	if registry == nil {
+		return
+	}
+
+	stats := p.Stats()
+	registry.SetGauge("redis_pool_max_connections", float64(stats.MaxConns), map[string]string{})
+	registry.SetGauge("redis_pool_connections", float64(stats.TotalConns), map[string]string{"state": "open"})
+	registry.SetGauge("redis_pool_connections", float64(stats.InUse), map[string]string{"state": "in_use"})
+	registry.SetGauge("redis_pool_connections", float64(stats.IdleConns), map[string]string{"state": "idle"})
+	registry.SetCounter("redis_pool_hits_total", float64(stats.Hits), map[string]string{})
+	registry.SetCounter("redis_pool_misses_total", float64(stats.Misses), map[string]string{})
+	registry.SetCounter("redis_pool_exhausted_waits_total", float64(stats.Waits), map[string]string{})
+	registry.SetCounter("redis_pool_wait_duration_seconds_total", stats.WaitDuration.Seconds(), map[string]string{})
+	registry.SetCounter("redis_pool_timeouts_total", float64(stats.Timeouts), map[string]string{})
+	registry.SetCounter("redis_pool_stale_connections_total", float64(stats.StaleConns), map[string]string{})
+}
+
+// Close closes idle connections and stops the reaper. Connections in use
+// are closed when they are put back.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	idle := p.idle
+	p.idle = nil
+	p.numOpen -= len(idle)
+	p.mu.Unlock()
+
+	for _, cn := range idle {
+		cn.close()
+	}
+}
@@ -0,0 +1,535 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api/internal/backoff"
+)
+
+// ErrPubSubClosed is returned by the subscribe methods after Close.
+var ErrPubSubClosed = errors.New("redis_gateway: pubsub is closed")
+
+const (
+	// DefaultMessageBuffer is the capacity of PubSub.Channel.
+	DefaultMessageBuffer = 256
+	// DefaultDeliveryTimeout is how long a message waits for room in a full
+	// PubSub.Channel before it is dropped.
+	DefaultDeliveryTimeout = 5 * time.Second
+
+	pubsubMinBackoff = 500 * time.Millisecond
+	pubsubMaxBackoff = 30 * time.Second
+)
+
+// pubsubConnID numbers pub/sub connections. They live outside the pool.
+var pubsubConnID int64
+
+// PubSubConfig controls how a PubSub hands messages to its consumer.
+type PubSubConfig struct {
+	// BufferSize is the capacity of PubSub.Channel.
+	BufferSize int
+	// DeliveryTimeout is how long a message waits for room in a full
+	// buffer before it is dropped. While it waits the socket is not read,
+	// so the server holds back further messages; past its
+	// client-output-buffer-limit for pubsub it disconnects and the PubSub
+	// reconnects. Zero waits forever.
+	DeliveryTimeout time.Duration
+}
+
+func DefaultPubSubConfig() PubSubConfig {
+	return PubSubConfig{
+		BufferSize:      DefaultMessageBuffer,
+		DeliveryTimeout: DefaultDeliveryTimeout,
+	}
+}
+
+// Message is a message published on a subscribed channel. Pattern is the
+// PSUBSCRIBE pattern it matched, empty for SUBSCRIBE.
+type Message struct {
+	Channel string
+	Pattern string
+	Payload string
+}
+
+// PubSub holds a dedicated connection in subscribed state and delivers
+// messages on a channel. If the connection drops it reconnects with backoff
+// and subscribes again to every channel and pattern. Messages published
+// while it was disconnected are lost; a nil *Message is delivered after each
+// reconnect so consumers can resynchronize.
+//
+//	ps := client.NewPubSub()
+//	defer ps.Close()
+//	ps.Subscribe(ctx, "users:events")
+//	for msg := range ps.Channel() {
+//		if msg == nil {
+//			// Reconnected; messages may have been missed.
+//			continue
+//		}
+//		...
+//	}
+type PubSub struct {
+	config   Config
+	messages chan *Message
+	done     chan struct{}
+	stopped  chan struct{}
+	dropped  uint64
+
+	metricsRegistry interface {
+		IncrementCounter(name string, labels map[string]string)
+	}
+
+	// cmdMu keeps one command in flight at a time, and keeps commands out
+	// while a new connection is being subscribed.
+	cmdMu sync.Mutex
+
+	mu       sync.Mutex
+	cn       *redisConn
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	// pending holds one slot per command sent on cn, in order. The read loop
+	// answers the oldest once every channel in it has been confirmed.
+	pending []*pubsubReply
+	closed  bool
+}
+
+type pubsubReply struct {
+	remaining int
+	err       chan error
+}
+
+// NewPubSub starts a subscriber for config in the background. Pool and
+// retry settings are ignored.
+func NewPubSub(config Config) *PubSub {
+	return newPubSub(config, nil)
+}
+
+// newPubSub sets everything the read loop looks at, the registry included,
+// before starting it.
+func newPubSub(config Config, registry interface {
+	IncrementCounter(name string, labels map[string]string)
+}) *PubSub {
+	if config.PubSub.BufferSize <= 0 {
+		config.PubSub.BufferSize = DefaultMessageBuffer
+	}
+	ps := &PubSub{
+		config:   config,
+		messages: make(chan *Message, config.PubSub.BufferSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+
+		metricsRegistry: registry,
+	}
+	log.Printf("[REDIS] Starting pub/sub connection to %s (buffer=%d, delivery_timeout=%v)",
+		config.Addr, config.PubSub.BufferSize, config.PubSub.DeliveryTimeout)
+	go ps.run()
+	return ps
+}
+
+// NewPubSub starts a subscriber with the client's configuration and metrics
+// registry.
+func (r *RedisClient) NewPubSub() *PubSub {
+	r.mu.Lock()
+	registry := r.metricsRegistry
+	r.mu.Unlock()
+	return newPubSub(r.config, registry)
+}
+
+// Publish posts message on channel and returns the number of subscribers
+// that received it.
+func (r *RedisClient) Publish(ctx context.Context, channel, message string) (int64, error) {
+	log.Printf("[REDIS] Sending PUBLISH channel='%s' (%d bytes)", channel, len(message))
+	receivers, err := toInt64(r.Do(ctx, "PUBLISH", channel, message))
+	if err != nil {
+		log.Printf("[REDIS] ERROR: PUBLISH failed: %v", err)
+		return 0, err
+	}
+	log.Printf("[REDIS] PUBLISH on '%s' reached %d subscribers", channel, receivers)
+	return receivers, nil
+}
+
+// Channel is closed once the PubSub is closed.
+func (ps *PubSub) Channel() <-chan *Message {
+	return ps.messages
+}
+
+// Dropped is the number of messages dropped because the consumer did not
+// keep up.
+func (ps *PubSub) Dropped() uint64 {
+	return atomic.LoadUint64(&ps.dropped)
+}
+
+// Subscribe subscribes to channels. If the PubSub is between connections
+// they are recorded and subscribed to as soon as it reconnects.
+func (ps *PubSub) Subscribe(ctx context.Context, channels ...string) error {
+	return ps.command(ctx, "SUBSCRIBE", channels)
+}
+
+// PSubscribe subscribes to glob-style patterns.
+func (ps *PubSub) PSubscribe(ctx context.Context, patterns ...string) error {
+	return ps.command(ctx, "PSUBSCRIBE", patterns)
+}
+
+func (ps *PubSub) Unsubscribe(ctx context.Context, channels ...string) error {
+	return ps.command(ctx, "UNSUBSCRIBE", channels)
+}
+
+func (ps *PubSub) PUnsubscribe(ctx context.Context, patterns ...string) error {
+	return ps.command(ctx, "PUNSUBSCRIBE", patterns)
+}
+
+func (ps *PubSub) command(ctx context.Context, name string, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("redis_gateway: %s needs at least one name", name)
+	}
+	ps.cmdMu.Lock()
+	defer ps.cmdMu.Unlock()
+
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return ErrPubSubClosed
+	}
+	for _, n := range names {
+		switch name {
+		case "SUBSCRIBE":
+			ps.channels[n] = struct{}{}
+		case "PSUBSCRIBE":
+			ps.patterns[n] = struct{}{}
+		case "UNSUBSCRIBE":
+			delete(ps.channels, n)
+		case "PUNSUBSCRIBE":
+			delete(ps.patterns, n)
+		}
+	}
+	cn := ps.cn
+	if cn == nil {
+		ps.mu.Unlock()
+		log.Printf("[REDIS] Pub/sub not connected, %s %v deferred until reconnect", name, names)
+		return nil
+	}
+	reply := &pubsubReply{remaining: len(names), err: make(chan error, 1)}
+	ps.pending = append(ps.pending, reply)
+	err := ps.send(cn, name, names)
+	ps.mu.Unlock()
+	if err != nil {
+		// The read loop sees the broken socket too and answers reply.
+		log.Printf("[REDIS] ERROR: Pub/sub failed to send %s %v: %v", name, names, err)
+	} else {
+		log.Printf("[REDIS] Pub/sub sent %s %v", name, names)
+	}
+
+	select {
+	case err := <-reply.err:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ps.done:
+		return ErrPubSubClosed
+	}
+}
+
+func (ps *PubSub) send(cn *redisConn, name string, names []string) error {
+	args := make([]interface{}, 0, len(names)+1)
+	args = append(args, name)
+	for _, n := range names {
+		args = append(args, n)
+	}
+	if ps.config.WriteTimeout > 0 {
+		cn.conn.SetWriteDeadline(time.Now().Add(ps.config.WriteTimeout))
+	}
+	return cn.writeCommand(args...)
+}
+
+// Close stops the PubSub, closes its connection and then Channel.
+func (ps *PubSub) Close() error {
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return nil
+	}
+	ps.closed = true
+	close(ps.done)
+	cn := ps.cn
+	ps.mu.Unlock()
+
+	log.Printf("[REDIS] Closing pub/sub connection...")
+	if cn != nil {
+		// Wake the read loop; it closes the connection on its way out.
+		cn.conn.SetReadDeadline(time.Now())
+	}
+	<-ps.stopped
+	log.Printf("[REDIS] Pub/sub closed (%d messages dropped)", ps.Dropped())
+	return nil
+}
+
+func (ps *PubSub) run() {
+	defer close(ps.stopped)
+	defer close(ps.messages)
+
+	bo := backoff.Backoff{Min: pubsubMinBackoff, Max: pubsubMaxBackoff, Jitter: 0.5}
+	connected := false
+	for {
+		cn, err := ps.connect()
+		if err == nil {
+			bo.Reset()
+			if connected && !ps.deliver(nil) {
+				return
+			}
+			connected = true
+			err = ps.readLoop(cn)
+			ps.disconnect(cn, err)
+		}
+		if ps.isClosed() {
+			return
+		}
+
+		delay := bo.Next()
+		log.Printf("[REDIS] WARNING: Pub/sub connection lost: %v (reconnecting in %v)", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ps.done:
+			return
+		}
+	}
+}
+
+// connect opens a connection and subscribes it to every channel and pattern
+// before publishing it. cmdMu is held meanwhile so a Subscribe racing with
+// the reconnect either lands in the snapshot or waits for the new
+// connection.
+func (ps *PubSub) connect() (*redisConn, error) {
+	id := atomic.AddInt64(&pubsubConnID, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), ps.config.DialTimeout+ps.config.ReadTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-ps.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	cn, err := dialRedisConn(ctx, id, &ps.config)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.cmdMu.Lock()
+	defer ps.cmdMu.Unlock()
+	ps.mu.Lock()
+	channels := sortedKeys(ps.channels)
+	patterns := sortedKeys(ps.patterns)
+	ps.mu.Unlock()
+
+	if err := ps.resubscribe(cn, "SUBSCRIBE", channels); err != nil {
+		cn.close()
+		return nil, err
+	}
+	if err := ps.resubscribe(cn, "PSUBSCRIBE", patterns); err != nil {
+		cn.close()
+		return nil, err
+	}
+
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		cn.close()
+		return nil, ErrPubSubClosed
+	}
+	ps.cn = cn
+	ps.mu.Unlock()
+
+	// The read loop owns the socket from here on and must not time out
+	// between messages.
+	cn.conn.SetDeadline(time.Time{})
+	log.Printf("[REDIS] Pub/sub connection #%d ready, channels %v, patterns %v", cn.id, channels, patterns)
+	return cn, nil
+}
+
+// resubscribe runs one subscribe command on a connection nobody else can
+// see yet and waits for its confirmations. Messages that arrive meanwhile
+// are delivered as usual.
+func (ps *PubSub) resubscribe(cn *redisConn, name string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	if err := ps.send(cn, name, names); err != nil {
+		return err
+	}
+	for remaining := len(names); remaining > 0; {
+		if ps.config.ReadTimeout > 0 {
+			cn.conn.SetReadDeadline(time.Now().Add(ps.config.ReadTimeout))
+		}
+		reply, err := readReply(cn.reader)
+		if err != nil {
+			return err
+		}
+		if redisErr, ok := reply.(*RedisError); ok {
+			return fmt.Errorf("%s failed: %w", name, redisErr)
+		}
+		kind, data := pubsubFrame(reply)
+		switch kind {
+		case "message", "pmessage":
+			if !ps.handleMessage(kind, data) {
+				return ErrPubSubClosed
+			}
+		case "subscribe", "psubscribe":
+			remaining--
+		}
+	}
+	return nil
+}
+
+// readLoop owns all reads on cn until it fails.
+func (ps *PubSub) readLoop(cn *redisConn) error {
+	for {
+		reply, err := readReply(cn.reader)
+		if err != nil {
+			return err
+		}
+		if redisErr, ok := reply.(*RedisError); ok {
+			log.Printf("[REDIS] ERROR: Pub/sub command failed: %v", redisErr)
+			ps.answer(redisErr, true)
+			continue
+		}
+
+		kind, data := pubsubFrame(reply)
+		switch kind {
+		case "message", "pmessage":
+			if !ps.handleMessage(kind, data) {
+				return ErrPubSubClosed
+			}
+		case "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+			if len(data) >= 2 {
+				log.Printf("[REDIS] Pub/sub %s '%v' confirmed (%v active)", kind, data[0], data[1])
+			}
+			ps.answer(nil, false)
+		default:
+			log.Printf("[REDIS] WARNING: Pub/sub connection #%d received unexpected reply %v", cn.id, reply)
+		}
+	}
+}
+
+// answer counts one confirmation against the oldest pending command, or
+// fails it outright.
+func (ps *PubSub) answer(err error, fail bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if len(ps.pending) == 0 {
+		return
+	}
+	head := ps.pending[0]
+	head.remaining--
+	if fail || head.remaining <= 0 {
+		head.err <- err
+		ps.pending = ps.pending[1:]
+	}
+}
+
+func (ps *PubSub) handleMessage(kind string, data []interface{}) bool {
+	msg := &Message{}
+	if kind == "pmessage" {
+		if len(data) < 3 {
+			return true
+		}
+		msg.Pattern, _ = data[0].(string)
+		data = data[1:]
+	}
+	if len(data) < 2 {
+		return true
+	}
+	msg.Channel, _ = data[0].(string)
+	msg.Payload, _ = data[1].(string)
+	log.Printf("[REDIS] Pub/sub message on '%s' (%d bytes)", msg.Channel, len(msg.Payload))
+	return ps.deliver(msg)
+}
+
+func (ps *PubSub) disconnect(cn *redisConn, err error) {
+	ps.mu.Lock()
+	if ps.cn == cn {
+		ps.cn = nil
+	}
+	for _, reply := range ps.pending {
+		reply.err <- err
+	}
+	ps.pending = nil
+	ps.mu.Unlock()
+	cn.close()
+}
+
+// deliver queues msg, waiting up to DeliveryTimeout for room when the
+// consumer is behind; the socket is not read meanwhile. It returns false
+// only when the PubSub is closed.
+func (ps *PubSub) deliver(msg *Message) bool {
+	select {
+	case ps.messages <- msg:
+		ps.incrementCounter("redis_pubsub_messages_total", map[string]string{"result": "delivered"})
+		return true
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if d := ps.config.PubSub.DeliveryTimeout; d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case ps.messages <- msg:
+		ps.incrementCounter("redis_pubsub_messages_total", map[string]string{"result": "delivered"})
+		return true
+	case <-timeout:
+		dropped := atomic.AddUint64(&ps.dropped, 1)
+		if msg != nil {
+			log.Printf("[REDIS] WARNING: Pub/sub consumer too slow, dropped message on '%s' (%d dropped so far)", msg.Channel, dropped)
+		}
+		ps.incrementCounter("redis_pubsub_messages_total", map[string]string{"result": "dropped"})
+		return true
+	case <-ps.done:
+		return false
+	}
+}
+
+func (ps *PubSub) incrementCounter(name string, labels map[string]string) {
+	if ps.metricsRegistry != nil {
+		ps.metricsRegistry.IncrementCounter(name, labels)
+	}
+}
+
+func (ps *PubSub) isClosed() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.closed
+}
+
+// pubsubFrame splits a pub/sub reply into its kind and the rest. They come
+// as arrays on RESP2 and as push frames on RESP3.
+func pubsubFrame(reply interface{}) (string, []interface{}) {
+	switch v := reply.(type) {
+	case *Push:
+		return v.Kind, v.Data
+	case []interface{}:
+		if len(v) == 0 {
+			return "", nil
+		}
+		kind, _ := v[0].(string)
+		return kind, v[1:]
+	}
+	return "", nil
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,120 @@
+package redis_gateway
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newTestPubSub(pending ...int) (*PubSub, []*pubsubReply) {
+	ps := &PubSub{
+		messages: make(chan *Message, 8),
+		done:     make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	replies := make([]*pubsubReply, len(pending))
+	for i, n := range pending {
+		replies[i] = &pubsubReply{remaining: n, err: make(chan error, 1)}
+	}
+	ps.pending = append(ps.pending, replies...)
+	return ps, replies
+}
+
+// answered reports whether reply has been answered, and with what.
+func answered(reply *pubsubReply) (bool, error) {
+	select {
+	case err := <-reply.err:
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+// errPending marks a command that must not have been answered yet.
+var errPending = errors.New("pending")
+
+func TestPubSubAnswer(t *testing.T) {
+	failure := &RedisError{Code: "ERR", Message: "ERR wrong number of arguments"}
+	type step struct {
+		err  error
+		fail bool
+	}
+	ack := step{}
+	tests := []struct {
+		name    string
+		pending []int
+		steps   []step
+		// want is the error each pending command was answered with.
+		want        []error
+		wantPending int
+	}{
+		{"one ack per name", []int{3}, []step{ack, ack, ack}, []error{nil}, 0},
+		{"short of acks", []int{3}, []step{ack, ack}, []error{errPending}, 1},
+		{"acks go to the oldest command", []int{2, 1}, []step{ack, ack, ack}, []error{nil, nil}, 0},
+		{"second command still waiting", []int{1, 2}, []step{ack, ack}, []error{nil, errPending}, 1},
+		{"error fails the oldest at once", []int{3, 1}, []step{ack, {failure, true}, ack}, []error{failure, nil}, 0},
+		{"acks without a command are ignored", nil, []step{ack, ack}, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, replies := newTestPubSub(tt.pending...)
+			for _, s := range tt.steps {
+				ps.answer(s.err, s.fail)
+			}
+			for i, reply := range replies {
+				done, err := answered(reply)
+				if tt.want[i] == errPending {
+					if done {
+						t.Errorf("command %d answered with %v, want still pending", i, err)
+					}
+					continue
+				}
+				if !done || err != tt.want[i] {
+					t.Errorf("command %d: answered=%v err=%v, want answered with %v", i, done, err, tt.want[i])
+				}
+			}
+			if len(ps.pending) != tt.wantPending {
+				t.Errorf("%d commands pending, want %d", len(ps.pending), tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestPubSubReadLoop(t *testing.T) {
+	// SUBSCRIBE a b, then UNSUBSCRIBE a, with messages in between and a
+	// mix of RESP2 arrays and RESP3 push frames.
+	stream := strings.Join([]string{
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n",
+		"*3\r\n$7\r\nmessage\r\n$1\r\na\r\n$2\r\nhi\r\n",
+		">3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n",
+		">4\r\n$8\r\npmessage\r\n$2\r\nb*\r\n$2\r\nbc\r\n$3\r\nyes\r\n",
+		">3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:1\r\n",
+	}, "")
+	ps, replies := newTestPubSub(2, 1)
+	cn := &redisConn{id: 1, reader: bufio.NewReader(strings.NewReader(stream))}
+
+	if err := ps.readLoop(cn); err != io.EOF {
+		t.Fatalf("readLoop returned %v, want io.EOF", err)
+	}
+	for i, reply := range replies {
+		if done, err := answered(reply); !done || err != nil {
+			t.Errorf("command %d: answered=%v err=%v, want answered with nil", i, done, err)
+		}
+	}
+	if len(ps.pending) != 0 {
+		t.Errorf("%d commands pending, want 0", len(ps.pending))
+	}
+
+	want := []Message{{Channel: "a", Payload: "hi"}, {Channel: "bc", Pattern: "b*", Payload: "yes"}}
+	if len(ps.messages) != len(want) {
+		t.Fatalf("%d messages delivered, want %d", len(ps.messages), len(want))
+	}
+	for i, w := range want {
+		if got := <-ps.messages; got == nil || *got != w {
+			t.Errorf("message %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
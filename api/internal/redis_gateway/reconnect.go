@@ -0,0 +1,259 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"api/internal/backoff"
+)
+
+const (
+	defaultMinRetryBackoff = 8 * time.Millisecond
+	defaultMaxRetryBackoff = 512 * time.Millisecond
+
+	reconnectMinBackoff = 250 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// idempotentCommands can be sent again after a connection dropped without
+// knowing whether the first attempt reached the server. SET is handled in
+// isIdempotent since only its plain form qualifies.
+var idempotentCommands = map[string]bool{
+	"PING": true, "ECHO": true, "INFO": true, "DBSIZE": true, "TIME": true,
+	"GET": true, "MGET": true, "MSET": true, "STRLEN": true, "GETRANGE": true,
+	"EXISTS": true, "TYPE": true, "TTL": true, "PTTL": true, "PERSIST": true, "DEL": true,
+	"SCAN": true, "SSCAN": true, "HSCAN": true, "ZSCAN": true,
+	"HGET": true, "HMGET": true, "HGETALL": true, "HSET": true, "HDEL": true, "HLEN": true, "HEXISTS": true, "HKEYS": true, "HVALS": true,
+	"SMEMBERS": true, "SISMEMBER": true, "SCARD": true,
+	"LRANGE": true, "LLEN": true, "LINDEX": true,
+	"ZRANGE": true, "ZSCORE": true, "ZCARD": true, "ZRANK": true,
+	"XRANGE": true, "XREVRANGE": true, "XLEN": true, "XPENDING": true, "XACK": true,
+}
+
+// isIdempotent reports whether running the command twice has the same
+// effect as running it once.
+func isIdempotent(args []interface{}) bool {
+	if len(args) == 0 {
+		return false
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return false
+	}
+	name = strings.ToUpper(name)
+	if name == "SET" {
+		// SET key value; options such as NX, GET or KEEPTTL change the
+		// reply or the effect of a second run.
+		return len(args) == 3
+	}
+	return idempotentCommands[name]
+}
+
+func allIdempotent(cmds []*Cmd) bool {
+	for _, cmd := range cmds {
+		if !isIdempotent(cmd.args) {
+			return false
+		}
+	}
+	return true
+}
+
+// withConn checks a connection out of the pool, arms its deadlines and hands
+// it to fn. Failed dials are retried for any command since nothing reached
+// the server; if the connection is lost while fn runs, fn is retried on a
+// new connection only when idempotent is set.
+func (r *RedisClient) withConn(ctx context.Context, idempotent bool, fn func(cn *redisConn) error) error {
+	bo := r.retryBackoff()
+	for {
+		cn, err := r.pool.get(ctx)
+		if err != nil {
+			log.Printf("[REDIS] ERROR: Failed to get connection from pool: %v", err)
+			if !isDialError(ctx, err) {
+				return err
+			}
+			r.connectionLost(err)
+			if !r.waitRetry(ctx, bo, err) {
+				return err
+			}
+			continue
+		}
+
+		cn.setDeadlines(ctx, r.config.ReadTimeout, r.config.WriteTimeout)
+		err = fn(cn)
+		lost := err != nil && cn.broken && isConnLost(err)
+		r.pool.put(cn)
+		if !lost {
+			return err
+		}
+		r.connectionLost(err)
+		if !idempotent || !r.waitRetry(ctx, bo, err) {
+			return err
+		}
+	}
+}
+
+func (r *RedisClient) retryBackoff() *backoff.Backoff {
+	bo := &backoff.Backoff{Min: r.config.MinRetryBackoff, Max: r.config.MaxRetryBackoff, Jitter: 0.5}
+	if bo.Min <= 0 {
+		bo.Min = defaultMinRetryBackoff
+	}
+	if bo.Max <= 0 {
+		bo.Max = defaultMaxRetryBackoff
+	}
+	return bo
+}
+
+// waitRetry sleeps before the next attempt. It returns false when the
+// retries are used up or ctx is done.
+func (r *RedisClient) waitRetry(ctx context.Context, bo *backoff.Backoff, err error) bool {
+	if bo.Attempt() >= r.config.MaxRetries || ctx.Err() != nil {
+		return false
+	}
+	delay := bo.Next()
+	log.Printf("[REDIS] WARNING: %v, retrying in %v (attempt %d/%d)", err, delay, bo.Attempt(), r.config.MaxRetries)
+	r.incrementCounter("redis_retries_total", map[string]string{})
+	return backoff.Wait(ctx, delay) == nil
+}
+
+// connectionLost marks the server as down, drops the idle connections (they
+// point at the same dead server) and starts redialing in the background.
+func (r *RedisClient) connectionLost(err error) {
+	r.mu.Lock()
+	if r.reconnecting {
+		r.mu.Unlock()
+		return
+	}
+	r.reconnecting = true
+	r.mu.Unlock()
+
+	log.Printf("[REDIS] ERROR: Connection to %s lost: %v", r.config.Addr, err)
+	r.setConnected(false)
+	if n := r.pool.purgeIdle(); n > 0 {
+		log.Printf("[REDIS] Dropped %d idle connections", n)
+	}
+	go r.reconnectLoop()
+}
+
+// reconnectLoop pings the server with jittered exponential backoff until it
+// answers or the client is closed.
+func (r *RedisClient) reconnectLoop() {
+	bo := backoff.Backoff{Min: reconnectMinBackoff, Max: reconnectMaxBackoff, Jitter: 0.5}
+	lostAt := time.Now()
+	for {
+		delay := bo.Next()
+		log.Printf("[REDIS] Reconnecting in %v (attempt %d)...", delay, bo.Attempt())
+		select {
+		case <-time.After(delay):
+		case <-r.pool.stop:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), r.config.DialTimeout+r.config.ReadTimeout)
+		err := r.pingServer(ctx)
+		cancel()
+		if errors.Is(err, ErrPoolClosed) {
+			return
+		}
+
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		r.incrementCounter("redis_reconnect_attempts_total", map[string]string{"result": result})
+		if err != nil {
+			log.Printf("[REDIS] WARNING: Reconnect attempt %d failed: %v", bo.Attempt(), err)
+			continue
+		}
+
+		log.Printf("[REDIS] Reconnected after %d attempts (down for %v)", bo.Attempt(), time.Since(lostAt))
+		r.mu.Lock()
+		r.reconnecting = false
+		r.mu.Unlock()
+		r.setConnected(true)
+		return
+	}
+}
+
+func (r *RedisClient) pingServer(ctx context.Context) error {
+	cn, err := r.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.pool.put(cn)
+	cn.setDeadlines(ctx, r.config.ReadTimeout, r.config.WriteTimeout)
+	_, err = cn.do("PING")
+	return err
+}
+
+// setConnected records whether the server is reachable and publishes it as
+// redis_connection_status.
+func (r *RedisClient) setConnected(connected bool) {
+	r.mu.Lock()
+	r.connected = connected
+	r.mu.Unlock()
+	r.publishStatus()
+}
+
+// Connected reports whether the server answered the last time it was
+// contacted. It turns false when a connection is lost and true again once a
+// background reconnect succeeds.
+func (r *RedisClient) Connected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connected
+}
+
+func (r *RedisClient) publishStatus() {
+	r.mu.Lock()
+	registry, connected := r.metricsRegistry, r.connected
+	r.mu.Unlock()
+	if registry == nil {
+		return
+	}
+	status := 0.0
+	if connected {
+		status = 1
+	}
+	registry.SetGauge("redis_connection_status", status, map[string]string{})
+}
+
+func (r *RedisClient) incrementCounter(name string, labels map[string]string) {
+	r.mu.Lock()
+	registry := r.metricsRegistry
+	r.mu.Unlock()
+	if registry != nil {
+		registry.IncrementCounter(name, labels)
+	}
+}
+
+// isDialError reports whether a pool error came from opening a new
+// connection, as opposed to the pool itself or the caller's context. Errors
+// replied by the server during setup (a wrong password, say) are final,
+// except LOADING while it reads its dataset after a restart.
+func isDialError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrPoolClosed) || errors.Is(err, ErrPoolTimeout) {
+		return false
+	}
+	var redisErr *RedisError
+	if errors.As(err, &redisErr) {
+		return redisErr.Code == "LOADING"
+	}
+	return true
+}
+
+// isConnLost reports whether err means the server went away, as opposed to
+// an error reply or a timeout on a live connection.
+func isConnLost(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && !netErr.Timeout()
+}
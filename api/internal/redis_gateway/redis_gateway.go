@@ -1,269 +1,293 @@
 package redis_gateway
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	userKeyPattern = "user:*"
+	userScanCount  = 500
+	userBatchSize  = 100
+)
+
+// Config describes how RedisClient reaches the server.
+type Config struct {
+	Addr         string
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Protocol selects RESP2 (2) or RESP3 (3). RESP3 is negotiated with
+	// HELLO and falls back to RESP2 if the server refuses.
+	Protocol int
+	// Username and Password are sent with AUTH (or HELLO ... AUTH). An empty
+	// Username authenticates as the default user.
+	Username string
+	Password string
+	// DB is the logical database every connection SELECTs.
+	DB int
+	// ClientName is set with CLIENT SETNAME so connections are recognizable
+	// in CLIENT LIST.
+	ClientName string
+	// MaxRetries is how many times a failed dial, or an idempotent command
+	// whose connection was lost, is retried with jittered exponential
+	// backoff between MinRetryBackoff and MaxRetryBackoff. Zero disables
+	// retries; zero backoffs use the defaults.
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+	// TLS, if set, wraps every connection in TLS.
+	TLS  *TLSConfig
+	Pool PoolConfig
+	// PubSub applies to subscribers started with NewPubSub.
+	PubSub PubSubConfig
+}
+
+func DefaultConfig(addr string) Config {
+	return Config{
+		Addr:            addr,
+		DialTimeout:     5 * time.Second,
+		ReadTimeout:     3 * time.Second,
+		WriteTimeout:    3 * time.Second,
+		Protocol:        2,
+		MaxRetries:      3,
+		MinRetryBackoff: defaultMinRetryBackoff,
+		MaxRetryBackoff: defaultMaxRetryBackoff,
+		Pool:            DefaultPoolConfig(),
+		PubSub:          DefaultPubSubConfig(),
+	}
+}
+
+// RedisClient is safe for concurrent use. Every command checks a connection
+// out of the pool for the duration of its round trip.
 type RedisClient struct {
-	conn            net.Conn
-	addr            string
+	config Config
+	pool   *Pool
+
+	mu           sync.Mutex
+	connected    bool
+	reconnecting bool
+
+	// scanTypeUnsupported is set once the server has rejected the TYPE
+	// option of SCAN (Redis before 6.0).
+	scanTypeUnsupported int32
+
 	metricsRegistry interface {
 		SetGauge(name string, value float64, labels map[string]string)
+		SetCounter(name string, value float64, labels map[string]string)
+		IncrementCounter(name string, labels map[string]string)
 	}
 }
 
 func (r *RedisClient) SetMetricsRegistry(registry interface {
 	SetGauge(name string, value float64, labels map[string]string)
+	SetCounter(name string, value float64, labels map[string]string)
+	IncrementCounter(name string, labels map[string]string)
 }) {
+	r.mu.Lock()
 	r.metricsRegistry = registry
+	r.mu.Unlock()
+	r.pool.SetMetricsRegistry(registry)
+	r.publishStatus()
 }
 
-func NewRedisClient(addr string) *RedisClient {
-	log.Printf("[REDIS] Dialing TCP connection to %s...", addr)
-	startTime := time.Now()
-	
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		log.Printf("[REDIS] FATAL: Failed to connect to Redis: %v", err)
-		panic(fmt.Sprintf("Failed to connect to Redis: %v", err))
+func NewRedisClient(addr string) (*RedisClient, error) {
+	return NewRedisClientFromConfig(DefaultConfig(addr))
+}
+
+// NewRedisClientFromConfig opens the pool's initial connections and returns
+// an error if the server cannot be reached.
+func NewRedisClientFromConfig(config Config) (*RedisClient, error) {
+	log.Printf("[REDIS] Creating new Redis client for %s", config.Addr)
+	log.Printf("[REDIS] Timeouts: dial=%v, read=%v, write=%v", config.DialTimeout, config.ReadTimeout, config.WriteTimeout)
+	log.Printf("[REDIS] Session: user=%q, password set=%v, db=%d, client_name=%q", config.Username, config.Password != "", config.DB, config.ClientName)
+	if config.TLS != nil {
+		log.Printf("[REDIS] TLS enabled: ca=%q, cert=%q, server_name=%q, insecure_skip_verify=%v",
+			config.TLS.CACert, config.TLS.Cert, config.TLS.ServerName, config.TLS.InsecureSkipVerify)
 	}
-	
-	log.Printf("[REDIS] TCP connection established in %v", time.Since(startTime))
-	log.Printf("[REDIS] Local address: %s", conn.LocalAddr())
-	log.Printf("[REDIS] Remote address: %s", conn.RemoteAddr())
-
-	return &RedisClient{
-		conn: conn,
-		addr: addr,
+	log.Printf("[REDIS] Pool configuration: %+v", config.Pool)
+
+	client := &RedisClient{config: config}
+	client.pool = NewPool(func(ctx context.Context, id int64) (*redisConn, error) {
+		return dialRedisConn(ctx, id, &client.config)
+	}, config.Pool)
+
+	if err := client.pool.warmUp(context.Background()); err != nil {
+		log.Printf("[REDIS] ERROR: Failed to connect to Redis: %v", err)
+		client.pool.Close()
+		return nil, fmt.Errorf("failed to connect to Redis at %s: %w", config.Addr, err)
 	}
+
+	client.connected = true
+	log.Printf("[REDIS] Client created successfully")
+	return client, nil
 }
 
-func (r *RedisClient) Set(key, value string) error {
-	operationStart := time.Now()
-	
-	log.Printf("[REDIS] Building RESP command for SET key='%s' value='%s'", key, value)
-	cmd := fmt.Sprintf("*3\r\n$3\r\nSET\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
-		len(key), key, len(value), value)
-	
-	log.Printf("[REDIS] RESP command: %q", cmd)
-	log.Printf("[REDIS] Command size: %d bytes", len(cmd))
-	log.Printf("[REDIS] Writing command to socket...", )
-	
-	startWrite := time.Now()
-	bytesWritten, err := r.conn.Write([]byte(cmd))
-	if err != nil {
-		log.Printf("[REDIS] ERROR: Failed to write to socket: %v", err)
+// Do sends one command and returns its decoded reply (see readReply for the
+// Go types used). An error reply from the server is returned as a
+// *RedisError; a null reply is returned as a nil value without error.
+func (r *RedisClient) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	var reply interface{}
+	err := r.withConn(ctx, isIdempotent(args), func(cn *redisConn) error {
+		var err error
+		reply, err = cn.do(args...)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("[REDIS] Wrote %d bytes in %v", bytesWritten, time.Since(startWrite))
+	return reply, nil
+}
+
+func (r *RedisClient) Set(key, value string) error {
+	operationStart := time.Now()
 
-	log.Printf("[REDIS] Reading response from Redis...")
-	reader := bufio.NewReader(r.conn)
-	startRead := time.Now()
-	response, err := reader.ReadString('\n')
+	log.Printf("[REDIS] Sending SET key='%s' value='%s'", key, value)
+	response, err := toString(r.Do(context.Background(), "SET", key, value))
 	if err != nil {
-		log.Printf("[REDIS] ERROR: Failed to read response: %v", err)
+		log.Printf("[REDIS] ERROR: SET failed: %v", err)
 		return err
 	}
-	log.Printf("[REDIS] Received response in %v: %q", time.Since(startRead), response)
-
-	if !strings.HasPrefix(response, "+OK") {
+	if response != "OK" {
 		log.Printf("[REDIS] ERROR: Unexpected response from Redis: %s", response)
 		return fmt.Errorf("Redis error: %s", response)
 	}
 
 	totalLatency := time.Since(operationStart)
 	log.Printf("[REDIS] SET operation successful (total latency: %v)", totalLatency)
-	
+
 	if r.metricsRegistry != nil {
 		r.metricsRegistry.SetGauge("redis_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "set"})
 	}
-	
+
 	return nil
 }
 
+// Get returns ErrNil when the key does not exist.
 func (r *RedisClient) Get(key string) (string, error) {
 	operationStart := time.Now()
-	
-	log.Printf("[REDIS] Building RESP command for GET key='%s'", key)
-	cmd := fmt.Sprintf("*2\r\n$3\r\nGET\r\n$%d\r\n%s\r\n", len(key), key)
-	
-	log.Printf("[REDIS] RESP command: %q", cmd)
-	log.Printf("[REDIS] Writing GET command to socket...")
-	
-	bytesWritten, err := r.conn.Write([]byte(cmd))
-	if err != nil {
-		log.Printf("[REDIS] ERROR: Failed to write GET command: %v", err)
-		return "", err
-	}
-	log.Printf("[REDIS] Wrote %d bytes", bytesWritten)
 
-	log.Printf("[REDIS] Reading GET response...")
-	reader := bufio.NewReader(r.conn)
-	response, err := reader.ReadString('\n')
-	if err != nil {
-		log.Printf("[REDIS] ERROR: Failed to read GET response: %v", err)
-		return "", err
+	log.Printf("[REDIS] Sending GET key='%s'", key)
+	value, err := toString(r.Do(context.Background(), "GET", key))
+
+	totalLatency := time.Since(operationStart)
+	if r.metricsRegistry != nil {
+		r.metricsRegistry.SetGauge("redis_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "get"})
 	}
-	log.Printf("[REDIS] Received response: %q", response)
 
-	if strings.HasPrefix(response, "$-1") {
+	if err == ErrNil {
 		log.Printf("[REDIS] Key not found in Redis")
-		totalLatency := time.Since(operationStart)
-		if r.metricsRegistry != nil {
-			r.metricsRegistry.SetGauge("redis_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "get"})
-		}
-		return "", fmt.Errorf("key not found")
+		return "", err
 	}
-
-	if strings.HasPrefix(response, "$") {
-		lengthStr := strings.TrimSpace(response[1:])
-		length, _ := strconv.Atoi(lengthStr)
-		log.Printf("[REDIS] Value length: %d bytes", length)
-		
-		value := make([]byte, length)
-		bytesRead, err := reader.Read(value)
-		if err != nil {
-			log.Printf("[REDIS] ERROR: Failed to read value: %v", err)
-			return "", err
-		}
-		log.Printf("[REDIS] Read %d bytes of value data", bytesRead)
-		
-		reader.ReadString('\n')
-		
-		totalLatency := time.Since(operationStart)
-		log.Printf("[REDIS] GET operation successful, value='%s' (total latency: %v)", string(value), totalLatency)
-		
-		if r.metricsRegistry != nil {
-			r.metricsRegistry.SetGauge("redis_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "get"})
-		}
-		
-		return string(value), nil
+	if err != nil {
+		log.Printf("[REDIS] ERROR: GET failed: %v", err)
+		return "", err
 	}
 
-	log.Printf("[REDIS] ERROR: Unexpected response format: %s", response)
-	return "", fmt.Errorf("unexpected response: %s", response)
+	log.Printf("[REDIS] GET operation successful, %d bytes (total latency: %v)", len(value), totalLatency)
+	return value, nil
 }
 
-func (r *RedisClient) GetAllUsers() ([]map[string]interface{}, error) {
+// GetAllUsers returns the fields of every user:<id> hash, with the ID from
+// the key added as "user_id".
+func (r *RedisClient) GetAllUsers() ([]map[string]string, error) {
 	operationStart := time.Now()
-	
-	log.Printf("[REDIS] Getting all user keys with pattern 'user:*'")
-	
-	// First, get all keys matching pattern "user:*"
-	cmd := "*2\r\n$4\r\nKEYS\r\n$6\r\nuser:*\r\n"
-	
-	log.Printf("[REDIS] Sending KEYS command...")
-	bytesWritten, err := r.conn.Write([]byte(cmd))
-	if err != nil {
-		log.Printf("[REDIS] ERROR: Failed to write KEYS command: %v", err)
-		return nil, err
-	}
-	log.Printf("[REDIS] Wrote %d bytes", bytesWritten)
-	
-	reader := bufio.NewReader(r.conn)
-	response, err := reader.ReadString('\n')
-	if err != nil {
-		log.Printf("[REDIS] ERROR: Failed to read KEYS response: %v", err)
-		return nil, err
-	}
-	log.Printf("[REDIS] KEYS response: %q", response)
-	
-	// Parse array response
-	if !strings.HasPrefix(response, "*") {
-		log.Printf("[REDIS] ERROR: Unexpected KEYS response format: %s", response)
-		return nil, fmt.Errorf("unexpected response: %s", response)
-	}
-	
-	countStr := strings.TrimSpace(response[1:])
-	count, err := strconv.Atoi(countStr)
+
+	log.Printf("[REDIS] Getting all users with pattern '%s'", userKeyPattern)
+
+	users := make([]map[string]string, 0)
+	err := r.ForEachUser(context.Background(), func(userID string, fields map[string]string) error {
+		fields["user_id"] = userID
+		users = append(users, fields)
+		return nil
+	})
 	if err != nil {
-		log.Printf("[REDIS] ERROR: Failed to parse key count: %v", err)
+		log.Printf("[REDIS] ERROR: Failed to scan users: %v", err)
 		return nil, err
 	}
-	
-	log.Printf("[REDIS] Found %d user keys", count)
-	
-	if count == 0 {
-		totalLatency := time.Since(operationStart)
-		if r.metricsRegistry != nil {
-			r.metricsRegistry.SetGauge("redis_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "get_all_users"})
-		}
-		return []map[string]interface{}{}, nil
-	}
-	
-	// Read all keys
-	keys := make([]string, 0, count)
-	for i := 0; i < count; i++ {
-		// Read bulk string length
-		lengthLine, err := reader.ReadString('\n')
-		if err != nil {
-			log.Printf("[REDIS] ERROR: Failed to read key length: %v", err)
-			return nil, err
-		}
-		
-		if strings.HasPrefix(lengthLine, "$") {
-			lengthStr := strings.TrimSpace(lengthLine[1:])
-			length, _ := strconv.Atoi(lengthStr)
-			
-			// Read key value
-			keyBytes := make([]byte, length)
-			reader.Read(keyBytes)
-			reader.ReadString('\n') // Read trailing \r\n
-			
-			keys = append(keys, string(keyBytes))
-		}
-	}
-	
-	log.Printf("[REDIS] Retrieved %d keys: %v", len(keys), keys)
-	
-	// Now get all values for these keys
-	users := make([]map[string]interface{}, 0, len(keys))
-	for _, key := range keys {
-		value, err := r.Get(key)
-		if err != nil {
-			log.Printf("[REDIS] WARNING: Failed to get value for key %s: %v", key, err)
-			continue
-		}
-		
-		// Parse user ID from key (format: "user:12345-6789")
-		userID := strings.TrimPrefix(key, "user:")
-		
-		// Create user map (simplified - in production would parse JSON)
-		user := map[string]interface{}{
-			"user_id": userID,
-			"data":    value,
-		}
-		users = append(users, user)
-	}
-	
+
 	totalLatency := time.Since(operationStart)
 	log.Printf("[REDIS] Retrieved %d users (total latency: %v)", len(users), totalLatency)
-	
+
 	if r.metricsRegistry != nil {
 		r.metricsRegistry.SetGauge("redis_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "get_all_users"})
 	}
-	
+
 	return users, nil
 }
 
-func (r *RedisClient) Close() error {
-	log.Printf("[REDIS] Closing connection to %s...", r.addr)
-	if r.conn != nil {
-		err := r.conn.Close()
-		if err != nil {
-			log.Printf("[REDIS] ERROR: Failed to close connection: %v", err)
+// ForEachUser streams the fields of every user:<id> hash to fn. Keys are
+// discovered with SCAN rather than KEYS so Redis is never blocked, and are
+// read with one pipeline of HGETALLs per userBatchSize keys. Keys removed
+// between SCAN and HGETALL are skipped, as are keys of other types.
+// Returning an error from fn stops the iteration.
+func (r *RedisClient) ForEachUser(ctx context.Context, fn func(userID string, fields map[string]string) error) error {
+	it := r.ScanIter(ctx, ScanOptions{Match: userKeyPattern, Count: userScanCount, Type: "hash"})
+	batch := make([]string, 0, userBatchSize)
+	pipe := r.Pipeline()
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		log.Printf("[REDIS] Fetching %d user hashes with HGETALL...", len(batch))
+		for _, key := range batch {
+			pipe.HGetAll(key)
+		}
+		// Error replies are checked per command below, so that one key
+		// that changed type does not abort the listing.
+		cmds, err := pipe.Exec(ctx)
+		var redisErr *RedisError
+		if err != nil && !errors.As(err, &redisErr) {
 			return err
 		}
-		log.Printf("[REDIS] Connection closed successfully")
+		for i, cmd := range cmds {
+			fields, err := cmd.StringMap()
+			if errors.As(err, &redisErr) && redisErr.Code == "WRONGTYPE" {
+				log.Printf("[REDIS] Skipping key '%s', it is no longer a hash", batch[i])
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			if err := fn(strings.TrimPrefix(batch[i], "user:"), fields); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
 		return nil
 	}
-	log.Printf("[REDIS] No active connection to close")
+
+	for it.Next() {
+		batch = append(batch, it.Key())
+		if len(batch) == userBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// PoolStats returns a snapshot of the connection pool.
+func (r *RedisClient) PoolStats() PoolStats {
+	return r.pool.Stats()
+}
+
+func (r *RedisClient) Close() error {
+	log.Printf("[REDIS] Closing connection pool for %s...", r.config.Addr)
+	r.pool.Close()
+	log.Printf("[REDIS] Connection pool closed successfully")
 	return nil
 }
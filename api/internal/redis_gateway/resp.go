@@ -0,0 +1,428 @@
+package redis_gateway
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNil is returned when the server answers with a null reply, for example
+// GET on a missing key.
+var ErrNil = errors.New("redis: nil")
+
+// maxBulkLen mirrors the server's default proto-max-bulk-len.
+const maxBulkLen = 512 * 1024 * 1024
+
+// RedisError is an error reply ("-ERR ..." or a RESP3 blob error). Code is
+// the leading upper-case word such as ERR, WRONGTYPE or NOPROTO.
+type RedisError struct {
+	Code    string
+	Message string
+}
+
+func (e *RedisError) Error() string {
+	return e.Message
+}
+
+func parseRedisError(msg string) *RedisError {
+	code := msg
+	if i := strings.IndexByte(msg, ' '); i > 0 {
+		code = msg[:i]
+	}
+	if strings.ToUpper(code) != code {
+		code = ""
+	}
+	return &RedisError{Code: code, Message: msg}
+}
+
+// ProtocolError reports a reply that does not follow RESP. The connection it
+// came from can no longer be trusted.
+type ProtocolError struct {
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return "redis: protocol error: " + e.Message
+}
+
+// Push is a RESP3 out-of-band push frame such as a pub/sub message or a
+// client-side caching invalidation. Kind is the first element.
+type Push struct {
+	Kind string
+	Data []interface{}
+}
+
+// readReply decodes one RESP2 or RESP3 value into Go types:
+//
+//	simple string, bulk string, verbatim string -> string
+//	error, blob error                          -> *RedisError (as a value)
+//	integer                                    -> int64
+//	double                                     -> float64
+//	boolean                                    -> bool
+//	big number                                 -> *big.Int
+//	null, null bulk string, null array         -> nil
+//	array, set                                 -> []interface{}
+//	map                                        -> map[string]interface{}
+//	push                                       -> *Push
+//
+// Error replies are returned as values rather than errors so that they can
+// sit inside arrays (EXEC, pipelines); callers decide when they are fatal.
+// Attributes are read and dropped. The returned error is only set for I/O
+// and protocol failures.
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, &ProtocolError{Message: "empty reply line"}
+	}
+
+	payload := line[1:]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return parseRedisError(payload), nil
+	case ':':
+		n, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return nil, &ProtocolError{Message: fmt.Sprintf("invalid integer %q", payload)}
+		}
+		return n, nil
+	case '$':
+		return readBulk(reader, payload)
+	case '!':
+		s, err := readBulk(reader, payload)
+		if err != nil || s == nil {
+			return s, err
+		}
+		return parseRedisError(s.(string)), nil
+	case '=':
+		s, err := readBulk(reader, payload)
+		if err != nil || s == nil {
+			return s, err
+		}
+		// Verbatim strings carry a three letter format and a colon.
+		text := s.(string)
+		if len(text) >= 4 && text[3] == ':' {
+			text = text[4:]
+		}
+		return text, nil
+	case '*', '~':
+		n, err := parseLength(payload)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		return readArray(reader, n)
+	case '>':
+		n, err := parseLength(payload)
+		if err != nil {
+			return nil, err
+		}
+		items, err := readArray(reader, n)
+		if err != nil {
+			return nil, err
+		}
+		push := &Push{}
+		if len(items) > 0 {
+			push.Kind, _ = items[0].(string)
+			push.Data = items[1:]
+		}
+		return push, nil
+	case '%':
+		n, err := parseLength(payload)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := readReply(reader)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readReply(reader)
+			if err != nil {
+				return nil, err
+			}
+			m[replyKey(key)] = value
+		}
+		return m, nil
+	case '|':
+		n, err := parseLength(payload)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < 2*n; i++ {
+			if _, err := readReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return readReply(reader)
+	case '_':
+		return nil, nil
+	case ',':
+		f, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return nil, &ProtocolError{Message: fmt.Sprintf("invalid double %q", payload)}
+		}
+		return f, nil
+	case '#':
+		switch payload {
+		case "t":
+			return true, nil
+		case "f":
+			return false, nil
+		}
+		return nil, &ProtocolError{Message: fmt.Sprintf("invalid boolean %q", payload)}
+	case '(':
+		n, ok := new(big.Int).SetString(payload, 10)
+		if !ok {
+			return nil, &ProtocolError{Message: fmt.Sprintf("invalid big number %q", payload)}
+		}
+		return n, nil
+	}
+
+	return nil, &ProtocolError{Message: fmt.Sprintf("unknown reply type %q", line[0])}
+}
+
+// readLine reads one CRLF-terminated line and strips the terminator.
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", &ProtocolError{Message: fmt.Sprintf("line not terminated by CRLF: %q", line)}
+	}
+	return line[:len(line)-2], nil
+}
+
+func parseLength(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < -1 {
+		return 0, &ProtocolError{Message: fmt.Sprintf("invalid length %q", s)}
+	}
+	return n, nil
+}
+
+// readBulk reads a length-prefixed payload with io.ReadFull, so large values
+// split across several TCP segments are read completely.
+func readBulk(reader *bufio.Reader, lengthStr string) (interface{}, error) {
+	n, err := parseLength(lengthStr)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	if n > maxBulkLen {
+		return nil, &ProtocolError{Message: fmt.Sprintf("bulk length %d exceeds limit", n)}
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, &ProtocolError{Message: "bulk string not terminated by CRLF"}
+	}
+	return string(buf[:n]), nil
+}
+
+func readArray(reader *bufio.Reader, n int) ([]interface{}, error) {
+	if n < 0 {
+		return nil, nil
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		item, err := readReply(reader)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+func replyKey(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// writeArgs encodes a command as a RESP array of bulk strings. Arguments are
+// converted up front so an unsupported type never leaves a half-written
+// command in the buffer.
+func writeArgs(writer *bufio.Writer, args []interface{}) error {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		s, err := argString(arg)
+		if err != nil {
+			return err
+		}
+		strs[i] = s
+	}
+
+	writer.WriteByte('*')
+	writer.WriteString(strconv.Itoa(len(strs)))
+	writer.WriteString("\r\n")
+	for _, s := range strs {
+		writer.WriteByte('$')
+		writer.WriteString(strconv.Itoa(len(s)))
+		writer.WriteString("\r\n")
+		writer.WriteString(s)
+		writer.WriteString("\r\n")
+	}
+	return nil
+}
+
+func argString(arg interface{}) (string, error) {
+	switch v := arg.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Duration:
+		return strconv.FormatInt(int64(v/time.Millisecond), 10), nil
+	case nil:
+		// Commands have no null argument; sending "" would silently store
+		// an empty string.
+		return "", fmt.Errorf("redis: nil argument")
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("redis: unsupported argument type %T", arg)
+}
+
+// Reply conversion helpers. Each takes the result of Do so that calls can be
+// written as toString(r.Do(ctx, "GET", key)).
+
+func toString(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return "", ErrNil
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case *big.Int:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("redis: unexpected reply type %T for string", reply)
+}
+
+func toInt64(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return 0, ErrNil
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("redis: unexpected reply type %T for integer", reply)
+}
+
+// toStrings converts an array reply. Null elements become empty strings.
+func toStrings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		if reply == nil {
+			return nil, ErrNil
+		}
+		return nil, fmt.Errorf("redis: unexpected reply type %T for array", reply)
+	}
+	out := make([]string, len(items))
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		s, err := toString(item, nil)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+// toStringMap accepts both a RESP3 map and the flat key/value array RESP2
+// uses for the same commands.
+func toStringMap(reply interface{}, err error) (map[string]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch v := reply.(type) {
+	case map[string]interface{}:
+		out := make(map[string]string, len(v))
+		for key, value := range v {
+			s, err := toString(value, nil)
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+			out[key] = s
+		}
+		return out, nil
+	case []interface{}:
+		if len(v)%2 != 0 {
+			return nil, fmt.Errorf("redis: odd number of elements (%d) in key/value reply", len(v))
+		}
+		out := make(map[string]string, len(v)/2)
+		for i := 0; i < len(v); i += 2 {
+			key, err := toString(v[i], nil)
+			if err != nil {
+				return nil, err
+			}
+			value, err := toString(v[i+1], nil)
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+			out[key] = value
+		}
+		return out, nil
+	case nil:
+		return nil, ErrNil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %T for map", reply)
+}
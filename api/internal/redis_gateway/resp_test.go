@@ -0,0 +1,139 @@
+package redis_gateway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want interface{}
+	}{
+		{"simple string", "+OK\r\n", "OK"},
+		{"empty simple string", "+\r\n", ""},
+		{"error", "-WRONGTYPE Operation against a key\r\n", &RedisError{Code: "WRONGTYPE", Message: "WRONGTYPE Operation against a key"}},
+		{"error without code", "-unknown command\r\n", &RedisError{Message: "unknown command"}},
+		{"integer", ":-42\r\n", int64(-42)},
+		{"bulk string", "$5\r\nhello\r\n", "hello"},
+		{"bulk string with CRLF inside", "$4\r\na\r\nb\r\n", "a\r\nb"},
+		{"empty bulk string", "$0\r\n\r\n", ""},
+		{"null bulk string", "$-1\r\n", nil},
+		{"null array", "*-1\r\n", nil},
+		{"empty array", "*0\r\n", []interface{}{}},
+		{"array", "*3\r\n:1\r\n$1\r\na\r\n$-1\r\n", []interface{}{int64(1), "a", nil}},
+		{"nested array", "*2\r\n*2\r\n+a\r\n+b\r\n*1\r\n*0\r\n", []interface{}{[]interface{}{"a", "b"}, []interface{}{[]interface{}{}}}},
+		{"array holding an error", "*2\r\n+OK\r\n-ERR no\r\n", []interface{}{"OK", &RedisError{Code: "ERR", Message: "ERR no"}}},
+		{"null", "_\r\n", nil},
+		{"double", ",1.5\r\n", 1.5},
+		{"boolean true", "#t\r\n", true},
+		{"boolean false", "#f\r\n", false},
+		{"big number", "(3492890328409238509324850943850943825024385\r\n", bigInt("3492890328409238509324850943850943825024385")},
+		{"blob error", "!9\r\nERR nope!\r\n", &RedisError{Code: "ERR", Message: "ERR nope!"}},
+		{"verbatim string", "=15\r\ntxt:Some string\r\n", "Some string"},
+		{"set", "~2\r\n+a\r\n:1\r\n", []interface{}{"a", int64(1)}},
+		{"map", "%2\r\n+first\r\n:1\r\n$6\r\nsecond\r\n_\r\n", map[string]interface{}{"first": int64(1), "second": nil}},
+		{"map with integer key", "%1\r\n:7\r\n+seven\r\n", map[string]interface{}{"7": "seven"}},
+		{"nested map", "%1\r\n+m\r\n%1\r\n+k\r\n*1\r\n+v\r\n", map[string]interface{}{"m": map[string]interface{}{"k": []interface{}{"v"}}}},
+		{"attribute is dropped", "|1\r\n+ttl\r\n:3600\r\n$3\r\nval\r\n", "val"},
+		{"push", ">3\r\n+message\r\n+news\r\n$2\r\nhi\r\n", &Push{Kind: "message", Data: []interface{}{"news", "hi"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.in))
+			got, err := readReply(reader)
+			if err != nil {
+				t.Fatalf("readReply(%q): %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("readReply(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+			if reader.Buffered() != 0 {
+				t.Fatalf("readReply(%q) left %d bytes unread", tt.in, reader.Buffered())
+			}
+		})
+	}
+}
+
+func bigInt(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	return n
+}
+
+func TestReadReplyMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantEOF bool
+	}{
+		{"empty line", "\r\n", false},
+		{"missing CR", "+OK\n", false},
+		{"unknown type", "?1\r\n", false},
+		{"bad integer", ":12a\r\n", false},
+		{"bad length", "$x\r\n", false},
+		{"negative length", "*-2\r\n", false},
+		{"bulk without CRLF", "$3\r\nabcde", false},
+		{"bulk too long", "$536870913\r\n", false},
+		{"bad double", ",one\r\n", false},
+		{"bad boolean", "#x\r\n", false},
+		{"bad big number", "(12.5\r\n", false},
+		{"bad element", "*2\r\n:1\r\n:x\r\n", false},
+		{"bad map value", "%1\r\n+k\r\n#?\r\n", false},
+		{"truncated line", "+OK", true},
+		{"truncated bulk", "$5\r\nhel", true},
+		{"truncated array", "*3\r\n:1\r\n:2\r\n", true},
+		{"truncated map", "%2\r\n+a\r\n:1\r\n+b\r\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReply(bufio.NewReader(strings.NewReader(tt.in)))
+			if err == nil {
+				t.Fatalf("readReply(%q) = %#v, want error", tt.in, got)
+			}
+			if tt.wantEOF {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("readReply(%q) error = %v, want EOF", tt.in, err)
+				}
+				return
+			}
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) {
+				t.Fatalf("readReply(%q) error = %v, want *ProtocolError", tt.in, err)
+			}
+		})
+	}
+}
+
+func TestWriteArgs(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	args := []interface{}{"SET", []byte("k"), 42, int64(-1), uint64(7), 1.5, true, 2 * time.Second, ""}
+	if err := writeArgs(w, args); err != nil {
+		t.Fatalf("writeArgs: %v", err)
+	}
+	w.Flush()
+	want := "*9\r\n$3\r\nSET\r\n$1\r\nk\r\n$2\r\n42\r\n$2\r\n-1\r\n$1\r\n7\r\n$3\r\n1.5\r\n$1\r\n1\r\n$4\r\n2000\r\n$0\r\n\r\n"
+	if buf.String() != want {
+		t.Fatalf("writeArgs wrote %q, want %q", buf.String(), want)
+	}
+
+	for _, bad := range []interface{}{nil, struct{}{}, []string{"a"}} {
+		buf.Reset()
+		w := bufio.NewWriter(&buf)
+		if err := writeArgs(w, []interface{}{"SET", "k", bad}); err == nil {
+			t.Errorf("writeArgs accepted %#v", bad)
+		}
+		w.Flush()
+		if buf.Len() != 0 {
+			t.Errorf("writeArgs left %q in the buffer after rejecting %#v", buf.String(), bad)
+		}
+	}
+}
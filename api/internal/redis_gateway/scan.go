@@ -0,0 +1,194 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ScanOptions maps to the optional MATCH, COUNT and TYPE arguments of SCAN.
+// Zero values leave the argument out. TYPE needs Redis 6.0; older servers
+// reject it, and Scan then filters each page with one TYPE per key instead.
+type ScanOptions struct {
+	Match string
+	Count int64
+	Type  string
+}
+
+// Scan runs a single SCAN step and returns the keys of that page together
+// with the cursor for the next call. A returned cursor of 0 means the
+// iteration is complete.
+func (r *RedisClient) Scan(ctx context.Context, cursor uint64, opts ScanOptions) ([]string, uint64, error) {
+	if opts.Type == "" {
+		return r.scan(ctx, cursor, opts)
+	}
+	if atomic.LoadInt32(&r.scanTypeUnsupported) == 1 {
+		return r.scanFilterType(ctx, cursor, opts)
+	}
+	keys, next, err := r.scan(ctx, cursor, opts)
+	var redisErr *RedisError
+	if errors.As(err, &redisErr) && redisErr.Code == "ERR" && strings.Contains(strings.ToLower(redisErr.Message), "syntax") {
+		log.Printf("[REDIS] WARNING: Server rejected SCAN ... TYPE (needs Redis 6.0), filtering keys with TYPE instead")
+		atomic.StoreInt32(&r.scanTypeUnsupported, 1)
+		return r.scanFilterType(ctx, cursor, opts)
+	}
+	return keys, next, err
+}
+
+// scanFilterType runs a SCAN step without TYPE and keeps the keys whose
+// TYPE matches opts.Type. Keys deleted in between report "none" and are
+// dropped.
+func (r *RedisClient) scanFilterType(ctx context.Context, cursor uint64, opts ScanOptions) ([]string, uint64, error) {
+	typ := opts.Type
+	opts.Type = ""
+	keys, next, err := r.scan(ctx, cursor, opts)
+	if err != nil || len(keys) == 0 {
+		return keys, next, err
+	}
+
+	pipe := r.Pipeline()
+	for _, key := range keys {
+		pipe.Do("TYPE", key)
+	}
+	cmds, err := pipe.Exec(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	matched := keys[:0]
+	for i, cmd := range cmds {
+		if t, _ := cmd.String(); t == typ {
+			matched = append(matched, keys[i])
+		}
+	}
+	return matched, next, nil
+}
+
+func (r *RedisClient) scan(ctx context.Context, cursor uint64, opts ScanOptions) ([]string, uint64, error) {
+	args := []interface{}{"SCAN", cursor}
+	if opts.Match != "" {
+		args = append(args, "MATCH", opts.Match)
+	}
+	if opts.Count > 0 {
+		args = append(args, "COUNT", opts.Count)
+	}
+	if opts.Type != "" {
+		args = append(args, "TYPE", opts.Type)
+	}
+
+	reply, err := r.Do(ctx, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return nil, 0, fmt.Errorf("redis: unexpected SCAN reply %T", reply)
+	}
+	cursorStr, err := toString(items[0], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Cursors are unsigned 64-bit and may exceed the int64 range.
+	next, err := strconv.ParseUint(cursorStr, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis: invalid SCAN cursor %q", cursorStr)
+	}
+	keys, err := toStrings(items[1], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return keys, next, nil
+}
+
+// ScanIterator walks the whole keyspace with SCAN. It keeps calling SCAN
+// until the server hands back cursor 0, so pages that come back empty (common
+// with MATCH or TYPE) do not end the iteration early. SCAN may return a key
+// more than once while the server rehashes; the iterator drops duplicates.
+type ScanIterator struct {
+	client  *RedisClient
+	ctx     context.Context
+	opts    ScanOptions
+	cursor  uint64
+	started bool
+	page    []string
+	pos     int
+	key     string
+	seen    map[string]struct{}
+	err     error
+}
+
+func (r *RedisClient) ScanIter(ctx context.Context, opts ScanOptions) *ScanIterator {
+	return &ScanIterator{
+		client: r,
+		ctx:    ctx,
+		opts:   opts,
+		seen:   make(map[string]struct{}),
+	}
+}
+
+// Next advances to the next key, fetching pages as needed. It returns false
+// when the iteration is complete or an error occurred; check Err.
+func (it *ScanIterator) Next() bool {
+	for {
+		if it.err != nil {
+			return false
+		}
+		for it.pos < len(it.page) {
+			key := it.page[it.pos]
+			it.pos++
+			if _, dup := it.seen[key]; dup {
+				continue
+			}
+			it.seen[key] = struct{}{}
+			it.key = key
+			return true
+		}
+		if it.started && it.cursor == 0 {
+			return false
+		}
+
+		keys, next, err := it.client.Scan(it.ctx, it.cursor, it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.started = true
+		it.cursor = next
+		it.page = keys
+		it.pos = 0
+	}
+}
+
+func (it *ScanIterator) Key() string {
+	return it.key
+}
+
+func (it *ScanIterator) Err() error {
+	return it.err
+}
+
+// MGet returns one entry per key: the value as a string, or nil when the key
+// does not exist or holds a non-string value.
+func (r *RedisClient) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return []interface{}{}, nil
+	}
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "MGET")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	reply, err := r.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(keys) {
+		return nil, fmt.Errorf("redis: unexpected MGET reply %T", reply)
+	}
+	return values, nil
+}
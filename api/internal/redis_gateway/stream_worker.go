@@ -0,0 +1,295 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"api/internal/backoff"
+)
+
+const (
+	DefaultStreamMaxDeliveries = 5
+	DefaultStreamBatchSize     = 10
+	DefaultStreamBlock         = 5 * time.Second
+	DefaultStreamClaimMinIdle  = 30 * time.Second
+
+	streamWorkerMinBackoff = 500 * time.Millisecond
+	streamWorkerMaxBackoff = 30 * time.Second
+)
+
+// StreamWorkerConfig describes the stream a StreamWorker consumes and how
+// failed entries are retried. Zero values use the defaults above.
+type StreamWorkerConfig struct {
+	Stream   string
+	Group    string
+	Consumer string
+	// StartID is where the group starts if the worker has to create it:
+	// "0" (the default) for the whole stream, "$" for new entries only.
+	StartID string
+	// BatchSize is the COUNT of each XREADGROUP and XPENDING.
+	BatchSize int64
+	// Block is how long XREADGROUP waits for new entries.
+	Block time.Duration
+	// ClaimMinIdle is how long an entry stays pending, because its handler
+	// failed or its consumer died, before it is claimed and retried.
+	ClaimMinIdle time.Duration
+	// MaxDeliveries is how many times an entry is handed to a handler
+	// before it is moved to DeadLetterStream instead.
+	MaxDeliveries int64
+	// DeadLetterStream defaults to Stream + ":dead".
+	DeadLetterStream string
+}
+
+// StreamHandler processes one entry. Returning nil acknowledges it; an
+// error leaves it pending so that it is retried.
+type StreamHandler func(ctx context.Context, msg XMessage) error
+
+// StreamWorker consumes a stream as a member of a consumer group with
+// at-least-once semantics: an entry is acknowledged only after its handler
+// succeeds, so a handler may see the same entry more than once and should
+// be idempotent. Entries that fail MaxDeliveries times are copied to the
+// dead-letter stream, with the dlq_* fields describing where they came
+// from, and acknowledged.
+//
+//	w := client.NewStreamWorker(redis_gateway.StreamWorkerConfig{
+//		Stream: "users:stream", Group: "mailer", Consumer: hostname,
+//	}, handle)
+//	go w.Run(ctx)
+type StreamWorker struct {
+	client  *RedisClient
+	config  StreamWorkerConfig
+	handler StreamHandler
+
+	lastClaim time.Time
+}
+
+func (r *RedisClient) NewStreamWorker(config StreamWorkerConfig, handler StreamHandler) *StreamWorker {
+	if config.StartID == "" {
+		config.StartID = "0"
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultStreamBatchSize
+	}
+	if config.Block <= 0 {
+		config.Block = DefaultStreamBlock
+	}
+	if config.ClaimMinIdle <= 0 {
+		config.ClaimMinIdle = DefaultStreamClaimMinIdle
+	}
+	if config.MaxDeliveries <= 0 {
+		config.MaxDeliveries = DefaultStreamMaxDeliveries
+	}
+	if config.DeadLetterStream == "" {
+		config.DeadLetterStream = config.Stream + ":dead"
+	}
+	return &StreamWorker{client: r, config: config, handler: handler}
+}
+
+// Run creates the group if needed and processes entries until ctx is done.
+// Errors talking to Redis are logged and retried with backoff; Run only
+// returns ctx.Err().
+func (w *StreamWorker) Run(ctx context.Context) error {
+	c := w.config
+	log.Printf("[REDIS] Stream worker '%s' starting on '%s' (group=%s, batch=%d, block=%v, claim_min_idle=%v, max_deliveries=%d, dead_letter=%s)",
+		c.Consumer, c.Stream, c.Group, c.BatchSize, c.Block, c.ClaimMinIdle, c.MaxDeliveries, c.DeadLetterStream)
+
+	bo := backoff.Backoff{Min: streamWorkerMinBackoff, Max: streamWorkerMaxBackoff, Jitter: 0.5}
+	groupReady := false
+	for ctx.Err() == nil {
+		err := func() error {
+			if !groupReady {
+				if err := w.createGroup(ctx); err != nil {
+					return err
+				}
+				groupReady = true
+			}
+			if time.Since(w.lastClaim) >= c.ClaimMinIdle {
+				if err := w.reclaim(ctx); err != nil {
+					return err
+				}
+				w.lastClaim = time.Now()
+			}
+			return w.readNew(ctx)
+		}()
+		if err == nil {
+			bo.Reset()
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		var redisErr *RedisError
+		if errors.As(err, &redisErr) && redisErr.Code == "NOGROUP" {
+			// The stream or the group was deleted under us.
+			groupReady = false
+		}
+		delay := bo.Next()
+		log.Printf("[REDIS] WARNING: Stream worker '%s' on '%s': %v (retrying in %v)", c.Consumer, c.Stream, err, delay)
+		if backoff.Wait(ctx, delay) != nil {
+			break
+		}
+	}
+	log.Printf("[REDIS] Stream worker '%s' on '%s' stopped", c.Consumer, c.Stream)
+	return ctx.Err()
+}
+
+func (w *StreamWorker) createGroup(ctx context.Context) error {
+	err := w.client.XGroupCreate(ctx, w.config.Stream, w.config.Group, w.config.StartID, true)
+	var redisErr *RedisError
+	if errors.As(err, &redisErr) && redisErr.Code == "BUSYGROUP" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("creating group %s: %w", w.config.Group, err)
+	}
+	log.Printf("[REDIS] Created consumer group '%s' on '%s' at %s", w.config.Group, w.config.Stream, w.config.StartID)
+	return nil
+}
+
+func (w *StreamWorker) readNew(ctx context.Context) error {
+	streams, err := w.client.XReadGroup(ctx, XReadGroupArgs{
+		Group:    w.config.Group,
+		Consumer: w.config.Consumer,
+		Streams:  []string{w.config.Stream},
+		Count:    w.config.BatchSize,
+		Block:    w.config.Block,
+	})
+	if err != nil {
+		return err
+	}
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			if err := w.handle(ctx, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reclaim goes through the entries of the group that have been pending for
+// ClaimMinIdle. Those delivered MaxDeliveries times are dead-lettered, the
+// others are claimed by this consumer and handled again.
+func (w *StreamWorker) reclaim(ctx context.Context) error {
+	start := "-"
+	for {
+		pending, err := w.client.XPendingExt(ctx, XPendingExtArgs{
+			Stream: w.config.Stream,
+			Group:  w.config.Group,
+			Idle:   w.config.ClaimMinIdle,
+			Start:  start,
+			Count:  w.config.BatchSize,
+		})
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		var retry, dead []string
+		deliveries := make(map[string]int64, len(pending))
+		for _, entry := range pending {
+			deliveries[entry.ID] = entry.RetryCount
+			if entry.RetryCount >= w.config.MaxDeliveries {
+				dead = append(dead, entry.ID)
+			} else {
+				retry = append(retry, entry.ID)
+			}
+		}
+
+		if err := w.claim(ctx, dead, func(msg XMessage) error {
+			return w.deadLetter(ctx, msg, deliveries[msg.ID])
+		}); err != nil {
+			return err
+		}
+		if err := w.claim(ctx, retry, func(msg XMessage) error {
+			log.Printf("[REDIS] Stream worker '%s' retrying %s (delivery %d)", w.config.Consumer, msg.ID, deliveries[msg.ID]+1)
+			return w.handle(ctx, msg)
+		}); err != nil {
+			return err
+		}
+
+		if int64(len(pending)) < w.config.BatchSize {
+			return nil
+		}
+		start = "(" + pending[len(pending)-1].ID
+	}
+}
+
+// claim takes over ids and passes each claimed entry to fn. Entries another
+// consumer claimed first are not returned by XCLAIM and are skipped; entries
+// deleted from the stream while pending are acknowledged.
+func (w *StreamWorker) claim(ctx context.Context, ids []string, fn func(msg XMessage) error) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	messages, err := w.client.XClaim(ctx, XClaimArgs{
+		Stream:   w.config.Stream,
+		Group:    w.config.Group,
+		Consumer: w.config.Consumer,
+		MinIdle:  w.config.ClaimMinIdle,
+		IDs:      ids,
+	})
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if msg.Values == nil {
+			log.Printf("[REDIS] Stream worker '%s': entry %s was deleted, acknowledging", w.config.Consumer, msg.ID)
+			if err := w.ack(ctx, msg.ID, "deleted"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle runs the handler and acknowledges on success. A handler error is
+// not returned: the entry stays pending and is retried by reclaim.
+func (w *StreamWorker) handle(ctx context.Context, msg XMessage) error {
+	start := time.Now()
+	if err := w.handler(ctx, msg); err != nil {
+		log.Printf("[REDIS] WARNING: Stream worker '%s' failed to handle %s on '%s': %v", w.config.Consumer, msg.ID, w.config.Stream, err)
+		w.client.incrementCounter("redis_stream_messages_total", map[string]string{"stream": w.config.Stream, "result": "failed"})
+		return nil
+	}
+	log.Printf("[REDIS] Stream worker '%s' handled %s in %v", w.config.Consumer, msg.ID, time.Since(start))
+	return w.ack(ctx, msg.ID, "acked")
+}
+
+func (w *StreamWorker) deadLetter(ctx context.Context, msg XMessage, deliveries int64) error {
+	values := make(map[string]interface{}, len(msg.Values)+4)
+	for field, value := range msg.Values {
+		values[field] = value
+	}
+	values["dlq_stream"] = w.config.Stream
+	values["dlq_id"] = msg.ID
+	values["dlq_group"] = w.config.Group
+	values["dlq_deliveries"] = strconv.FormatInt(deliveries, 10)
+
+	id, err := w.client.XAdd(ctx, XAddArgs{Stream: w.config.DeadLetterStream, Values: values})
+	if err != nil {
+		return fmt.Errorf("dead-lettering %s: %w", msg.ID, err)
+	}
+	log.Printf("[REDIS] WARNING: Stream worker '%s' moved %s to '%s' as %s after %d deliveries",
+		w.config.Consumer, msg.ID, w.config.DeadLetterStream, id, deliveries)
+	return w.ack(ctx, msg.ID, "dead_lettered")
+}
+
+func (w *StreamWorker) ack(ctx context.Context, id, result string) error {
+	if _, err := w.client.XAck(ctx, w.config.Stream, w.config.Group, id); err != nil {
+		return fmt.Errorf("acknowledging %s: %w", id, err)
+	}
+	w.client.incrementCounter("redis_stream_messages_total", map[string]string{"stream": w.config.Stream, "result": result})
+	return nil
+}
@@ -0,0 +1,324 @@
+package redis_gateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a RESP2 server that answers each command with handle. It is
+// only meant for driving the client through a fixed script.
+type fakeRedis struct {
+	ln     net.Listener
+	handle func(args []string) interface{}
+}
+
+func startFakeRedis(t *testing.T, handle func(args []string) interface{}) *RedisClient {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeRedis{ln: ln, handle: handle}
+	go srv.serve()
+
+	client, err := NewRedisClient(ln.Addr().String())
+	if err != nil {
+		ln.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		ln.Close()
+	})
+	return client
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(c)
+	}
+}
+
+func (s *fakeRedis) serveConn(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		var reply interface{}
+		switch strings.ToUpper(args[0]) {
+		case "PING", "CLIENT", "SELECT":
+			reply = "OK"
+		default:
+			reply = s.handle(args)
+		}
+		if _, err := io.WriteString(c, encodeReply(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func encodeReply(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "$-1\r\n"
+	case int64:
+		return fmt.Sprintf(":%d\r\n", v)
+	case string:
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case *RedisError:
+		return "-" + v.Message + "\r\n"
+	case []interface{}:
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(v))
+		for _, item := range v {
+			b.WriteString(encodeReply(item))
+		}
+		return b.String()
+	}
+	panic(fmt.Sprintf("encodeReply: unsupported %T", v))
+}
+
+func TestStreamWorkerReclaim(t *testing.T) {
+	fields := map[string]string{"name": "bob"}
+	tests := []struct {
+		name    string
+		pending []XPendingEntry
+		// claimed holds the entries XCLAIM returns; a nil value is an entry
+		// deleted from the stream, a missing ID one claimed elsewhere.
+		claimed map[string]map[string]string
+		failing map[string]bool
+		want    []string
+	}{
+		{
+			name:    "below the limit is retried and acked",
+			pending: []XPendingEntry{{ID: "1-0", RetryCount: 2}},
+			claimed: map[string]map[string]string{"1-0": fields},
+			want:    []string{"XPENDING -", "XCLAIM 1-0", "handle 1-0", "XACK 1-0"},
+		},
+		{
+			name:    "at the limit is dead-lettered",
+			pending: []XPendingEntry{{ID: "1-0", RetryCount: 3}},
+			claimed: map[string]map[string]string{"1-0": fields},
+			want: []string{
+				"XPENDING -", "XCLAIM 1-0",
+				"XADD users:dead dlq_deliveries=3 dlq_group=mailer dlq_id=1-0 dlq_stream=users name=bob",
+				"XACK 1-0",
+			},
+		},
+		{
+			name:    "deleted entry is acked without the handler",
+			pending: []XPendingEntry{{ID: "1-0", RetryCount: 1}},
+			claimed: map[string]map[string]string{"1-0": nil},
+			want:    []string{"XPENDING -", "XCLAIM 1-0", "XACK 1-0"},
+		},
+		{
+			name:    "deleted entry at the limit is acked without dead-lettering",
+			pending: []XPendingEntry{{ID: "1-0", RetryCount: 3}},
+			claimed: map[string]map[string]string{"1-0": nil},
+			want:    []string{"XPENDING -", "XCLAIM 1-0", "XACK 1-0"},
+		},
+		{
+			name:    "handler failure leaves the entry pending",
+			pending: []XPendingEntry{{ID: "1-0", RetryCount: 1}},
+			claimed: map[string]map[string]string{"1-0": fields},
+			failing: map[string]bool{"1-0": true},
+			want:    []string{"XPENDING -", "XCLAIM 1-0", "handle 1-0"},
+		},
+		{
+			name:    "claimed by another consumer first",
+			pending: []XPendingEntry{{ID: "1-0", RetryCount: 1}},
+			claimed: map[string]map[string]string{},
+			want:    []string{"XPENDING -", "XCLAIM 1-0"},
+		},
+		{
+			name: "nothing pending",
+			want: []string{"XPENDING -"},
+		},
+		{
+			name: "full page goes on to the next",
+			pending: []XPendingEntry{
+				{ID: "1-0", RetryCount: 1},
+				{ID: "2-0", RetryCount: 5},
+				{ID: "3-0", RetryCount: 2},
+			},
+			claimed: map[string]map[string]string{"1-0": fields, "2-0": fields, "3-0": fields},
+			want: []string{
+				"XPENDING -",
+				"XCLAIM 2-0",
+				"XADD users:dead dlq_deliveries=5 dlq_group=mailer dlq_id=2-0 dlq_stream=users name=bob",
+				"XACK 2-0",
+				"XCLAIM 1-0 3-0",
+				"handle 1-0", "XACK 1-0",
+				"handle 3-0", "XACK 3-0",
+				"XPENDING (3-0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var events []string
+			record := func(event string) {
+				mu.Lock()
+				events = append(events, event)
+				mu.Unlock()
+			}
+
+			client := startFakeRedis(t, func(args []string) interface{} {
+				switch args[0] {
+				case "XPENDING":
+					// XPENDING stream group IDLE ms start end count
+					record("XPENDING " + args[5])
+					reply := []interface{}{}
+					if args[5] == "-" {
+						for _, entry := range tt.pending {
+							reply = append(reply, []interface{}{entry.ID, "gone", int64(60000), entry.RetryCount})
+						}
+					}
+					return reply
+				case "XCLAIM":
+					// XCLAIM stream group consumer min-idle id...
+					ids := args[5:]
+					record("XCLAIM " + strings.Join(ids, " "))
+					reply := []interface{}{}
+					for _, id := range ids {
+						values, ok := tt.claimed[id]
+						if !ok {
+							continue
+						}
+						if values == nil {
+							reply = append(reply, []interface{}{id, nil})
+							continue
+						}
+						flat := []interface{}{}
+						for field, value := range values {
+							flat = append(flat, field, value)
+						}
+						reply = append(reply, []interface{}{id, flat})
+					}
+					return reply
+				case "XADD":
+					// XADD stream * field value ...
+					pairs := []string{}
+					for i := 3; i+1 < len(args); i += 2 {
+						pairs = append(pairs, args[i]+"="+args[i+1])
+					}
+					sort.Strings(pairs)
+					record("XADD " + args[1] + " " + strings.Join(pairs, " "))
+					return "9-0"
+				case "XACK":
+					record("XACK " + strings.Join(args[3:], " "))
+					return int64(1)
+				}
+				return &RedisError{Code: "ERR", Message: "ERR unknown command '" + args[0] + "'"}
+			})
+
+			w := client.NewStreamWorker(StreamWorkerConfig{
+				Stream:        "users",
+				Group:         "mailer",
+				Consumer:      "worker",
+				BatchSize:     3,
+				ClaimMinIdle:  time.Second,
+				MaxDeliveries: 3,
+			}, func(ctx context.Context, msg XMessage) error {
+				record("handle " + msg.ID)
+				if !reflect.DeepEqual(msg.Values, fields) {
+					t.Errorf("handler got %s with %v, want %v", msg.ID, msg.Values, fields)
+				}
+				if tt.failing[msg.ID] {
+					return errors.New("handler failed")
+				}
+				return nil
+			})
+
+			if err := w.reclaim(context.Background()); err != nil {
+				t.Fatalf("reclaim() error = %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(events, tt.want) {
+				t.Fatalf("events:\n  %s\nwant:\n  %s", strings.Join(events, "\n  "), strings.Join(tt.want, "\n  "))
+			}
+		})
+	}
+}
+
+func TestStreamWorkerReclaimStopsOnDeadLetterFailure(t *testing.T) {
+	var acked []string
+	var mu sync.Mutex
+	client := startFakeRedis(t, func(args []string) interface{} {
+		switch args[0] {
+		case "XPENDING":
+			return []interface{}{[]interface{}{"1-0", "gone", int64(60000), int64(3)}}
+		case "XCLAIM":
+			return []interface{}{[]interface{}{"1-0", []interface{}{"name", "bob"}}}
+		case "XACK":
+			mu.Lock()
+			acked = append(acked, args[3])
+			mu.Unlock()
+			return int64(1)
+		}
+		return &RedisError{Code: "OOM", Message: "OOM command not allowed when used memory > 'maxmemory'"}
+	})
+
+	w := client.NewStreamWorker(StreamWorkerConfig{Stream: "users", Group: "mailer", Consumer: "worker", MaxDeliveries: 3},
+		func(ctx context.Context, msg XMessage) error {
+			t.Errorf("handler called for %s", msg.ID)
+			return nil
+		})
+	err := w.reclaim(context.Background())
+	var redisErr *RedisError
+	if !errors.As(err, &redisErr) || redisErr.Code != "OOM" {
+		t.Fatalf("reclaim() error = %v, want the XADD failure", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(acked) != 0 {
+		t.Fatalf("acknowledged %v although the dead-letter XADD failed", acked)
+	}
+}
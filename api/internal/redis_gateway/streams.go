@@ -0,0 +1,421 @@
+package redis_gateway
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// XMessage is one stream entry. Values is nil for an entry that is still
+// pending but has since been deleted from the stream.
+type XMessage struct {
+	ID     string
+	Values map[string]string
+}
+
+// XStream holds the entries read from one stream.
+type XStream struct {
+	Stream   string
+	Messages []XMessage
+}
+
+// XAddArgs maps to XADD. ID defaults to "*" (server-assigned). A MaxLen
+// above zero trims the stream, approximately (MAXLEN ~) when Approx is set,
+// which is much cheaper.
+type XAddArgs struct {
+	Stream     string
+	ID         string
+	MaxLen     int64
+	Approx     bool
+	NoMkStream bool
+	Values     map[string]interface{}
+}
+
+// XAdd appends an entry and returns its ID.
+func (r *RedisClient) XAdd(ctx context.Context, a XAddArgs) (string, error) {
+	args := []interface{}{"XADD", a.Stream}
+	if a.NoMkStream {
+		args = append(args, "NOMKSTREAM")
+	}
+	if a.MaxLen > 0 {
+		if a.Approx {
+			args = append(args, "MAXLEN", "~", a.MaxLen)
+		} else {
+			args = append(args, "MAXLEN", a.MaxLen)
+		}
+	}
+	id := a.ID
+	if id == "" {
+		id = "*"
+	}
+	args = append(args, id)
+
+	// Fields are sent in a fixed order so entries are reproducible.
+	fields := make([]string, 0, len(a.Values))
+	for field := range a.Values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		args = append(args, field, a.Values[field])
+	}
+	return toString(r.Do(ctx, args...))
+}
+
+// XGroupCreate creates a consumer group that starts reading after start
+// ("$" for new entries only, "0" for the whole stream). With mkStream the
+// stream is created if it does not exist. A group that already exists is
+// reported as a *RedisError with Code "BUSYGROUP".
+func (r *RedisClient) XGroupCreate(ctx context.Context, stream, group, start string, mkStream bool) error {
+	args := []interface{}{"XGROUP", "CREATE", stream, group, start}
+	if mkStream {
+		args = append(args, "MKSTREAM")
+	}
+	_, err := r.Do(ctx, args...)
+	return err
+}
+
+// XReadGroupArgs maps to XREADGROUP. ID applies to every stream and
+// defaults to ">" (entries never delivered to the group); "0" re-reads the
+// consumer's own pending entries. A Block of zero does not block.
+type XReadGroupArgs struct {
+	Group    string
+	Consumer string
+	Streams  []string
+	ID       string
+	Count    int64
+	Block    time.Duration
+	NoAck    bool
+}
+
+// XReadGroup reads entries as a group consumer. When Block runs out without
+// new entries it returns no streams and no error.
+func (r *RedisClient) XReadGroup(ctx context.Context, a XReadGroupArgs) ([]XStream, error) {
+	args := []interface{}{"XREADGROUP", "GROUP", a.Group, a.Consumer}
+	if a.Count > 0 {
+		args = append(args, "COUNT", a.Count)
+	}
+	if a.Block > 0 {
+		args = append(args, "BLOCK", a.Block)
+	}
+	if a.NoAck {
+		args = append(args, "NOACK")
+	}
+	args = append(args, "STREAMS")
+	for _, stream := range a.Streams {
+		args = append(args, stream)
+	}
+	id := a.ID
+	if id == "" {
+		id = ">"
+	}
+	for range a.Streams {
+		args = append(args, id)
+	}
+
+	reply, err := r.doBlocking(ctx, a.Block, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseXStreams(reply)
+}
+
+// doBlocking is Do for commands that may hold the connection for up to
+// block before replying; the read deadline is extended to match. Blocking
+// commands are never retried after a lost connection.
+func (r *RedisClient) doBlocking(ctx context.Context, block time.Duration, args ...interface{}) (interface{}, error) {
+	var reply interface{}
+	err := r.withConn(ctx, false, func(cn *redisConn) error {
+		if block > 0 {
+			cn.setDeadlines(ctx, r.config.ReadTimeout+block, r.config.WriteTimeout)
+		}
+		var err error
+		reply, err = cn.do(args...)
+		return err
+	})
+	return reply, err
+}
+
+// XAck acknowledges entries and returns how many were pending.
+func (r *RedisClient) XAck(ctx context.Context, stream, group string, ids ...string) (int64, error) {
+	args := []interface{}{"XACK", stream, group}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return toInt64(r.Do(ctx, args...))
+}
+
+// XPendingSummary is the short form of XPENDING.
+type XPendingSummary struct {
+	Count     int64
+	Lower     string
+	Higher    string
+	Consumers map[string]int64
+}
+
+// XPending returns the number of pending entries of a group, their ID range
+// and how many each consumer holds.
+func (r *RedisClient) XPending(ctx context.Context, stream, group string) (*XPendingSummary, error) {
+	reply, err := r.Do(ctx, "XPENDING", stream, group)
+	if err != nil {
+		return nil, err
+	}
+	return parseXPendingSummary(reply)
+}
+
+// parseXPendingSummary decodes the short form of XPENDING: count, lowest
+// and highest ID, and [consumer, count] pairs. The IDs and consumers are
+// null when nothing is pending.
+func parseXPendingSummary(reply interface{}) (*XPendingSummary, error) {
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 4 {
+		return nil, fmt.Errorf("redis: unexpected XPENDING reply %T", reply)
+	}
+	var err error
+	summary := &XPendingSummary{Consumers: make(map[string]int64)}
+	if summary.Count, err = toInt64(items[0], nil); err != nil {
+		return nil, err
+	}
+	if summary.Count == 0 {
+		return summary, nil
+	}
+	summary.Lower, _ = toString(items[1], nil)
+	summary.Higher, _ = toString(items[2], nil)
+	consumers, _ := items[3].([]interface{})
+	for _, item := range consumers {
+		pair, ok := item.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("redis: unexpected XPENDING consumer entry %T", item)
+		}
+		name, err := toString(pair[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		count, err := toInt64(pair[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		summary.Consumers[name] = count
+	}
+	return summary, nil
+}
+
+// XPendingExtArgs maps to the extended form of XPENDING. Start and End
+// default to "-" and "+"; Consumer and Idle filter when set.
+type XPendingExtArgs struct {
+	Stream   string
+	Group    string
+	Idle     time.Duration
+	Start    string
+	End      string
+	Count    int64
+	Consumer string
+}
+
+// XPendingEntry describes one pending entry. RetryCount is the number of
+// times it has been delivered.
+type XPendingEntry struct {
+	ID         string
+	Consumer   string
+	Idle       time.Duration
+	RetryCount int64
+}
+
+func (r *RedisClient) XPendingExt(ctx context.Context, a XPendingExtArgs) ([]XPendingEntry, error) {
+	args := []interface{}{"XPENDING", a.Stream, a.Group}
+	if a.Idle > 0 {
+		args = append(args, "IDLE", a.Idle)
+	}
+	start, end := a.Start, a.End
+	if start == "" {
+		start = "-"
+	}
+	if end == "" {
+		end = "+"
+	}
+	args = append(args, start, end, a.Count)
+	if a.Consumer != "" {
+		args = append(args, a.Consumer)
+	}
+
+	reply, err := r.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseXPendingEntries(reply)
+}
+
+// parseXPendingEntries decodes the extended form of XPENDING: an array of
+// [id, consumer, idle ms, deliveries] entries.
+func parseXPendingEntries(reply interface{}) ([]XPendingEntry, error) {
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected XPENDING reply %T", reply)
+	}
+	var err error
+	entries := make([]XPendingEntry, 0, len(items))
+	for _, item := range items {
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) != 4 {
+			return nil, fmt.Errorf("redis: unexpected XPENDING entry %T", item)
+		}
+		var entry XPendingEntry
+		var idleMs int64
+		if entry.ID, err = toString(fields[0], nil); err != nil {
+			return nil, err
+		}
+		if entry.Consumer, err = toString(fields[1], nil); err != nil {
+			return nil, err
+		}
+		if idleMs, err = toInt64(fields[2], nil); err != nil {
+			return nil, err
+		}
+		if entry.RetryCount, err = toInt64(fields[3], nil); err != nil {
+			return nil, err
+		}
+		entry.Idle = time.Duration(idleMs) * time.Millisecond
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// XClaimArgs maps to XCLAIM. Only entries idle for at least MinIdle change
+// owner.
+type XClaimArgs struct {
+	Stream   string
+	Group    string
+	Consumer string
+	MinIdle  time.Duration
+	IDs      []string
+}
+
+// XClaim takes over pending entries and returns them. Claiming counts as a
+// delivery.
+func (r *RedisClient) XClaim(ctx context.Context, a XClaimArgs) ([]XMessage, error) {
+	args := []interface{}{"XCLAIM", a.Stream, a.Group, a.Consumer, a.MinIdle}
+	for _, id := range a.IDs {
+		args = append(args, id)
+	}
+	reply, err := r.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseXMessages(reply)
+}
+
+// XAutoClaimArgs maps to XAUTOCLAIM. Start defaults to "0-0".
+type XAutoClaimArgs struct {
+	Stream   string
+	Group    string
+	Consumer string
+	MinIdle  time.Duration
+	Start    string
+	Count    int64
+}
+
+// XAutoClaim claims pending entries idle for at least MinIdle, scanning from
+// Start. It returns them with the ID to pass as Start next time; "0-0"
+// means the scan is complete.
+func (r *RedisClient) XAutoClaim(ctx context.Context, a XAutoClaimArgs) ([]XMessage, string, error) {
+	start := a.Start
+	if start == "" {
+		start = "0-0"
+	}
+	args := []interface{}{"XAUTOCLAIM", a.Stream, a.Group, a.Consumer, a.MinIdle, start}
+	if a.Count > 0 {
+		args = append(args, "COUNT", a.Count)
+	}
+	reply, err := r.Do(ctx, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	// [next-start, entries] on 6.2, plus the IDs of deleted entries on 7.0.
+	items, ok := reply.([]interface{})
+	if !ok || len(items) < 2 {
+		return nil, "", fmt.Errorf("redis: unexpected XAUTOCLAIM reply %T", reply)
+	}
+	next, err := toString(items[0], nil)
+	if err != nil {
+		return nil, "", err
+	}
+	messages, err := parseXMessages(items[1])
+	if err != nil {
+		return nil, "", err
+	}
+	return messages, next, nil
+}
+
+// parseXStreams decodes the reply of XREAD and XREADGROUP: an array of
+// [stream, entries] pairs on RESP2, a map of stream to entries on RESP3.
+func parseXStreams(reply interface{}) ([]XStream, error) {
+	switch v := reply.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		streams := make([]XStream, 0, len(v))
+		for name, entries := range v {
+			messages, err := parseXMessages(entries)
+			if err != nil {
+				return nil, err
+			}
+			streams = append(streams, XStream{Stream: name, Messages: messages})
+		}
+		sort.Slice(streams, func(i, j int) bool { return streams[i].Stream < streams[j].Stream })
+		return streams, nil
+	case []interface{}:
+		streams := make([]XStream, 0, len(v))
+		for _, item := range v {
+			pair, ok := item.([]interface{})
+			if !ok || len(pair) != 2 {
+				return nil, fmt.Errorf("redis: unexpected stream reply %T", item)
+			}
+			name, err := toString(pair[0], nil)
+			if err != nil {
+				return nil, err
+			}
+			messages, err := parseXMessages(pair[1])
+			if err != nil {
+				return nil, err
+			}
+			streams = append(streams, XStream{Stream: name, Messages: messages})
+		}
+		return streams, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected stream reply %T", reply)
+}
+
+// parseXMessages decodes an array of [id, [field, value, ...]] entries.
+// Entries deleted while pending come back as [id, nil], or as a bare nil
+// from XAUTOCLAIM on 6.2, which is skipped.
+func parseXMessages(reply interface{}) ([]XMessage, error) {
+	items, ok := reply.([]interface{})
+	if !ok {
+		if reply == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("redis: unexpected reply type %T for stream entries", reply)
+	}
+	messages := make([]XMessage, 0, len(items))
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		entry, ok := item.([]interface{})
+		if !ok || len(entry) != 2 {
+			return nil, fmt.Errorf("redis: unexpected stream entry %T", item)
+		}
+		id, err := toString(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		msg := XMessage{ID: id}
+		if entry[1] != nil {
+			if msg.Values, err = toStringMap(entry[1], nil); err != nil {
+				return nil, err
+			}
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
@@ -0,0 +1,198 @@
+package redis_gateway
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseXMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   interface{}
+		want    []XMessage
+		wantErr bool
+	}{
+		{"null", nil, nil, false},
+		{"empty", []interface{}{}, []XMessage{}, false},
+		{
+			"entries",
+			[]interface{}{
+				[]interface{}{"1-0", []interface{}{"name", "bob", "age", "30"}},
+				[]interface{}{"2-0", []interface{}{}},
+			},
+			[]XMessage{{ID: "1-0", Values: map[string]string{"name": "bob", "age": "30"}}, {ID: "2-0", Values: map[string]string{}}},
+			false,
+		},
+		{"RESP3 field map", []interface{}{[]interface{}{"1-0", map[string]interface{}{"name": "bob"}}}, []XMessage{{ID: "1-0", Values: map[string]string{"name": "bob"}}}, false},
+		{"deleted entry keeps its ID", []interface{}{[]interface{}{"1-0", nil}}, []XMessage{{ID: "1-0"}}, false},
+		{"bare null is skipped", []interface{}{nil, []interface{}{"2-0", []interface{}{"a", "b"}}}, []XMessage{{ID: "2-0", Values: map[string]string{"a": "b"}}}, false},
+		{"not an array", "1-0", nil, true},
+		{"entry without fields", []interface{}{[]interface{}{"1-0"}}, nil, true},
+		{"odd field list", []interface{}{[]interface{}{"1-0", []interface{}{"name"}}}, nil, true},
+		{"ID not a string", []interface{}{[]interface{}{[]interface{}{}, []interface{}{}}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseXMessages(tt.reply)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseXMessages() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseXMessages() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseXMessages() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseXStreams(t *testing.T) {
+	entries := func(id, field, value string) []interface{} {
+		return []interface{}{[]interface{}{id, []interface{}{field, value}}}
+	}
+	tests := []struct {
+		name    string
+		reply   interface{}
+		want    []XStream
+		wantErr bool
+	}{
+		{"timed out", nil, nil, false},
+		{
+			"RESP2 pairs keep their order",
+			[]interface{}{
+				[]interface{}{"b", entries("1-0", "x", "1")},
+				[]interface{}{"a", entries("2-0", "y", "2")},
+			},
+			[]XStream{
+				{Stream: "b", Messages: []XMessage{{ID: "1-0", Values: map[string]string{"x": "1"}}}},
+				{Stream: "a", Messages: []XMessage{{ID: "2-0", Values: map[string]string{"y": "2"}}}},
+			},
+			false,
+		},
+		{
+			"RESP3 map is sorted by stream",
+			map[string]interface{}{
+				"b": entries("1-0", "x", "1"),
+				"a": entries("2-0", "y", "2"),
+			},
+			[]XStream{
+				{Stream: "a", Messages: []XMessage{{ID: "2-0", Values: map[string]string{"y": "2"}}}},
+				{Stream: "b", Messages: []XMessage{{ID: "1-0", Values: map[string]string{"x": "1"}}}},
+			},
+			false,
+		},
+		{"stream without entries", []interface{}{[]interface{}{"a", []interface{}{}}}, []XStream{{Stream: "a", Messages: []XMessage{}}}, false},
+		{"pair too short", []interface{}{[]interface{}{"a"}}, nil, true},
+		{"null name", []interface{}{[]interface{}{nil, []interface{}{}}}, nil, true},
+		{"bad entries", []interface{}{[]interface{}{"a", "oops"}}, nil, true},
+		{"bad entries in map", map[string]interface{}{"a": int64(1)}, nil, true},
+		{"unexpected type", "OK", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseXStreams(tt.reply)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseXStreams() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseXStreams() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseXStreams() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseXPendingSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   interface{}
+		want    *XPendingSummary
+		wantErr bool
+	}{
+		{"nothing pending", []interface{}{int64(0), nil, nil, nil}, &XPendingSummary{Consumers: map[string]int64{}}, false},
+		{
+			"pending",
+			[]interface{}{int64(3), "1-0", "5-0", []interface{}{
+				[]interface{}{"alice", "2"},
+				[]interface{}{"bob", "1"},
+			}},
+			&XPendingSummary{Count: 3, Lower: "1-0", Higher: "5-0", Consumers: map[string]int64{"alice": 2, "bob": 1}},
+			false,
+		},
+		{"too short", []interface{}{int64(0), nil, nil}, nil, true},
+		{"count not a number", []interface{}{"many", nil, nil, nil}, nil, true},
+		{"bad consumer pair", []interface{}{int64(1), "1-0", "1-0", []interface{}{[]interface{}{"alice"}}}, nil, true},
+		{"consumer count not a number", []interface{}{int64(1), "1-0", "1-0", []interface{}{[]interface{}{"alice", "x"}}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseXPendingSummary(tt.reply)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseXPendingSummary() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseXPendingSummary() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseXPendingSummary() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseXPendingEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   interface{}
+		want    []XPendingEntry
+		wantErr bool
+	}{
+		{"empty", []interface{}{}, []XPendingEntry{}, false},
+		{
+			"entries",
+			[]interface{}{
+				[]interface{}{"1-0", "alice", int64(1500), int64(1)},
+				[]interface{}{"2-0", "bob", int64(0), int64(4)},
+			},
+			[]XPendingEntry{
+				{ID: "1-0", Consumer: "alice", Idle: 1500 * time.Millisecond, RetryCount: 1},
+				{ID: "2-0", Consumer: "bob", RetryCount: 4},
+			},
+			false,
+		},
+		{"not an array", nil, nil, true},
+		{"entry too short", []interface{}{[]interface{}{"1-0", "alice", int64(1)}}, nil, true},
+		{"idle not a number", []interface{}{[]interface{}{"1-0", "alice", "long", int64(1)}}, nil, true},
+		{"deliveries not a number", []interface{}{[]interface{}{"1-0", "alice", int64(1), nil}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseXPendingEntries(tt.reply)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseXPendingEntries() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseXPendingEntries() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseXPendingEntries() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package redis_gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// TLSConfig enables TLS for every connection. File paths are PEM.
+type TLSConfig struct {
+	// CACert verifies the server certificate instead of the system roots.
+	CACert string
+	// Cert and Key are a client certificate for servers that require one
+	// (tls-auth-clients yes).
+	Cert string
+	Key  string
+	// ServerName overrides the host name used for SNI and verification.
+	// Defaults to the host part of Config.Addr.
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// buildTLSConfig loads the certificates named in config for a connection to
+// addr.
+func buildTLSConfig(config *TLSConfig, addr string) (*tls.Config, error) {
+	serverName := config.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("redis_gateway: invalid address %q: %w", addr, err)
+		}
+		serverName = host
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if config.CACert != "" {
+		pem, err := os.ReadFile(config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("redis_gateway: reading CA certificate: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis_gateway: no certificates found in %s", config.CACert)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if config.Cert != "" || config.Key != "" {
+		cert, err := tls.LoadX509KeyPair(config.Cert, config.Key)
+		if err != nil {
+			return nil, fmt.Errorf("redis_gateway: loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
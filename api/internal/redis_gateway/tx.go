@@ -0,0 +1,296 @@
+package redis_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrTxFailed is returned when EXEC answers with a null reply because one of
+// the watched keys was modified after WATCH. The transaction did not run and
+// can be retried.
+var ErrTxFailed = errors.New("redis: transaction failed, watched key changed")
+
+// DefaultTxMaxRetries bounds WatchRetry and CheckAndSet loops.
+const DefaultTxMaxRetries = 10
+
+// Tx holds a single connection for the length of a WATCH/MULTI/EXEC
+// sequence. Commands run through Do execute immediately, so reads made after
+// WATCH see the current values; writes are queued with TxPipelined and sent
+// inside MULTI/EXEC. A Tx is only valid inside the function passed to Watch.
+//
+// There is no Discard: MULTI is only ever sent together with its commands
+// and EXEC in one write, so a transaction is never left open on the
+// connection for DISCARD to abort. To abandon a transaction, return an error
+// from the TxPipelined function; nothing is sent, and the watches are
+// cleared with UNWATCH when Watch returns.
+type Tx struct {
+	client   *RedisClient
+	cn       *redisConn
+	ctx      context.Context
+	watching bool
+}
+
+// Watch checks out a connection, WATCHes keys and calls fn with a Tx bound
+// to it. If a watched key changes before the transaction's EXEC, fn's
+// TxPipelined call returns ErrTxFailed. Watches are cleared before the
+// connection goes back to the pool.
+func (r *RedisClient) Watch(ctx context.Context, fn func(tx *Tx) error, keys ...string) error {
+	return r.withConn(ctx, false, func(cn *redisConn) error {
+		tx := &Tx{client: r, cn: cn, ctx: ctx}
+		defer tx.close()
+
+		if len(keys) > 0 {
+			if err := tx.Watch(ctx, keys...); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+// WatchRetry runs Watch until it succeeds, fails with anything other than
+// ErrTxFailed, or maxRetries attempts have been made.
+func (r *RedisClient) WatchRetry(ctx context.Context, maxRetries int, fn func(tx *Tx) error, keys ...string) error {
+	if maxRetries <= 0 {
+		maxRetries = DefaultTxMaxRetries
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		err = r.Watch(ctx, fn, keys...)
+		if !errors.Is(err, ErrTxFailed) {
+			return err
+		}
+		log.Printf("[REDIS] Transaction on %v aborted by a concurrent write (attempt %d/%d), retrying...", keys, attempt, maxRetries)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+	}
+	log.Printf("[REDIS] ERROR: Transaction on %v still conflicting after %d attempts", keys, maxRetries)
+	return err
+}
+
+// CheckAndSet atomically replaces the value of key with the result of fn.
+// fn receives the current value and whether the key exists; returning an
+// error leaves the key untouched. The read-modify-write is retried when
+// another client changes the key in between.
+func (r *RedisClient) CheckAndSet(ctx context.Context, key string, fn func(current string, exists bool) (string, error)) error {
+	return r.WatchRetry(ctx, DefaultTxMaxRetries, func(tx *Tx) error {
+		current, err := toString(tx.Do(ctx, "GET", key))
+		exists := err == nil
+		if err != nil && err != ErrNil {
+			return err
+		}
+
+		next, err := fn(current, exists)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe *Pipeline) error {
+			pipe.Set(key, next)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// TxPipeline returns a pipeline whose commands are wrapped in MULTI/EXEC and
+// run atomically on one connection, without any WATCH.
+func (r *RedisClient) TxPipeline() *Pipeline {
+	return &Pipeline{
+		client: r,
+		exec:   r.processTxPipeline,
+	}
+}
+
+func (r *RedisClient) processTxPipeline(ctx context.Context, cmds []*Cmd) error {
+	operationStart := time.Now()
+
+	err := r.withConn(ctx, false, func(cn *redisConn) error {
+		return cn.execMulti(cmds)
+	})
+	if err != nil {
+		setCmdsError(cmds, err)
+		log.Printf("[REDIS] ERROR: Transaction of %d commands failed: %v", len(cmds), err)
+		return err
+	}
+
+	totalLatency := time.Since(operationStart)
+	log.Printf("[REDIS] Transaction of %d commands committed in %v", len(cmds), totalLatency)
+	if r.metricsRegistry != nil {
+		r.metricsRegistry.SetGauge("redis_operation_latency_seconds", totalLatency.Seconds(), map[string]string{"operation": "exec"})
+	}
+	return nil
+}
+
+// Do runs a command on the transaction's connection right away.
+func (tx *Tx) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	tx.cn.setDeadlines(ctx, tx.client.config.ReadTimeout, tx.client.config.WriteTimeout)
+	return tx.cn.do(args...)
+}
+
+func (tx *Tx) Get(ctx context.Context, key string) (string, error) {
+	return toString(tx.Do(ctx, "GET", key))
+}
+
+func (tx *Tx) Watch(ctx context.Context, keys ...string) error {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "WATCH")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	if _, err := tx.Do(ctx, args...); err != nil {
+		return err
+	}
+	tx.watching = true
+	return nil
+}
+
+func (tx *Tx) Unwatch(ctx context.Context) error {
+	if _, err := tx.Do(ctx, "UNWATCH"); err != nil {
+		return err
+	}
+	tx.watching = false
+	return nil
+}
+
+// TxPipelined queues the commands added by fn and sends them as one
+// MULTI ... EXEC block. If fn returns an error nothing is sent and the queued
+// commands are discarded. A null EXEC reply yields ErrTxFailed on every
+// command.
+func (tx *Tx) TxPipelined(ctx context.Context, fn func(pipe *Pipeline) error) ([]*Cmd, error) {
+	pipe := &Pipeline{
+		client: tx.client,
+		exec: func(ctx context.Context, cmds []*Cmd) error {
+			if err := checkTxCmds(cmds); err != nil {
+				return err
+			}
+			tx.cn.setDeadlines(ctx, tx.client.config.ReadTimeout, tx.client.config.WriteTimeout)
+			// EXEC clears watches whatever its outcome.
+			tx.watching = false
+			return tx.cn.execMulti(cmds)
+		},
+	}
+	if err := fn(pipe); err != nil {
+		pipe.Discard()
+		return nil, err
+	}
+	return pipe.Exec(ctx)
+}
+
+func (tx *Tx) close() {
+	if !tx.watching || tx.cn.broken {
+		return
+	}
+	if err := tx.Unwatch(tx.ctx); err != nil {
+		log.Printf("[REDIS] WARNING: UNWATCH on connection #%d failed: %v", tx.cn.id, err)
+		tx.cn.broken = true
+	}
+}
+
+// execMulti sends MULTI, the queued commands and EXEC in a single write and
+// maps the EXEC array back onto cmds. Arguments are checked before anything
+// is written so a bad command cannot leave a half-queued transaction on the
+// connection.
+func (cn *redisConn) execMulti(cmds []*Cmd) error {
+	if err := checkTxCmds(cmds); err != nil {
+		return err
+	}
+
+	if err := cn.bufferCommand([]interface{}{"MULTI"}); err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if err := cn.bufferCommand(cmd.args); err != nil {
+			return err
+		}
+	}
+	if err := cn.bufferCommand([]interface{}{"EXEC"}); err != nil {
+		return err
+	}
+	if err := cn.flush(); err != nil {
+		setCmdsError(cmds, err)
+		return err
+	}
+
+	// MULTI, one QUEUED per command, then EXEC. Every reply has to be read
+	// even after an error so the connection stays in sync.
+	reply, err := cn.readReply()
+	if err != nil {
+		setCmdsError(cmds, err)
+		return err
+	}
+	multiErr, _ := reply.(*RedisError)
+
+	for _, cmd := range cmds {
+		reply, err := cn.readReply()
+		if err != nil {
+			setCmdsError(cmds, err)
+			return err
+		}
+		if redisErr, ok := reply.(*RedisError); ok {
+			cmd.err = redisErr
+		}
+	}
+
+	reply, err = cn.readReply()
+	if err != nil {
+		setCmdsError(cmds, err)
+		return err
+	}
+	if multiErr != nil {
+		setCmdsError(cmds, multiErr)
+		return multiErr
+	}
+
+	switch v := reply.(type) {
+	case nil:
+		setCmdsError(cmds, ErrTxFailed)
+		return ErrTxFailed
+	case *RedisError:
+		// EXECABORT: a command was rejected while queuing.
+		setCmdsError(cmds, v)
+		return v
+	case []interface{}:
+		if len(v) != len(cmds) {
+			cn.broken = true
+			err := &ProtocolError{Message: fmt.Sprintf("EXEC returned %d replies for %d commands", len(v), len(cmds))}
+			setCmdsError(cmds, err)
+			return err
+		}
+		for i, item := range v {
+			if redisErr, ok := item.(*RedisError); ok {
+				cmds[i].err = redisErr
+				continue
+			}
+			cmds[i].val = item
+		}
+		return nil
+	}
+
+	cn.broken = true
+	err = &ProtocolError{Message: fmt.Sprintf("unexpected EXEC reply %T", reply)}
+	setCmdsError(cmds, err)
+	return err
+}
+
+func checkTxCmds(cmds []*Cmd) error {
+	for i, cmd := range cmds {
+		if len(cmd.args) == 0 {
+			err := fmt.Errorf("redis: empty command at position %d in transaction", i)
+			setCmdsError(cmds, err)
+			return err
+		}
+		for _, arg := range cmd.args {
+			if _, err := argString(arg); err != nil {
+				setCmdsError(cmds, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
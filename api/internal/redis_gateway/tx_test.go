@@ -0,0 +1,149 @@
+package redis_gateway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// replyConn returns a redisConn that reads the canned reply stream and
+// records what is written to it.
+func replyConn(replies string) (*redisConn, *bytes.Buffer) {
+	var written bytes.Buffer
+	return &redisConn{
+		id:     1,
+		reader: bufio.NewReader(strings.NewReader(replies)),
+		writer: bufio.NewWriter(&written),
+	}, &written
+}
+
+func TestExecMulti(t *testing.T) {
+	execAbort := &RedisError{Code: "EXECABORT", Message: "EXECABORT Transaction discarded because of previous errors."}
+	unknown := &RedisError{Code: "ERR", Message: "ERR unknown command 'NOPE'"}
+	wrongType := &RedisError{Code: "WRONGTYPE", Message: "WRONGTYPE Operation against a key holding the wrong kind of value"}
+	nested := &RedisError{Code: "ERR", Message: "ERR MULTI calls can not be nested"}
+
+	tests := []struct {
+		name     string
+		replies  string
+		wantErr  error
+		wantVals []interface{}
+		wantErrs []error
+	}{
+		{
+			name:     "committed",
+			replies:  "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n:5\r\n",
+			wantVals: []interface{}{"OK", int64(5)},
+			wantErrs: []error{nil, nil},
+		},
+		{
+			name:     "error reply inside EXEC",
+			replies:  "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n-" + wrongType.Message + "\r\n:1\r\n",
+			wantVals: []interface{}{nil, int64(1)},
+			wantErrs: []error{wrongType, nil},
+		},
+		{
+			name:     "watched key changed",
+			replies:  "+OK\r\n+QUEUED\r\n+QUEUED\r\n*-1\r\n",
+			wantErr:  ErrTxFailed,
+			wantVals: []interface{}{nil, nil},
+			wantErrs: []error{ErrTxFailed, ErrTxFailed},
+		},
+		{
+			name:     "rejected while queuing",
+			replies:  "+OK\r\n+QUEUED\r\n-" + unknown.Message + "\r\n-" + execAbort.Message + "\r\n",
+			wantErr:  execAbort,
+			wantVals: []interface{}{nil, nil},
+			wantErrs: []error{execAbort, unknown},
+		},
+		{
+			name:     "MULTI rejected",
+			replies:  "-" + nested.Message + "\r\n-" + unknown.Message + "\r\n-" + unknown.Message + "\r\n+OK\r\n",
+			wantErr:  nested,
+			wantVals: []interface{}{nil, nil},
+			wantErrs: []error{unknown, unknown},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cn, written := replyConn(tt.replies)
+			cmds := []*Cmd{{args: []interface{}{"SET", "a", "1"}}, {args: []interface{}{"INCR", "b"}}}
+			err := cn.execMulti(cmds)
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Fatalf("execMulti error = %v, want %v", err, tt.wantErr)
+			}
+			for i, cmd := range cmds {
+				if !reflect.DeepEqual(cmd.val, tt.wantVals[i]) || !reflect.DeepEqual(cmd.err, tt.wantErrs[i]) {
+					t.Errorf("command %d = (%#v, %v), want (%#v, %v)", i, cmd.val, cmd.err, tt.wantVals[i], tt.wantErrs[i])
+				}
+			}
+			if cn.broken {
+				t.Error("connection marked broken")
+			}
+			if cn.reader.Buffered() != 0 {
+				t.Errorf("%d reply bytes left unread", cn.reader.Buffered())
+			}
+			want := "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$4\r\nINCR\r\n$1\r\nb\r\n*1\r\n$4\r\nEXEC\r\n"
+			if written.String() != want {
+				t.Errorf("wrote %q, want %q", written.String(), want)
+			}
+		})
+	}
+}
+
+func TestExecMultiBrokenReplies(t *testing.T) {
+	tests := []struct {
+		name       string
+		replies    string
+		wantBroken bool
+	}{
+		{"EXEC reply count mismatch", "+OK\r\n+QUEUED\r\n+QUEUED\r\n*1\r\n+OK\r\n", true},
+		{"EXEC reply of the wrong type", "+OK\r\n+QUEUED\r\n+QUEUED\r\n:2\r\n", true},
+		{"stream ends before EXEC", "+OK\r\n+QUEUED\r\n+QUEUED\r\n", false},
+		{"stream ends after MULTI", "+OK\r\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cn, _ := replyConn(tt.replies)
+			cmds := []*Cmd{{args: []interface{}{"SET", "a", "1"}}, {args: []interface{}{"INCR", "b"}}}
+			err := cn.execMulti(cmds)
+			if err == nil {
+				t.Fatal("execMulti succeeded, want error")
+			}
+			if tt.wantBroken {
+				var protoErr *ProtocolError
+				if !errors.As(err, &protoErr) || !cn.broken {
+					t.Fatalf("execMulti error = %v, broken = %v; want a protocol error on a broken conn", err, cn.broken)
+				}
+			} else if !errors.Is(err, io.EOF) {
+				t.Fatalf("execMulti error = %v, want io.EOF", err)
+			}
+			for i, cmd := range cmds {
+				if cmd.err == nil {
+					t.Errorf("command %d has no error", i)
+				}
+			}
+		})
+	}
+}
+
+func TestExecMultiRejectsBadArgsBeforeWriting(t *testing.T) {
+	cn, written := replyConn("")
+	cmds := []*Cmd{{args: []interface{}{"SET", "a", "1"}}, {args: []interface{}{"SET", "b", nil}}}
+	if err := cn.execMulti(cmds); err == nil {
+		t.Fatal("execMulti accepted a nil argument")
+	}
+	cn.writer.Flush()
+	if written.Len() != 0 {
+		t.Fatalf("wrote %q for a rejected transaction", written.String())
+	}
+	for i, cmd := range cmds {
+		if cmd.err == nil {
+			t.Errorf("command %d has no error", i)
+		}
+	}
+}
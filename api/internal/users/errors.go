@@ -0,0 +1,51 @@
+package users
+
+import (
+	"api/internal/pg_gateway"
+	"errors"
+	"net/http"
+)
+
+// Error carries the HTTP status a failed user operation should be reported
+// with. Err is the underlying cause, usually a *pg_gateway.PGError.
+type Error struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// classifyPGError maps constraint and data errors reported by PostgreSQL to
+// client errors. Anything else is treated as a server-side failure.
+func classifyPGError(err error, fallback string) *Error {
+	var pgErr *pg_gateway.PGError
+	if !errors.As(err, &pgErr) {
+		return &Error{Status: http.StatusInternalServerError, Message: fallback, Err: err}
+	}
+
+	switch pgErr.Code {
+	case pg_gateway.SQLStateUniqueViolation:
+		return &Error{Status: http.StatusConflict, Message: "user already exists", Err: err}
+	case pg_gateway.SQLStateStringDataRightTruncation,
+		pg_gateway.SQLStateNotNullViolation,
+		pg_gateway.SQLStateCheckViolation,
+		pg_gateway.SQLStateInvalidTextRepresentation,
+		pg_gateway.SQLStateNumericValueOutOfRange:
+		msg := pgErr.Message
+		if pgErr.ColumnName != "" {
+			msg = pgErr.ColumnName + ": " + msg
+		}
+		return &Error{Status: http.StatusBadRequest, Message: msg, Err: err}
+	}
+	return &Error{Status: http.StatusInternalServerError, Message: fallback, Err: err}
+}
@@ -0,0 +1,75 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"api/internal/redis_gateway"
+)
+
+const (
+	// UserEventsChannel is the Redis pub/sub channel user changes made
+	// through the API are broadcast on, so other replicas can react to them.
+	UserEventsChannel = "users:events"
+	// UserEventsStream carries the same events durably for downstream
+	// services, which read it with a consumer group
+	// (redis_gateway.StreamWorker). Each entry has the fields type, user_id,
+	// at and user (the User as JSON).
+	UserEventsStream = "users:stream"
+	// userEventsStreamMaxLen bounds the stream; trimming is approximate.
+	userEventsStreamMaxLen = 100000
+)
+
+const EventUserCreated = "user.created"
+
+// UserEvent is the JSON message published on UserEventsChannel.
+type UserEvent struct {
+	Type string    `json:"type"`
+	User User      `json:"user"`
+	At   time.Time `json:"at"`
+}
+
+// emitEvent appends an event to UserEventsStream and broadcasts it on
+// UserEventsChannel. The user is already stored at this point, so failures
+// are logged and counted but do not fail the operation.
+func (um *UsersManager) emitEvent(ctx context.Context, requestID, eventType string, user User) {
+	at := time.Now().UTC()
+	userJSON, err := json.Marshal(user)
+	if err != nil {
+		log.Printf("[USERS:%s] WARNING: Could not encode %s event: %v", requestID, eventType, err)
+		return
+	}
+
+	id, err := um.redisClient.XAdd(ctx, redis_gateway.XAddArgs{
+		Stream: UserEventsStream,
+		MaxLen: userEventsStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type":    eventType,
+			"user_id": user.UserID,
+			"at":      at.Format(time.RFC3339Nano),
+			"user":    string(userJSON),
+		},
+	})
+	if err != nil {
+		log.Printf("[USERS:%s] WARNING: Could not append %s event to '%s': %v", requestID, eventType, UserEventsStream, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "stream", "status": "error", "source": "redis",
+		})
+	} else {
+		log.Printf("[USERS:%s] Appended %s event to '%s' as %s", requestID, eventType, UserEventsStream, id)
+	}
+
+	payload, _ := json.Marshal(UserEvent{Type: eventType, User: user, At: at})
+	receivers, err := um.redisClient.Publish(ctx, UserEventsChannel, string(payload))
+	if err != nil {
+		log.Printf("[USERS:%s] WARNING: Could not publish %s event: %v", requestID, eventType, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "publish", "status": "error", "source": "redis",
+		})
+		return
+	}
+	log.Printf("[USERS:%s] Published %s event to '%s' (%d subscribers)", requestID, eventType, UserEventsChannel, receivers)
+}
@@ -0,0 +1,258 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"api/internal/pg_gateway"
+	"api/internal/redis_gateway"
+)
+
+// evictBatchSize is how many keys one DEL removes when the whole cache is
+// evicted after a TRUNCATE.
+const evictBatchSize = 500
+
+// userChange is the payload of a pg_gateway.UsersChangesChannel notification.
+type userChange struct {
+	Op            string `json:"op"`
+	UserID        string `json:"user_id"`
+	OldUserID     string `json:"old_user_id"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Age           int    `json:"age"`
+	MaritalStatus bool   `json:"marital_status"`
+}
+
+// SyncCache keeps the Redis copy of users in step with PostgreSQL, including
+// changes made outside the API. It subscribes listener to the users change
+// channel and applies every notification until the listener is closed.
+func (um *UsersManager) SyncCache(ctx context.Context, listener *pg_gateway.Listener) error {
+	if err := listener.Listen(ctx, pg_gateway.UsersChangesChannel); err != nil {
+		return err
+	}
+	log.Printf("[USERS] Cache sync subscribed to '%s'", pg_gateway.UsersChangesChannel)
+
+	go func() {
+		for n := range listener.Notifications() {
+			if n == nil {
+				// Changes made while the listener was reconnecting are lost.
+				log.Printf("[USERS] WARNING: Cache sync reconnected, changes made meanwhile were missed")
+				um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+					"operation": "cache_sync", "status": "reconnected",
+				})
+				continue
+			}
+			if n.Channel != pg_gateway.UsersChangesChannel {
+				continue
+			}
+			um.applyChange(ctx, n.Payload)
+		}
+		log.Printf("[USERS] Cache sync stopped")
+	}()
+	return nil
+}
+
+func (um *UsersManager) applyChange(ctx context.Context, payload string) {
+	start := time.Now()
+	var change userChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		log.Printf("[USERS] WARNING: Ignoring malformed change notification %q: %v", payload, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "cache_sync", "status": "error",
+		})
+		return
+	}
+
+	var err error
+	switch change.Op {
+	case "INSERT", "UPDATE":
+		err = um.cacheChangedUser(ctx, change)
+	case "DELETE":
+		err = um.evictUser(ctx, change.UserID)
+	case "TRUNCATE":
+		err = um.evictAllUsers(ctx)
+	default:
+		err = fmt.Errorf("unknown operation %q", change.Op)
+	}
+	if err != nil {
+		log.Printf("[USERS] ERROR: Cache sync failed to apply %s of user '%s': %v", change.Op, change.UserID, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "cache_sync", "status": "error", "change": change.Op,
+		})
+		return
+	}
+
+	log.Printf("[USERS] Cache sync applied %s of user '%s' in %v", change.Op, change.UserID, time.Since(start))
+	um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+		"operation": "cache_sync", "status": "success", "change": change.Op,
+	})
+}
+
+// cacheChangedUser replaces the user:<id> hash with the new row. An UPDATE
+// that changed user_id also drops the old key.
+func (um *UsersManager) cacheChangedUser(ctx context.Context, change userChange) error {
+	user := User{
+		UserID:        change.UserID,
+		FirstName:     change.FirstName,
+		LastName:      change.LastName,
+		Age:           change.Age,
+		MaritalStatus: change.MaritalStatus,
+	}
+	pipe := um.redisClient.TxPipeline()
+	if change.OldUserID != "" && change.OldUserID != change.UserID {
+		pipe.Do("DEL", "user:"+change.OldUserID)
+		pipe.Do("SREM", usersIndexKey, change.OldUserID)
+	}
+	// DEL first so no stale field, or legacy JSON string, is left behind.
+	pipe.Do("DEL", "user:"+user.UserID)
+	pipe.HSet("user:"+user.UserID, userHash(user))
+	pipe.Do("SADD", usersIndexKey, user.UserID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (um *UsersManager) evictUser(ctx context.Context, userID string) error {
+	pipe := um.redisClient.TxPipeline()
+	pipe.Do("DEL", "user:"+userID)
+	pipe.Do("SREM", usersIndexKey, userID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// evictAllUsers removes every user:* key and the index, in batches so Redis
+// is never blocked by one huge DEL.
+func (um *UsersManager) evictAllUsers(ctx context.Context) error {
+	log.Printf("[USERS] Evicting all cached users...")
+	it := um.redisClient.ScanIter(ctx, redis_gateway.ScanOptions{Match: "user:*", Count: evictBatchSize})
+	args := []interface{}{"DEL"}
+	evicted := 0
+	for it.Next() {
+		args = append(args, it.Key())
+		if len(args) > evictBatchSize {
+			if _, err := um.redisClient.Do(ctx, args...); err != nil {
+				return err
+			}
+			evicted += len(args) - 1
+			args = args[:1]
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	args = append(args, usersIndexKey)
+	if _, err := um.redisClient.Do(ctx, args...); err != nil {
+		return err
+	}
+	evicted += len(args) - 2
+	log.Printf("[USERS] Evicted %d cached users", evicted)
+	return nil
+}
+
+// cacheMigratedKey is set once MigrateCache has gone through the keyspace,
+// so later startups skip the scan.
+const cacheMigratedKey = "users:cache:hashes_migrated"
+
+// legacyUser is the JSON older versions stored under user:<id>. Every field
+// is required, so that unrelated JSON such as {} is not mistaken for it.
+type legacyUser struct {
+	FirstName     *string `json:"first_name"`
+	LastName      *string `json:"last_name"`
+	Age           *int    `json:"age"`
+	MaritalStatus *bool   `json:"marital_status"`
+}
+
+// parseLegacyUser returns false for anything but a JSON object with exactly
+// the fields of legacyUser.
+func parseLegacyUser(userID, data string) (User, bool) {
+	var legacy legacyUser
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&legacy); err != nil || decoder.More() {
+		return User{}, false
+	}
+	if legacy.FirstName == nil || legacy.LastName == nil || legacy.Age == nil || legacy.MaritalStatus == nil {
+		return User{}, false
+	}
+	return User{
+		UserID:        userID,
+		FirstName:     *legacy.FirstName,
+		LastName:      *legacy.LastName,
+		Age:           *legacy.Age,
+		MaritalStatus: *legacy.MaritalStatus,
+	}, true
+}
+
+// MigrateCache converts the user:<id> entries that older versions stored as
+// JSON strings into hashes, and returns how many were converted. String keys
+// that do not hold a legacy user, such as ones written through /api/set, are
+// left alone. Each key is converted in its own WATCH transaction because the
+// API may already be serving requests. Once the scan completes a marker key
+// is set and later calls return immediately.
+func (um *UsersManager) MigrateCache(ctx context.Context) (int, error) {
+	done, err := um.redisClient.Exists(ctx, cacheMigratedKey)
+	if err != nil {
+		return 0, err
+	}
+	if done > 0 {
+		log.Printf("[USERS] Cached users already migrated to hashes, skipping")
+		return 0, nil
+	}
+
+	it := um.redisClient.ScanIter(ctx, redis_gateway.ScanOptions{Match: "user:*", Count: evictBatchSize, Type: "string"})
+	migrated, skipped := 0, 0
+	for it.Next() {
+		key := it.Key()
+		converted, err := um.migrateUserKey(ctx, key)
+		if err != nil {
+			return migrated, err
+		}
+		if converted {
+			migrated++
+		} else {
+			skipped++
+		}
+	}
+	if err := it.Err(); err != nil {
+		return migrated, err
+	}
+
+	if _, err := um.redisClient.SetWithOptions(ctx, cacheMigratedKey, time.Now().UTC().Format(time.RFC3339), redis_gateway.SetOptions{}); err != nil {
+		return migrated, err
+	}
+	log.Printf("[USERS] Migrated %d cached users from JSON strings to hashes (%d other string keys left alone)", migrated, skipped)
+	return migrated, nil
+}
+
+// migrateUserKey replaces key with a hash if it still holds a legacy user.
+func (um *UsersManager) migrateUserKey(ctx context.Context, key string) (bool, error) {
+	userID := strings.TrimPrefix(key, "user:")
+	converted := false
+	err := um.redisClient.WatchRetry(ctx, redis_gateway.DefaultTxMaxRetries, func(tx *redis_gateway.Tx) error {
+		converted = false
+		data, err := tx.Get(ctx, key)
+		if err == redis_gateway.ErrNil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		user, ok := parseLegacyUser(userID, data)
+		if !ok {
+			log.Printf("[USERS] Leaving string key '%s' alone, it does not hold a cached user", key)
+			return tx.Unwatch(ctx)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe *redis_gateway.Pipeline) error {
+			pipe.Do("DEL", key)
+			pipe.HSet(key, userHash(user))
+			pipe.Do("SADD", usersIndexKey, userID)
+			return nil
+		})
+		converted = err == nil
+		return err
+	}, key)
+	return converted, err
+}
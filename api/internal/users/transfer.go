@@ -0,0 +1,401 @@
+package users
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats accepted by ImportUsers and produced by ExportUsers.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+const (
+	copyUsersInQuery  = `COPY users (user_id, first_name, last_name, age, marital_status) FROM STDIN WITH (FORMAT csv)`
+	copyUsersOutQuery = `COPY (SELECT user_id, first_name, last_name, age, marital_status FROM users ORDER BY id) TO STDOUT WITH (FORMAT csv%s)`
+
+	// importCacheBatch is how many users go into one Redis pipeline when an
+	// import is mirrored into the cache.
+	importCacheBatch = 500
+)
+
+var userColumns = []string{"user_id", "first_name", "last_name", "age", "marital_status"}
+
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatNDJSON
+}
+
+// ImportResult reports how many users an import wrote to PostgreSQL and how
+// many of those made it into Redis.
+type ImportResult struct {
+	Imported int64 `json:"imported"`
+	Cached   int   `json:"cached"`
+}
+
+// ImportUsers loads users from body with a single COPY, so either every
+// record is inserted or none is. CSV input needs a header row naming the
+// columns first_name, last_name, age and marital_status; NDJSON input is one
+// user object per line. user_id is optional in both and generated when
+// missing. Records are parsed while the COPY runs; a malformed record aborts
+// it and is reported as a 400 Error.
+//
+// Once PostgreSQL has the rows they are written to Redis in pipelined
+// batches. As in CreateUser, a Redis failure only costs the cache.
+func (um *UsersManager) ImportUsers(ctx context.Context, format string, body io.Reader) (*ImportResult, error) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	operationStart := time.Now()
+
+	log.Printf("[USERS:%s] Importing users (format: %s)...", requestID, format)
+
+	var next func() (*User, error)
+	switch format {
+	case FormatCSV:
+		reader, err := newCSVUserReader(body)
+		if err != nil {
+			return nil, err
+		}
+		next = reader.next
+	case FormatNDJSON:
+		next = newNDJSONUserReader(body).next
+	default:
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("unsupported import format %q", format)}
+	}
+
+	// The parser writes CSV rows into the pipe while CopyFrom streams them
+	// to the server. Closing the pipe with an error makes CopyFrom send
+	// CopyFail, which rolls back everything copied so far.
+	pr, pw := io.Pipe()
+	idPrefix := time.Now().UnixNano()
+	var imported []User
+	parsed := make(chan struct{})
+	go func() {
+		defer close(parsed)
+		w := csv.NewWriter(pw)
+		for i := 0; ; i++ {
+			user, err := next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if user.UserID == "" {
+				user.UserID = fmt.Sprintf("%d-%d", idPrefix, i)
+			}
+			w.Write([]string{user.UserID, user.FirstName, user.LastName, strconv.Itoa(user.Age), strconv.FormatBool(user.MaritalStatus)})
+			if err := w.Error(); err != nil {
+				// The COPY is gone; CopyFrom has the reason.
+				return
+			}
+			imported = append(imported, *user)
+		}
+		w.Flush()
+		pw.CloseWithError(w.Error())
+	}()
+
+	log.Printf("[USERS:%s] Streaming users to PostgreSQL with COPY...", requestID)
+	copyStart := time.Now()
+	n, err := um.pgClient.CopyFrom(ctx, copyUsersInQuery, pr)
+	pr.Close()
+	<-parsed
+	if err != nil {
+		log.Printf("[USERS:%s] ERROR: COPY failed: %v", requestID, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "import", "status": "error", "source": "postgres",
+		})
+		var userErr *Error
+		if errors.As(err, &userErr) {
+			return nil, userErr
+		}
+		return nil, classifyPGError(err, "failed to import users")
+	}
+	log.Printf("[USERS:%s] PostgreSQL COPY inserted %d users in %v", requestID, n, time.Since(copyStart))
+
+	log.Printf("[USERS:%s] Mirroring %d users to Redis...", requestID, len(imported))
+	cacheStart := time.Now()
+	cached := um.cacheUsers(ctx, requestID, imported)
+	log.Printf("[USERS:%s] Cached %d/%d users in Redis in %v", requestID, cached, len(imported), time.Since(cacheStart))
+	if cached < len(imported) {
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "import", "status": "error", "source": "redis",
+		})
+	}
+
+	totalDuration := time.Since(operationStart)
+	um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+		"operation": "import", "status": "success",
+	})
+	um.metricsRegistry.SetGauge("users_imported_count", float64(n), map[string]string{})
+	um.metricsRegistry.SetGauge("user_operation_duration_seconds", totalDuration.Seconds(), map[string]string{
+		"operation": "import",
+	})
+
+	log.Printf("[USERS:%s] SUCCESS: Imported %d users in %v", requestID, n, totalDuration)
+	return &ImportResult{Imported: n, Cached: cached}, nil
+}
+
+// cacheUsers writes users to Redis in pipelined batches and returns how many
+// were stored. Failed batches are logged and skipped.
+func (um *UsersManager) cacheUsers(ctx context.Context, requestID string, users []User) int {
+	cached := 0
+	for start := 0; start < len(users); start += importCacheBatch {
+		end := start + importCacheBatch
+		if end > len(users) {
+			end = len(users)
+		}
+
+		pipe := um.redisClient.Pipeline()
+		ids := make([]interface{}, 0, end-start+2)
+		ids = append(ids, "SADD", usersIndexKey)
+		for _, user := range users[start:end] {
+			pipe.Do("DEL", "user:"+user.UserID)
+			pipe.HSet("user:"+user.UserID, userHash(user))
+			ids = append(ids, user.UserID)
+		}
+		pipe.Do(ids...)
+
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("[USERS:%s] WARNING: Failed to cache users %d-%d in Redis: %v", requestID, start, end-1, err)
+			continue
+		}
+		cached += end - start
+	}
+	return cached
+}
+
+// ExportUsers streams every user to w, ordered by insertion, in CSV (with a
+// header row) or NDJSON. It returns the number of users written. Rows are
+// read with COPY TO STDOUT and never buffered as a whole.
+func (um *UsersManager) ExportUsers(ctx context.Context, format string, w io.Writer) (int64, error) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	operationStart := time.Now()
+
+	log.Printf("[USERS:%s] Exporting users (format: %s)...", requestID, format)
+
+	var (
+		n   int64
+		err error
+	)
+	switch format {
+	case FormatCSV:
+		n, err = um.exportCSV(ctx, w)
+	case FormatNDJSON:
+		n, err = um.exportNDJSON(ctx, w)
+	default:
+		return 0, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("unsupported export format %q", format)}
+	}
+	if err != nil {
+		log.Printf("[USERS:%s] ERROR: Export failed after %d users: %v", requestID, n, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "export", "status": "error", "source": "postgres",
+		})
+		return n, err
+	}
+
+	totalDuration := time.Since(operationStart)
+	um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+		"operation": "export", "status": "success",
+	})
+	um.metricsRegistry.SetGauge("user_operation_duration_seconds", totalDuration.Seconds(), map[string]string{
+		"operation": "export",
+	})
+
+	log.Printf("[USERS:%s] SUCCESS: Exported %d users in %v", requestID, n, totalDuration)
+	return n, nil
+}
+
+func (um *UsersManager) exportCSV(ctx context.Context, w io.Writer) (int64, error) {
+	return um.pgClient.CopyTo(ctx, fmt.Sprintf(copyUsersOutQuery, ", HEADER true"), w)
+}
+
+// exportNDJSON re-encodes the CSV that COPY produces, so age and
+// marital_status come out as JSON numbers and booleans.
+func (um *UsersManager) exportNDJSON(ctx context.Context, w io.Writer) (int64, error) {
+	pr, pw := io.Pipe()
+	var copyErr error
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		_, copyErr = um.pgClient.CopyTo(ctx, fmt.Sprintf(copyUsersOutQuery, ""), pw)
+		pw.CloseWithError(copyErr)
+	}()
+
+	var n int64
+	reader := csv.NewReader(pr)
+	reader.FieldsPerRecord = len(userColumns)
+	encoder := json.NewEncoder(w)
+	err := func() error {
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			user, err := userFromRecord(record)
+			if err != nil {
+				return err
+			}
+			if err := encoder.Encode(user); err != nil {
+				return err
+			}
+			n++
+		}
+	}()
+	// Unblocks CopyTo if we stopped early; it drains the rest of the COPY.
+	pr.CloseWithError(err)
+	<-copied
+	if copyErr != nil {
+		return n, copyErr
+	}
+	return n, err
+}
+
+// userFromRecord converts a row in userColumns order.
+func userFromRecord(record []string) (*User, error) {
+	age, err := strconv.Atoi(record[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid age %q", record[3])
+	}
+	maritalStatus, err := strconv.ParseBool(record[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid marital_status %q", record[4])
+	}
+	return &User{
+		UserID:        record[0],
+		FirstName:     record[1],
+		LastName:      record[2],
+		Age:           age,
+		MaritalStatus: maritalStatus,
+	}, nil
+}
+
+type csvUserReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVUserReader(body io.Reader) (*csvUserReader, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "CSV body is empty, expected a header row"}
+	}
+	if err != nil {
+		return nil, importReadError(err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, column := range userColumns {
+			if name == column {
+				known = true
+			}
+		}
+		if !known {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown CSV column %q", name)}
+		}
+		columns[name] = i
+	}
+	for _, column := range userColumns[1:] {
+		if _, ok := columns[column]; !ok {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("CSV header is missing column %q", column)}
+		}
+	}
+	reader.FieldsPerRecord = len(header)
+	return &csvUserReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvUserReader) next() (*User, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, importReadError(err)
+	}
+
+	ordered := make([]string, len(userColumns))
+	for i, column := range userColumns {
+		if index, ok := r.columns[column]; ok {
+			ordered[i] = strings.TrimSpace(record[index])
+		}
+	}
+	user, err := userFromRecord(ordered)
+	if err != nil {
+		line, _ := r.reader.FieldPos(0)
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("line %d: %v", line, err)}
+	}
+	return user, nil
+}
+
+type ndjsonUserReader struct {
+	decoder *json.Decoder
+	line    int
+}
+
+func newNDJSONUserReader(body io.Reader) *ndjsonUserReader {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	return &ndjsonUserReader{decoder: decoder}
+}
+
+func (r *ndjsonUserReader) next() (*User, error) {
+	r.line++
+	var user User
+	if err := r.decoder.Decode(&user); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		if msg, ok := jsonErrorMessage(err); ok {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("record %d: %s", r.line, msg), Err: err}
+		}
+		return nil, importReadError(err)
+	}
+	return &user, nil
+}
+
+func jsonErrorMessage(err error) (string, bool) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return "invalid JSON: " + syntaxErr.Error(), true
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("field %s must be %s", typeErr.Field, typeErr.Type), true
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		return err.Error(), true
+	case err == io.ErrUnexpectedEOF:
+		return "truncated JSON object", true
+	}
+	return "", false
+}
+
+// importReadError reports a body that could not be read or parsed as a
+// client error.
+func importReadError(err error) *Error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &Error{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("import body exceeds %d bytes", maxBytesErr.Limit), Err: err}
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &Error{Status: http.StatusBadRequest, Message: parseErr.Error(), Err: err}
+	}
+	return &Error{Status: http.StatusBadRequest, Message: "failed to read import body", Err: err}
+}
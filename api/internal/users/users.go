@@ -2,14 +2,40 @@ package users
 
 import (
 	"api/internal/metrics"
+	"context"
+	"database/sql"
+	"errors"
 	"api/internal/pg_gateway"
 	"api/internal/redis_gateway"
 	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// usersIndexKey is a set holding the ID of every user stored under user:<id>.
+// Both are written in the same MULTI/EXEC so they cannot drift apart.
+const usersIndexKey = "users:index"
+
+// Fields of the user:<id> hash. The ID is part of the key, so it is not
+// stored as a field.
+const (
+	fieldFirstName     = "first_name"
+	fieldLastName      = "last_name"
+	fieldAge           = "age"
+	fieldMaritalStatus = "marital_status"
+)
+
+const (
+	insertUserQuery  = `INSERT INTO users (user_id, first_name, last_name, age, marital_status) VALUES ($1, $2, $3, $4, $5)`
+	selectUsersQuery = `SELECT user_id, first_name, last_name, age, marital_status FROM users`
+	selectUserQuery  = selectUsersQuery + ` WHERE user_id = $1`
+	updateUserQuery  = `UPDATE users SET %s WHERE user_id = $1 RETURNING user_id, first_name, last_name, age, marital_status`
+)
+
 type User struct {
 	UserID        string `json:"user_id"`
 	FirstName     string `json:"first_name"`
@@ -18,17 +44,50 @@ type User struct {
 	MaritalStatus bool   `json:"marital_status"`
 }
 
+// UserUpdate holds the fields of a partial update; nil fields are left
+// unchanged.
+type UserUpdate struct {
+	FirstName     *string `json:"first_name"`
+	LastName      *string `json:"last_name"`
+	Age           *int    `json:"age"`
+	MaritalStatus *bool   `json:"marital_status"`
+}
+
+// fields returns the columns the update sets, which are also the names of
+// the hash fields, with their values.
+func (u UserUpdate) fields() map[string]interface{} {
+	fields := make(map[string]interface{}, 4)
+	if u.FirstName != nil {
+		fields[fieldFirstName] = *u.FirstName
+	}
+	if u.LastName != nil {
+		fields[fieldLastName] = *u.LastName
+	}
+	if u.Age != nil {
+		fields[fieldAge] = *u.Age
+	}
+	if u.MaritalStatus != nil {
+		fields[fieldMaritalStatus] = *u.MaritalStatus
+	}
+	return fields
+}
+
+// UsersManager reads and writes users through db, a database/sql handle on
+// the pg_gateway driver. pgClient is kept for operations database/sql has no
+// API for.
 type UsersManager struct {
 	redisClient     *redis_gateway.RedisClient
 	pgClient        *pg_gateway.PGClient
+	db              *sql.DB
 	metricsRegistry *metrics.Registry
 }
 
-func NewUsersManager(redisClient *redis_gateway.RedisClient, pgClient *pg_gateway.PGClient, metricsRegistry *metrics.Registry) *UsersManager {
+func NewUsersManager(redisClient *redis_gateway.RedisClient, pgClient *pg_gateway.PGClient, db *sql.DB, metricsRegistry *metrics.Registry) *UsersManager {
 	log.Println("[USERS] Creating new UsersManager")
 	return &UsersManager{
 		redisClient:     redisClient,
 		pgClient:        pgClient,
+		db:              db,
 		metricsRegistry: metricsRegistry,
 	}
 }
@@ -47,34 +106,38 @@ func (um *UsersManager) CreateUser(firstName, lastName string, age int, maritalS
 	log.Printf("[USERS:%s] Generated user_id: %s", requestID, userID)
 	log.Printf("[USERS:%s] Redis key: %s", requestID, redisKey)
 	
-	// Create JSON value for Redis
-	userJSON := fmt.Sprintf(`{"first_name":"%s","last_name":"%s","age":%d,"marital_status":%t}`,
-		firstName, lastName, age, maritalStatus)
-	
-	log.Printf("[USERS:%s] Storing to Redis...", requestID)
-	setStart := time.Now()
-	if err := um.redisClient.Set(redisKey, userJSON); err != nil {
-		log.Printf("[USERS:%s] ERROR: Redis SET failed: %v", requestID, err)
-		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
-			"operation": "create", "status": "error", "source": "redis",
-		})
-		return "", err
-	}
-	setDuration := time.Since(setStart)
-	log.Printf("[USERS:%s] Redis SET completed in %v", requestID, setDuration)
+	user := User{UserID: userID, FirstName: firstName, LastName: lastName, Age: age, MaritalStatus: maritalStatus}
 	
+	// PostgreSQL is the source of truth, so it is written first: a rejected
+	// INSERT must not leave a cached copy behind in Redis.
 	log.Printf("[USERS:%s] Storing to PostgreSQL...", requestID)
 	insertStart := time.Now()
-	if err := um.pgClient.InsertUser(userID, firstName, lastName, age, maritalStatus); err != nil {
+	if _, err := um.db.ExecContext(context.Background(), insertUserQuery, userID, firstName, lastName, age, maritalStatus); err != nil {
 		log.Printf("[USERS:%s] ERROR: PostgreSQL INSERT failed: %v", requestID, err)
 		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
 			"operation": "create", "status": "error", "source": "postgres",
 		})
-		return "", fmt.Errorf("failed to insert into database")
+		return "", classifyPGError(err, "failed to insert into database")
 	}
 	insertDuration := time.Since(insertStart)
 	log.Printf("[USERS:%s] PostgreSQL INSERT completed in %v", requestID, insertDuration)
 
+	log.Printf("[USERS:%s] Storing to Redis...", requestID)
+	setStart := time.Now()
+	if err := um.storeUser(context.Background(), redisKey, user); err != nil {
+		// The user exists in PostgreSQL; reads fall back to it, so a cache
+		// write failure does not fail the request.
+		log.Printf("[USERS:%s] WARNING: Redis transaction failed, user only stored in PostgreSQL: %v", requestID, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "create", "status": "error", "source": "redis",
+		})
+	} else {
+		setDuration := time.Since(setStart)
+		log.Printf("[USERS:%s] Redis transaction completed in %v", requestID, setDuration)
+	}
+
+	um.emitEvent(context.Background(), requestID, EventUserCreated, user)
+
 	totalDuration := time.Since(operationStart)
 	um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
 		"operation": "create", "status": "success",
@@ -89,7 +152,58 @@ func (um *UsersManager) CreateUser(firstName, lastName string, age int, maritalS
 	return userID, nil
 }
 
-func (um *UsersManager) GetUsers() ([]map[string]interface{}, error) {
+// userHash is the user:<id> hash of user. marital_status is stored as
+// "true" or "false".
+func userHash(user User) map[string]interface{} {
+	return map[string]interface{}{
+		fieldFirstName:     user.FirstName,
+		fieldLastName:      user.LastName,
+		fieldAge:           user.Age,
+		fieldMaritalStatus: strconv.FormatBool(user.MaritalStatus),
+	}
+}
+
+// userFromHash converts the fields of a user:<id> hash back into a User.
+func userFromHash(userID string, fields map[string]string) (User, error) {
+	user := User{UserID: userID, FirstName: fields[fieldFirstName], LastName: fields[fieldLastName]}
+	age, err := strconv.Atoi(fields[fieldAge])
+	if err != nil {
+		return user, fmt.Errorf("user %s: invalid age %q", userID, fields[fieldAge])
+	}
+	maritalStatus, err := strconv.ParseBool(fields[fieldMaritalStatus])
+	if err != nil {
+		return user, fmt.Errorf("user %s: invalid marital_status %q", userID, fields[fieldMaritalStatus])
+	}
+	user.Age = age
+	user.MaritalStatus = maritalStatus
+	return user, nil
+}
+
+// storeUser writes the user hash and adds the ID to the index set
+// atomically. The user key is watched so a concurrent writer of the same ID
+// aborts the transaction instead of being silently overwritten.
+func (um *UsersManager) storeUser(ctx context.Context, redisKey string, user User) error {
+	return um.redisClient.WatchRetry(ctx, redis_gateway.DefaultTxMaxRetries, func(tx *redis_gateway.Tx) error {
+		exists, err := tx.Do(ctx, "EXISTS", redisKey)
+		if err != nil {
+			return err
+		}
+		if n, _ := exists.(int64); n > 0 {
+			return fmt.Errorf("user key %s already exists", redisKey)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe *redis_gateway.Pipeline) error {
+			pipe.HSet(redisKey, userHash(user))
+			pipe.Do("SADD", usersIndexKey, user.UserID)
+			return nil
+		})
+		return err
+	}, redisKey)
+}
+
+// GetUsers returns every user from the Redis hashes, or from PostgreSQL when
+// Redis has none. Hashes that cannot be parsed are skipped.
+func (um *UsersManager) GetUsers() ([]User, error) {
 	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
 	operationStart := time.Now()
 	
@@ -98,7 +212,7 @@ func (um *UsersManager) GetUsers() ([]map[string]interface{}, error) {
 	// First, try to get users from Redis
 	log.Printf("[USERS:%s] Attempting to get users from Redis...", requestID)
 	redisStart := time.Now()
-	users, err := um.redisClient.GetAllUsers()
+	users, err := um.cachedUsers(requestID)
 	redisDuration := time.Since(redisStart)
 	
 	if err != nil || len(users) == 0 {
@@ -118,7 +232,7 @@ func (um *UsersManager) GetUsers() ([]map[string]interface{}, error) {
 		
 		// Get users from PostgreSQL
 		pgStart := time.Now()
-		users, err = um.pgClient.GetAllUsers()
+		users, err = um.queryUsers(context.Background())
 		pgDuration := time.Since(pgStart)
 		
 		if err != nil {
@@ -153,3 +267,178 @@ func (um *UsersManager) GetUsers() ([]map[string]interface{}, error) {
 	
 	return users, nil
 }
+
+// cachedUsers reads every user hash through GetAllUsers.
+func (um *UsersManager) cachedUsers(requestID string) ([]User, error) {
+	hashes, err := um.redisClient.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(hashes))
+	for _, fields := range hashes {
+		user, err := userFromHash(fields["user_id"], fields)
+		if err != nil {
+			log.Printf("[USERS:%s] WARNING: Skipping malformed cached user: %v", requestID, err)
+			um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+				"operation": "get", "status": "error", "source": "redis",
+			})
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (um *UsersManager) queryUsers(ctx context.Context) ([]User, error) {
+	rows, err := um.db.QueryContext(ctx, selectUsersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.UserID, &user.FirstName, &user.LastName, &user.Age, &user.MaritalStatus); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetUser returns one user, read from its hash or, when it is not cached,
+// from PostgreSQL. A user that does not exist is a 404 *Error.
+func (um *UsersManager) GetUser(ctx context.Context, userID string) (*User, error) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	operationStart := time.Now()
+	redisKey := "user:" + userID
+
+	log.Printf("[USERS:%s] Getting user '%s'...", requestID, userID)
+
+	fields, err := um.redisClient.HGetAll(ctx, redisKey)
+	if err != nil {
+		log.Printf("[USERS:%s] WARNING: Failed to read user from Redis: %v", requestID, err)
+	} else if len(fields) > 0 {
+		user, err := userFromHash(userID, fields)
+		if err == nil {
+			log.Printf("[USERS:%s] SUCCESS: Retrieved user '%s' from Redis in %v", requestID, userID, time.Since(operationStart))
+			um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+				"operation": "get_one", "status": "success", "source": "redis",
+			})
+			return &user, nil
+		}
+		log.Printf("[USERS:%s] WARNING: Ignoring malformed cached user: %v", requestID, err)
+	}
+
+	var user User
+	err = um.db.QueryRowContext(ctx, selectUserQuery, userID).Scan(&user.UserID, &user.FirstName, &user.LastName, &user.Age, &user.MaritalStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("[USERS:%s] User '%s' not found", requestID, userID)
+		return nil, &Error{Status: http.StatusNotFound, Message: "user not found"}
+	}
+	if err != nil {
+		log.Printf("[USERS:%s] ERROR: Failed to get user from PostgreSQL: %v", requestID, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "get_one", "status": "error", "source": "postgres",
+		})
+		return nil, classifyPGError(err, "failed to query database")
+	}
+
+	log.Printf("[USERS:%s] SUCCESS: Retrieved user '%s' from PostgreSQL in %v", requestID, userID, time.Since(operationStart))
+	um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+		"operation": "get_one", "status": "success", "source": "postgres",
+	})
+	return &user, nil
+}
+
+// UpdateUser changes the fields set in update and returns the updated user.
+// PostgreSQL is updated first; the cached hash then gets only the changed
+// fields, so concurrent updates of different fields do not undo each other.
+func (um *UsersManager) UpdateUser(ctx context.Context, userID string, update UserUpdate) (*User, error) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	operationStart := time.Now()
+
+	fields := update.fields()
+	if len(fields) == 0 {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "no fields to update"}
+	}
+
+	// Columns are only ever the field constants, never user input.
+	columns := make([]string, 0, len(fields))
+	for _, column := range userColumns[1:] {
+		if _, ok := fields[column]; ok {
+			columns = append(columns, column)
+		}
+	}
+	assignments := make([]string, len(columns))
+	args := make([]interface{}, 0, len(columns)+1)
+	args = append(args, userID)
+	for i, column := range columns {
+		assignments[i] = fmt.Sprintf("%s = $%d", column, i+2)
+		args = append(args, fields[column])
+	}
+
+	log.Printf("[USERS:%s] Updating user '%s' fields %v...", requestID, userID, columns)
+
+	var user User
+	err := um.db.QueryRowContext(ctx, fmt.Sprintf(updateUserQuery, strings.Join(assignments, ", ")), args...).
+		Scan(&user.UserID, &user.FirstName, &user.LastName, &user.Age, &user.MaritalStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("[USERS:%s] User '%s' not found", requestID, userID)
+		return nil, &Error{Status: http.StatusNotFound, Message: "user not found"}
+	}
+	if err != nil {
+		log.Printf("[USERS:%s] ERROR: PostgreSQL UPDATE failed: %v", requestID, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "update", "status": "error", "source": "postgres",
+		})
+		return nil, classifyPGError(err, "failed to update database")
+	}
+
+	if err := um.updateCachedUser(ctx, user, fields); err != nil {
+		// The change notification rewrites the hash as well, and reads of
+		// a single user fall back to PostgreSQL.
+		log.Printf("[USERS:%s] WARNING: Failed to update cached user: %v", requestID, err)
+		um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+			"operation": "update", "status": "error", "source": "redis",
+		})
+	}
+
+	totalDuration := time.Since(operationStart)
+	um.metricsRegistry.IncrementCounter("user_operations_total", map[string]string{
+		"operation": "update", "status": "success",
+	})
+	um.metricsRegistry.SetGauge("user_operation_duration_seconds", totalDuration.Seconds(), map[string]string{
+		"operation": "update",
+	})
+	log.Printf("[USERS:%s] SUCCESS: User '%s' updated in %v", requestID, userID, totalDuration)
+	return &user, nil
+}
+
+// updateCachedUser sets the changed fields on an existing hash. A missing
+// key, or a legacy JSON string, is replaced by the full hash of user instead
+// so that a partial hash is never created.
+func (um *UsersManager) updateCachedUser(ctx context.Context, user User, fields map[string]interface{}) error {
+	redisKey := "user:" + user.UserID
+	if v, ok := fields[fieldMaritalStatus].(bool); ok {
+		fields[fieldMaritalStatus] = strconv.FormatBool(v)
+	}
+	return um.redisClient.WatchRetry(ctx, redis_gateway.DefaultTxMaxRetries, func(tx *redis_gateway.Tx) error {
+		keyType, err := tx.Do(ctx, "TYPE", redisKey)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe *redis_gateway.Pipeline) error {
+			if keyType == "hash" {
+				pipe.HSet(redisKey, fields)
+				return nil
+			}
+			pipe.Do("DEL", redisKey)
+			pipe.HSet(redisKey, userHash(user))
+			pipe.Do("SADD", usersIndexKey, user.UserID)
+			return nil
+		})
+		return err
+	}, redisKey)
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,9 +23,13 @@ import (
 	"api/internal/users"
 )
 
+// SetRequest is the body of /api/set. TTLSeconds expires the key; Mode "nx"
+// only creates it, "xx" only overwrites it.
 type SetRequest struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	TTLSeconds int64  `json:"ttl_seconds,omitempty"`
+	Mode       string `json:"mode,omitempty"`
 }
 
 type UserRequest struct {
@@ -31,6 +39,23 @@ type UserRequest struct {
 	MaritalStatus bool   `json:"marital_status"`
 }
 
+// KVBatchRequest is the body of POST /api/kv/batch: keys to MSET, then keys
+// to MGET.
+type KVBatchRequest struct {
+	Set map[string]string `json:"set"`
+	Get []string          `json:"get"`
+}
+
+// maxImportBodySize caps the body of /api/users/import.
+const maxImportBodySize = 64 << 20
+
+const (
+	kvListDefaultCount = 100
+	kvListMaxCount     = 1000
+	kvBatchMaxKeys     = 1000
+	maxKVBatchBodySize = 16 << 20
+)
+
 type Response struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
@@ -42,7 +67,7 @@ var (
 	loadedKeysMutex sync.RWMutex
 	loadedValues    []string
 	loadedValuesMutex sync.RWMutex
-	usersManager    *users.UsersManager
+	deps            *backends
 )
 
 func main() {
@@ -56,6 +81,36 @@ func main() {
 	redisHost := getEnv("REDIS_HOST", "localhost")
 	redisPort := getEnv("REDIS_PORT", "6379")
 	log.Printf("[INIT] Redis configuration: host=%s, port=%s", redisHost, redisPort)
+
+	redisConfig := redis_gateway.DefaultConfig(redisHost + ":" + redisPort)
+	redisConfig.DialTimeout = getEnvDuration("REDIS_DIAL_TIMEOUT", redisConfig.DialTimeout)
+	redisConfig.ReadTimeout = getEnvDuration("REDIS_READ_TIMEOUT", redisConfig.ReadTimeout)
+	redisConfig.WriteTimeout = getEnvDuration("REDIS_WRITE_TIMEOUT", redisConfig.WriteTimeout)
+	redisConfig.Protocol = getEnvInt("REDIS_PROTOCOL", redisConfig.Protocol)
+	redisConfig.MaxRetries = getEnvInt("REDIS_MAX_RETRIES", redisConfig.MaxRetries)
+	redisConfig.Username = os.Getenv("REDIS_USERNAME")
+	redisConfig.Password = os.Getenv("REDIS_PASSWORD")
+	redisConfig.DB = getEnvInt("REDIS_DB", redisConfig.DB)
+	redisConfig.ClientName = getEnv("REDIS_CLIENT_NAME", "api")
+	log.Printf("[INIT] Redis session configuration: user=%q, password set=%v, db=%d, client_name=%q",
+		redisConfig.Username, redisConfig.Password != "", redisConfig.DB, redisConfig.ClientName)
+	redisConfig.Pool.MaxConns = getEnvInt("REDIS_POOL_MAX_CONNS", redisConfig.Pool.MaxConns)
+	redisConfig.Pool.MinIdleConns = getEnvInt("REDIS_POOL_MIN_IDLE_CONNS", redisConfig.Pool.MinIdleConns)
+	redisConfig.Pool.PoolTimeout = getEnvDuration("REDIS_POOL_TIMEOUT", redisConfig.Pool.PoolTimeout)
+	redisConfig.Pool.IdleTimeout = getEnvDuration("REDIS_POOL_IDLE_TIMEOUT", redisConfig.Pool.IdleTimeout)
+	log.Printf("[INIT] Redis pool configuration: max=%d, min_idle=%d, pool_timeout=%v, idle_timeout=%v",
+		redisConfig.Pool.MaxConns, redisConfig.Pool.MinIdleConns, redisConfig.Pool.PoolTimeout, redisConfig.Pool.IdleTimeout)
+	if getEnvBool("REDIS_TLS", false) {
+		redisConfig.TLS = &redis_gateway.TLSConfig{
+			CACert:             os.Getenv("REDIS_TLS_CA_CERT"),
+			Cert:               os.Getenv("REDIS_TLS_CERT"),
+			Key:                os.Getenv("REDIS_TLS_KEY"),
+			ServerName:         os.Getenv("REDIS_TLS_SERVER_NAME"),
+			InsecureSkipVerify: getEnvBool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+		}
+		log.Printf("[INIT] Redis TLS enabled: ca=%q, cert=%q, server_name=%q",
+			redisConfig.TLS.CACert, redisConfig.TLS.Cert, redisConfig.TLS.ServerName)
+	}
 	
 	pgHost := getEnv("POSTGRES_HOST", "localhost")
 	pgPort := getEnv("POSTGRES_PORT", "5432")
@@ -63,32 +118,103 @@ func main() {
 	pgPass := getEnv("POSTGRES_PASSWORD", "apppass")
 	pgDB := getEnv("POSTGRES_DB", "appdb")
 	log.Printf("[INIT] PostgreSQL configuration: host=%s, port=%s, user=%s, db=%s", pgHost, pgPort, pgUser, pgDB)
+	pgSSLMode, err := pg_gateway.ParseSSLMode(getEnv("POSTGRES_SSLMODE", string(pg_gateway.SSLPrefer)))
+	if err != nil {
+		log.Fatalf("[INIT] FATAL: Invalid POSTGRES_SSLMODE: %v", err)
+	}
+	pgSSLRootCert := os.Getenv("POSTGRES_SSLROOTCERT")
+	pgSSLCert := os.Getenv("POSTGRES_SSLCERT")
+	pgSSLKey := os.Getenv("POSTGRES_SSLKEY")
+	log.Printf("[INIT] PostgreSQL SSL configuration: sslmode=%s, sslrootcert=%q, sslcert=%q", pgSSLMode, pgSSLRootCert, pgSSLCert)
+
+	pgPoolConfig := pg_gateway.DefaultPoolConfig()
+	pgPoolConfig.MinConns = getEnvInt("POSTGRES_POOL_MIN_CONNS", pgPoolConfig.MinConns)
+	pgPoolConfig.MaxConns = getEnvInt("POSTGRES_POOL_MAX_CONNS", pgPoolConfig.MaxConns)
+	pgPoolConfig.MaxWaiters = getEnvInt("POSTGRES_POOL_MAX_WAITERS", pgPoolConfig.MaxWaiters)
+	pgPoolConfig.IdleTimeout = getEnvDuration("POSTGRES_POOL_IDLE_TIMEOUT", pgPoolConfig.IdleTimeout)
+	pgPoolConfig.MaxLifetime = getEnvDuration("POSTGRES_POOL_MAX_LIFETIME", pgPoolConfig.MaxLifetime)
+	pgPoolConfig.WaitTimeout = getEnvDuration("POSTGRES_POOL_WAIT_TIMEOUT", pgPoolConfig.WaitTimeout)
+	log.Printf("[INIT] PostgreSQL pool configuration: min=%d, max=%d, max_waiters=%d, idle_timeout=%v, max_lifetime=%v, wait_timeout=%v",
+		pgPoolConfig.MinConns, pgPoolConfig.MaxConns, pgPoolConfig.MaxWaiters, pgPoolConfig.IdleTimeout, pgPoolConfig.MaxLifetime, pgPoolConfig.WaitTimeout)
 
 	log.Println("[INIT] Initializing metrics registry...")
 	metricsRegistry = metrics.NewRegistry()
 	log.Println("[INIT] Metrics registry initialized successfully")
 	
-	log.Printf("[REDIS] Attempting to connect to Redis at %s:%s...", redisHost, redisPort)
-	startTime := time.Now()
-	redisClient := redis_gateway.NewRedisClient(redisHost + ":" + redisPort)
-	defer redisClient.Close()
-	log.Printf("[REDIS] Connected successfully in %v", time.Since(startTime))
-	redisClient.SetMetricsRegistry(metricsRegistry)
-	log.Println("[REDIS] Metrics registry attached to Redis client")
-
-	log.Printf("[POSTGRES] Attempting to connect to PostgreSQL at %s:%s...", pgHost, pgPort)
-	startTime = time.Now()
-	pgClient := pg_gateway.NewPGClient(pgHost, pgPort, pgUser, pgPass, pgDB)
-	defer pgClient.Close()
-	log.Printf("[POSTGRES] Connected successfully in %v", time.Since(startTime))
-	pgClient.SetMetricsRegistry(metricsRegistry)
-	log.Println("[POSTGRES] Metrics registry attached to PostgreSQL client")
-
-	log.Println("[POSTGRES] Creating database table if not exists...")
-	if err := pgClient.CreateTable(); err != nil {
-		log.Printf("[POSTGRES] WARNING: Could not create table: %v", err)
-	} else {
-		log.Println("[POSTGRES] Table created/verified successfully")
+	pgConfig := pg_gateway.Config{
+		Host:           pgHost,
+		Port:           pgPort,
+		User:           pgUser,
+		Password:       pgPass,
+		Database:       pgDB,
+		MaxMessageSize: getEnvInt("POSTGRES_MAX_MESSAGE_SIZE", pg_gateway.DefaultMaxMessageSize),
+		SSLMode:        pgSSLMode,
+		SSLRootCert:    pgSSLRootCert,
+		SSLCert:        pgSSLCert,
+		SSLKey:         pgSSLKey,
+		MaxRetries:     getEnvInt("POSTGRES_MAX_RETRIES", pg_gateway.DefaultMaxRetries),
+		Pool:           pgPoolConfig,
+	}
+
+	// A backend that is down at startup does not stop the server: the
+	// endpoints that need it answer 503 until connectInBackground reaches it.
+	deps = &backends{}
+	defer deps.Close()
+
+	connectRedis := func() error {
+		log.Printf("[REDIS] Attempting to connect to Redis at %s:%s...", redisHost, redisPort)
+		startTime := time.Now()
+		client, err := redis_gateway.NewRedisClientFromConfig(redisConfig)
+		if err != nil {
+			return err
+		}
+		log.Printf("[REDIS] Connected successfully in %v", time.Since(startTime))
+		client.SetMetricsRegistry(metricsRegistry)
+		log.Println("[REDIS] Metrics registry attached to Redis client")
+		deps.setRedis(client)
+		deps.startUsers()
+		return nil
+	}
+
+	connectPG := func() error {
+		log.Printf("[POSTGRES] Attempting to connect to PostgreSQL at %s:%s...", pgHost, pgPort)
+		startTime := time.Now()
+		client, err := pg_gateway.NewPGClientFromConfig(pgConfig)
+		if err != nil {
+			return err
+		}
+		log.Printf("[POSTGRES] Connected successfully in %v", time.Since(startTime))
+		client.SetMetricsRegistry(metricsRegistry)
+		log.Println("[POSTGRES] Metrics registry attached to PostgreSQL client")
+
+		log.Println("[POSTGRES] Creating database table if not exists...")
+		if err := client.CreateTable(); err != nil {
+			log.Printf("[POSTGRES] WARNING: Could not create table: %v", err)
+		} else {
+			log.Println("[POSTGRES] Table created/verified successfully")
+		}
+
+		log.Println("[POSTGRES] Installing users change trigger...")
+		if err := client.CreateUsersChangeTrigger(); err != nil {
+			log.Printf("[POSTGRES] WARNING: Could not install users change trigger: %v", err)
+		} else {
+			log.Println("[POSTGRES] Users change trigger installed successfully")
+		}
+
+		deps.setPG(client)
+		deps.startUsers()
+		return nil
+	}
+
+	if err := connectRedis(); err != nil {
+		log.Printf("[REDIS] WARNING: Starting without Redis: %v", err)
+		metricsRegistry.SetGauge("redis_connection_status", 0, map[string]string{})
+		connectInBackground("Redis", connectRedis)
+	}
+	if err := connectPG(); err != nil {
+		log.Printf("[POSTGRES] WARNING: Starting without PostgreSQL: %v", err)
+		metricsRegistry.SetGauge("postgres_connection_status", 0, map[string]string{})
+		connectInBackground("PostgreSQL", connectPG)
 	}
 
 	log.Println("[MONITOR] Starting memory monitoring goroutine...")
@@ -107,13 +233,6 @@ func main() {
 	go func2.KeepConnectionsAlive()
 	log.Println("[MONITOR] Database connection keeper started")
 
-	metricsRegistry.SetGauge("redis_connection_status", 1, map[string]string{})
-	metricsRegistry.SetGauge("postgres_connection_status", 1, map[string]string{})
-
-	log.Println("[INIT] Creating UsersManager...")
-	usersManager = users.NewUsersManager(redisClient, pgClient, metricsRegistry)
-	log.Println("[INIT] UsersManager created successfully")
-
 	log.Println("[HTTP] Registering /api/user endpoint...")
 	http.HandleFunc("/api/user", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
@@ -137,6 +256,8 @@ func main() {
 			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
 				"method": r.Method, "endpoint": "/api/user", "status": "400",
 			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(Response{Success: false, Message: "Invalid request"})
 			return
 		}
@@ -144,13 +265,29 @@ func main() {
 		log.Printf("[USER:%s] Decoded payload: first_name='%s', last_name='%s', age=%d, marital_status=%t", 
 			requestID, req.FirstName, req.LastName, req.Age, req.MaritalStatus)
 		
+		usersManager := deps.users()
+		if usersManager == nil {
+			message := deps.unavailable(true, true)
+			log.Printf("[USER:%s] ERROR: %s", requestID, message)
+			writeUnavailable(w, r, "/api/user", message)
+			return
+		}
 		userID, err := usersManager.CreateUser(req.FirstName, req.LastName, req.Age, req.MaritalStatus)
 		if err != nil {
 			log.Printf("[USER:%s] ERROR: Failed to create user: %v", requestID, err)
+			status := http.StatusInternalServerError
+			message := err.Error()
+			var userErr *users.Error
+			if errors.As(err, &userErr) {
+				status = userErr.Status
+				message = userErr.Message
+			}
 			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
-				"method": r.Method, "endpoint": "/api/user", "status": "500",
+				"method": r.Method, "endpoint": "/api/user", "status": strconv.Itoa(status),
 			})
-			json.NewEncoder(w).Encode(Response{Success: false, Message: err.Error()})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(Response{Success: false, Message: message})
 			return
 		}
 
@@ -176,6 +313,74 @@ func main() {
 	}))
 	log.Println("[HTTP] /api/user endpoint registered")
 
+	log.Println("[HTTP] Registering /api/user/{id} endpoint...")
+	http.HandleFunc("/api/user/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+		const endpoint = "/api/user/{id}"
+		userID := strings.TrimPrefix(r.URL.Path, "/api/user/")
+
+		log.Printf("[USER:%s] Incoming %s request for user '%s' from %s", requestID, r.Method, userID, r.RemoteAddr)
+
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+			log.Printf("[USER:%s] ERROR: Method not allowed: %s", requestID, r.Method)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": endpoint, "status": "405",
+			})
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if userID == "" {
+			writeJSON(w, r, endpoint, http.StatusBadRequest, Response{Success: false, Message: "Missing user id"})
+			return
+		}
+
+		var update users.UserUpdate
+		if r.Method == http.MethodPatch {
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				log.Printf("[USER:%s] ERROR: Failed to decode JSON body: %v", requestID, err)
+				writeJSON(w, r, endpoint, http.StatusBadRequest, Response{Success: false, Message: "Invalid request"})
+				return
+			}
+		}
+
+		usersManager := deps.users()
+		if usersManager == nil {
+			message := deps.unavailable(true, true)
+			log.Printf("[USER:%s] ERROR: %s", requestID, message)
+			writeUnavailable(w, r, endpoint, message)
+			return
+		}
+
+		var (
+			user *users.User
+			err  error
+		)
+		if r.Method == http.MethodPatch {
+			user, err = usersManager.UpdateUser(r.Context(), userID, update)
+		} else {
+			user, err = usersManager.GetUser(r.Context(), userID)
+		}
+		if err != nil {
+			log.Printf("[USER:%s] ERROR: %s of user '%s' failed: %v", requestID, r.Method, userID, err)
+			status := http.StatusInternalServerError
+			message := err.Error()
+			var userErr *users.Error
+			if errors.As(err, &userErr) {
+				status = userErr.Status
+				message = userErr.Message
+			}
+			writeJSON(w, r, endpoint, status, Response{Success: false, Message: message})
+			return
+		}
+
+		log.Printf("[USER:%s] SUCCESS: %s of user '%s' completed", requestID, r.Method, userID)
+		writeJSON(w, r, endpoint, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"user":    user,
+		})
+	}))
+	log.Println("[HTTP] /api/user/{id} endpoint registered")
+
 	log.Println("[HTTP] Registering /api/users endpoint...")
 	http.HandleFunc("/api/users", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
@@ -193,6 +398,13 @@ func main() {
 			return
 		}
 
+		usersManager := deps.users()
+		if usersManager == nil {
+			message := deps.unavailable(true, true)
+			log.Printf("[USERS:%s] ERROR: %s", requestID, message)
+			writeUnavailable(w, r, "/api/users", message)
+			return
+		}
 		log.Printf("[USERS:%s] Fetching all users...", requestID)
 		users, err := usersManager.GetUsers()
 		if err != nil {
@@ -226,6 +438,162 @@ func main() {
 	}))
 	log.Println("[HTTP] /api/users endpoint registered")
 
+	log.Println("[HTTP] Registering /api/users/import endpoint...")
+	http.HandleFunc("/api/users/import", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+		requestStart := time.Now()
+
+		log.Printf("[IMPORT:%s] Incoming %s request to /api/users/import from %s", requestID, r.Method, r.RemoteAddr)
+		log.Printf("[IMPORT:%s] Headers: %v", requestID, r.Header)
+
+		if r.Method != http.MethodPost {
+			log.Printf("[IMPORT:%s] ERROR: Method not allowed: %s", requestID, r.Method)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": "/api/users/import", "status": "405",
+			})
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		format := transferFormat(r)
+		if !users.ValidFormat(format) {
+			log.Printf("[IMPORT:%s] ERROR: Unsupported format %q (Content-Type: %q)", requestID, format, r.Header.Get("Content-Type"))
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": "/api/users/import", "status": "400",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Success: false,
+				Message: fmt.Sprintf("Unsupported format (use ?format=%s|%s, or Content-Type text/csv or application/x-ndjson)", users.FormatCSV, users.FormatNDJSON),
+			})
+			return
+		}
+
+		usersManager := deps.users()
+		if usersManager == nil {
+			message := deps.unavailable(true, true)
+			log.Printf("[IMPORT:%s] ERROR: %s", requestID, message)
+			writeUnavailable(w, r, "/api/users/import", message)
+			return
+		}
+		log.Printf("[IMPORT:%s] Importing users from %s body...", requestID, format)
+		body := http.MaxBytesReader(w, r.Body, maxImportBodySize)
+		result, err := usersManager.ImportUsers(r.Context(), format, body)
+		if err != nil {
+			log.Printf("[IMPORT:%s] ERROR: Failed to import users: %v", requestID, err)
+			status := http.StatusInternalServerError
+			message := err.Error()
+			var userErr *users.Error
+			if errors.As(err, &userErr) {
+				status = userErr.Status
+				message = userErr.Message
+			}
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": "/api/users/import", "status": strconv.Itoa(status),
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(Response{Success: false, Message: message})
+			return
+		}
+
+		metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+			"method": r.Method, "endpoint": "/api/users/import", "status": "200",
+		})
+		metricsRegistry.SetGauge("http_request_duration_seconds", time.Since(requestStart).Seconds(), map[string]string{
+			"endpoint": "/api/users/import",
+		})
+
+		log.Printf("[IMPORT:%s] SUCCESS: Imported %d users (%d cached)", requestID, result.Imported, result.Cached)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"message":  fmt.Sprintf("Imported %d users", result.Imported),
+			"imported": result.Imported,
+			"cached":   result.Cached,
+		})
+		log.Printf("[IMPORT:%s] Request completed successfully", requestID)
+	}))
+	log.Println("[HTTP] /api/users/import endpoint registered")
+
+	log.Println("[HTTP] Registering /api/users/export endpoint...")
+	http.HandleFunc("/api/users/export", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+		requestStart := time.Now()
+
+		log.Printf("[EXPORT:%s] Incoming %s request to /api/users/export from %s", requestID, r.Method, r.RemoteAddr)
+
+		if r.Method != http.MethodGet {
+			log.Printf("[EXPORT:%s] ERROR: Method not allowed: %s", requestID, r.Method)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": "/api/users/export", "status": "405",
+			})
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = users.FormatCSV
+		}
+		if !users.ValidFormat(format) {
+			log.Printf("[EXPORT:%s] ERROR: Unsupported format %q", requestID, format)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": "/api/users/export", "status": "400",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Success: false,
+				Message: fmt.Sprintf("Invalid format %q (expected %q or %q)", format, users.FormatCSV, users.FormatNDJSON),
+			})
+			return
+		}
+
+		usersManager := deps.users()
+		if usersManager == nil {
+			message := deps.unavailable(true, true)
+			log.Printf("[EXPORT:%s] ERROR: %s", requestID, message)
+			writeUnavailable(w, r, "/api/users/export", message)
+			return
+		}
+		// Headers go out with the first row. If the export fails before
+		// that, a JSON error can still be sent instead.
+		contentType := "text/csv"
+		if format == users.FormatNDJSON {
+			contentType = "application/x-ndjson"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"users.%s\"", format))
+		out := &trackingWriter{ResponseWriter: w}
+
+		log.Printf("[EXPORT:%s] Streaming users as %s...", requestID, format)
+		n, err := usersManager.ExportUsers(r.Context(), format, out)
+		if err != nil {
+			log.Printf("[EXPORT:%s] ERROR: Export failed after %d users: %v", requestID, n, err)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": "/api/users/export", "status": "500",
+			})
+			if !out.written {
+				w.Header().Del("Content-Disposition")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(Response{Success: false, Message: err.Error()})
+			}
+			return
+		}
+
+		metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+			"method": r.Method, "endpoint": "/api/users/export", "status": "200",
+		})
+		metricsRegistry.SetGauge("http_request_duration_seconds", time.Since(requestStart).Seconds(), map[string]string{
+			"endpoint": "/api/users/export",
+		})
+		log.Printf("[EXPORT:%s] SUCCESS: Exported %d users in %v", requestID, n, time.Since(requestStart))
+	}))
+	log.Println("[HTTP] /api/users/export endpoint registered")
+
 	log.Println("[HTTP] Registering /api/set endpoint...")
 	http.HandleFunc("/api/set", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
@@ -249,15 +617,61 @@ func main() {
 			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
 				"method": r.Method, "endpoint": "/api/set", "status": "400",
 			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(Response{Success: false, Message: "Invalid request"})
 			return
 		}
+
+		opts := redis_gateway.SetOptions{TTL: time.Duration(req.TTLSeconds) * time.Second}
+		switch req.Mode {
+		case "":
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		}
+		if req.TTLSeconds < 0 || (req.Mode != "" && !opts.NX && !opts.XX) {
+			log.Printf("[REQUEST:%s] ERROR: Invalid options: ttl_seconds=%d, mode=%q", requestID, req.TTLSeconds, req.Mode)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": "/api/set", "status": "400",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Success: false,
+				Message: `Invalid options (ttl_seconds must not be negative, mode must be "nx" or "xx")`,
+			})
+			return
+		}
 		
-		log.Printf("[REQUEST:%s] Decoded payload: key='%s', value='%s'", requestID, req.Key, req.Value)
+		redisClient := deps.redisClient()
+		if redisClient == nil {
+			log.Printf("[REQUEST:%s] ERROR: Redis is unavailable", requestID)
+			writeUnavailable(w, r, "/api/set", "Redis is unavailable")
+			return
+		}
+		log.Printf("[REQUEST:%s] Decoded payload: key='%s', value='%s', ttl_seconds=%d, mode=%q", requestID, req.Key, req.Value, req.TTLSeconds, req.Mode)
 		log.Printf("[REQUEST:%s] Sending SET command to Redis...", requestID)
 		
 		setStart := time.Now()
-		if err := redisClient.Set(req.Key, req.Value); err != nil {
+		if _, err := redisClient.SetWithOptions(r.Context(), req.Key, req.Value, opts); errors.Is(err, redis_gateway.ErrNotSet) {
+			status, message := http.StatusConflict, "Key already exists"
+			if opts.XX {
+				status, message = http.StatusNotFound, "Key does not exist"
+			}
+			log.Printf("[REQUEST:%s] Key '%s' not set: %s", requestID, req.Key, message)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": "/api/set", "status": strconv.Itoa(status),
+			})
+			metricsRegistry.IncrementCounter("redis_operations_total", map[string]string{
+				"operation": "set", "status": "not_set",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(Response{Success: false, Message: message})
+			return
+		} else if err != nil {
 			log.Printf("[REQUEST:%s] ERROR: Redis SET failed: %v", requestID, err)
 			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
 				"method": r.Method, "endpoint": "/api/set", "status": "500",
@@ -291,6 +705,225 @@ func main() {
 	}))
 	log.Println("[HTTP] /api/set endpoint registered")
 
+	log.Println("[HTTP] Registering /api/kv endpoints...")
+	// kvBatch serves POST /api/kv/batch. It is dispatched from the /api/kv/
+	// handler rather than registered as its own route, so that GET, HEAD and
+	// DELETE still reach a key named "batch".
+	kvBatch := func(w http.ResponseWriter, r *http.Request, requestID, endpoint string) {
+		var req KVBatchRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxKVBatchBodySize)).Decode(&req); err != nil {
+			log.Printf("[KV:%s] ERROR: Failed to decode JSON body: %v", requestID, err)
+			writeJSON(w, r, endpoint, http.StatusBadRequest, Response{Success: false, Message: "Invalid request"})
+			return
+		}
+		if len(req.Set) == 0 && len(req.Get) == 0 {
+			writeJSON(w, r, endpoint, http.StatusBadRequest, Response{Success: false, Message: `Nothing to do (expected "set" and/or "get")`})
+			return
+		}
+		if len(req.Set) > kvBatchMaxKeys || len(req.Get) > kvBatchMaxKeys {
+			writeJSON(w, r, endpoint, http.StatusBadRequest, Response{
+				Success: false,
+				Message: fmt.Sprintf("Too many keys (at most %d to set and %d to get)", kvBatchMaxKeys, kvBatchMaxKeys),
+			})
+			return
+		}
+
+		redisClient := deps.redisClient()
+		if redisClient == nil {
+			log.Printf("[KV:%s] ERROR: Redis is unavailable", requestID)
+			writeUnavailable(w, r, endpoint, "Redis is unavailable")
+			return
+		}
+
+		// Keys are set before they are read, so a batch can read back what
+		// it wrote.
+		if err := redisClient.MSet(r.Context(), req.Set); err != nil {
+			log.Printf("[KV:%s] ERROR: Redis MSET failed: %v", requestID, err)
+			writeJSON(w, r, endpoint, http.StatusInternalServerError, Response{Success: false, Message: err.Error()})
+			return
+		}
+		values, err := redisClient.MGet(r.Context(), req.Get...)
+		if err != nil {
+			log.Printf("[KV:%s] ERROR: Redis MGET failed: %v", requestID, err)
+			writeJSON(w, r, endpoint, http.StatusInternalServerError, Response{Success: false, Message: err.Error()})
+			return
+		}
+
+		// Missing keys map to null.
+		got := make(map[string]interface{}, len(req.Get))
+		for i, key := range req.Get {
+			got[key] = values[i]
+		}
+		log.Printf("[KV:%s] SUCCESS: Set %d keys, read %d keys", requestID, len(req.Set), len(req.Get))
+		writeJSON(w, r, endpoint, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"set":     len(req.Set),
+			"values":  got,
+		})
+	}
+
+	http.HandleFunc("/api/kv/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+		const endpoint = "/api/kv/{key}"
+		key := strings.TrimPrefix(r.URL.Path, "/api/kv/")
+
+		if r.Method == http.MethodPost && key == "batch" {
+			log.Printf("[KV:%s] Incoming %s request to /api/kv/batch from %s", requestID, r.Method, r.RemoteAddr)
+			kvBatch(w, r, requestID, "/api/kv/batch")
+			return
+		}
+
+		log.Printf("[KV:%s] Incoming %s request for key '%s' from %s", requestID, r.Method, key, r.RemoteAddr)
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodDelete {
+			log.Printf("[KV:%s] ERROR: Method not allowed: %s", requestID, r.Method)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": endpoint, "status": "405",
+			})
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if key == "" {
+			writeJSON(w, r, endpoint, http.StatusBadRequest, Response{Success: false, Message: "Missing key"})
+			return
+		}
+
+		redisClient := deps.redisClient()
+		if redisClient == nil {
+			log.Printf("[KV:%s] ERROR: Redis is unavailable", requestID)
+			writeUnavailable(w, r, endpoint, "Redis is unavailable")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			value, err := redisClient.GetContext(r.Context(), key)
+			if errors.Is(err, redis_gateway.ErrNil) {
+				log.Printf("[KV:%s] Key '%s' not found", requestID, key)
+				writeJSON(w, r, endpoint, http.StatusNotFound, Response{Success: false, Message: "Key not found"})
+				return
+			}
+			var redisErr *redis_gateway.RedisError
+			if errors.As(err, &redisErr) && redisErr.Code == "WRONGTYPE" {
+				// Hashes such as user:<id> live in the same keyspace, but
+				// only string values can be read here.
+				log.Printf("[KV:%s] Key '%s' does not hold a string value", requestID, key)
+				writeJSON(w, r, endpoint, http.StatusConflict, Response{Success: false, Message: "Key does not hold a string value"})
+				return
+			}
+			if err != nil {
+				log.Printf("[KV:%s] ERROR: Redis GET failed: %v", requestID, err)
+				writeJSON(w, r, endpoint, http.StatusInternalServerError, Response{Success: false, Message: err.Error()})
+				return
+			}
+			log.Printf("[KV:%s] SUCCESS: Key '%s' read (%d bytes)", requestID, key, len(value))
+			writeJSON(w, r, endpoint, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"key":     key,
+				"value":   value,
+			})
+
+		case http.MethodHead:
+			n, err := redisClient.Exists(r.Context(), key)
+			if err != nil {
+				log.Printf("[KV:%s] ERROR: Redis EXISTS failed: %v", requestID, err)
+				writeJSON(w, r, endpoint, http.StatusInternalServerError, nil)
+				return
+			}
+			status := http.StatusOK
+			if n == 0 {
+				status = http.StatusNotFound
+			}
+			log.Printf("[KV:%s] Key '%s' exists: %v", requestID, key, n > 0)
+			writeJSON(w, r, endpoint, status, nil)
+
+		case http.MethodDelete:
+			n, err := redisClient.Del(r.Context(), key)
+			if err != nil {
+				log.Printf("[KV:%s] ERROR: Redis DEL failed: %v", requestID, err)
+				writeJSON(w, r, endpoint, http.StatusInternalServerError, Response{Success: false, Message: err.Error()})
+				return
+			}
+			if n == 0 {
+				log.Printf("[KV:%s] Key '%s' not found", requestID, key)
+				writeJSON(w, r, endpoint, http.StatusNotFound, Response{Success: false, Message: "Key not found"})
+				return
+			}
+			log.Printf("[KV:%s] SUCCESS: Key '%s' deleted", requestID, key)
+			writeJSON(w, r, endpoint, http.StatusOK, Response{Success: true, Message: "Key deleted"})
+		}
+	}))
+
+	http.HandleFunc("/api/kv", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+		const endpoint = "/api/kv"
+
+		log.Printf("[KV:%s] Incoming %s request to /api/kv from %s", requestID, r.Method, r.RemoteAddr)
+
+		if r.Method != http.MethodGet {
+			log.Printf("[KV:%s] ERROR: Method not allowed: %s", requestID, r.Method)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": endpoint, "status": "405",
+			})
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		prefix := query.Get("prefix")
+		var cursor uint64
+		if c := query.Get("cursor"); c != "" {
+			var err error
+			if cursor, err = strconv.ParseUint(c, 10, 64); err != nil {
+				writeJSON(w, r, endpoint, http.StatusBadRequest, Response{Success: false, Message: fmt.Sprintf("Invalid cursor %q", c)})
+				return
+			}
+		}
+		count := int64(kvListDefaultCount)
+		if c := query.Get("count"); c != "" {
+			n, err := strconv.ParseInt(c, 10, 64)
+			if err != nil || n <= 0 || n > kvListMaxCount {
+				writeJSON(w, r, endpoint, http.StatusBadRequest, Response{
+					Success: false,
+					Message: fmt.Sprintf("Invalid count %q (expected 1-%d)", c, kvListMaxCount),
+				})
+				return
+			}
+			count = n
+		}
+
+		redisClient := deps.redisClient()
+		if redisClient == nil {
+			log.Printf("[KV:%s] ERROR: Redis is unavailable", requestID)
+			writeUnavailable(w, r, endpoint, "Redis is unavailable")
+			return
+		}
+
+		log.Printf("[KV:%s] Listing keys with prefix '%s' from cursor %d (count %d)", requestID, prefix, cursor, count)
+		keys, next, err := redisClient.Scan(r.Context(), cursor, redis_gateway.ScanOptions{
+			Match: escapeGlob(prefix) + "*",
+			Count: count,
+		})
+		if err != nil {
+			log.Printf("[KV:%s] ERROR: Redis SCAN failed: %v", requestID, err)
+			writeJSON(w, r, endpoint, http.StatusInternalServerError, Response{Success: false, Message: err.Error()})
+			return
+		}
+
+		// The cursor is a string because it can exceed what JSON numbers
+		// hold exactly. "0" means the listing is complete; a page may be
+		// empty before that.
+		log.Printf("[KV:%s] SUCCESS: Listed %d keys, next cursor %d", requestID, len(keys), next)
+		writeJSON(w, r, endpoint, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"keys":    keys,
+			"count":   len(keys),
+			"cursor":  strconv.FormatUint(next, 10),
+		})
+	}))
+
+	log.Println("[HTTP] /api/kv endpoints registered")
+
 	log.Println("[HTTP] Registering /metrics endpoint...")
 	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
@@ -306,6 +939,21 @@ func main() {
 	})
 	log.Println("[HTTP] /metrics endpoint registered")
 
+	log.Println("[HTTP] Registering /health endpoint...")
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		status := deps.status()
+		code := http.StatusOK
+		status["status"] = "ok"
+		if status["redis"] != "up" || status["postgres"] != "up" {
+			code = http.StatusServiceUnavailable
+			status["status"] = "degraded"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	})
+	log.Println("[HTTP] /health endpoint registered")
+
 	log.Println("[HTTP] Registering /api/func1 endpoint...")
 	http.HandleFunc("/api/func1", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		requestID := fmt.Sprintf("%d", time.Now().UnixNano())
@@ -320,14 +968,38 @@ func main() {
 			return
 		}
 
-		log.Printf("[FUNC1:%s] Starting func1 ..", requestID)
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = func1.ModeSequential
+		}
+		if !func1.ValidMode(mode) {
+			log.Printf("[FUNC1:%s] ERROR: Invalid mode: %s", requestID, mode)
+			metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+				"method": r.Method, "endpoint": "/api/func1", "status": "400",
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Success: false,
+				Message: fmt.Sprintf("Invalid mode %q (expected %q or %q)", mode, func1.ModeSequential, func1.ModePipelined),
+			})
+			return
+		}
+
+		redisClient := deps.redisClient()
+		if redisClient == nil {
+			log.Printf("[FUNC1:%s] ERROR: Redis is unavailable", requestID)
+			writeUnavailable(w, r, "/api/func1", "Redis is unavailable")
+			return
+		}
+		log.Printf("[FUNC1:%s] Starting func1 in %s mode..", requestID, mode)
 		metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
 			"method": r.Method, "endpoint": "/api/func1", "status": "202",
 		})
 		
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(Response{Success: true, Message: "Func1 started"})
+		json.NewEncoder(w).Encode(Response{Success: true, Message: fmt.Sprintf("Func1 started (%s mode)", mode)})
 		log.Printf("[FUNC1:%s] Response sent, starting func1 in background", requestID)
 
 		go func() {
@@ -336,7 +1008,7 @@ func main() {
 			
 			metricsRegistry.IncrementCounter("func1_runs_total", map[string]string{"status": "started"})
 			
-			stats, err := func1.Func1Run(redisClient)
+			stats, err := func1.Func1Run(redisClient, mode)
 			funcDuration := time.Since(funcStart)
 			
 			if err != nil {
@@ -348,8 +1020,8 @@ func main() {
 				metricsRegistry.IncrementCounter("func1_runs_total", map[string]string{"status": "success"})
 				metricsRegistry.SetGauge("func1_successful_keys", float64(stats.SuccessfulKeys), map[string]string{})
 				metricsRegistry.SetGauge("func1_failed_keys", float64(stats.FailedKeys), map[string]string{})
-				metricsRegistry.SetGauge("func1_duration_seconds", stats.DurationSeconds, map[string]string{})
-				metricsRegistry.SetGauge("func1_throughput_keys_per_sec", stats.KeysPerSecond, map[string]string{})
+				metricsRegistry.SetGauge("func1_duration_seconds", stats.DurationSeconds, map[string]string{"mode": stats.Mode})
+				metricsRegistry.SetGauge("func1_throughput_keys_per_sec", stats.KeysPerSecond, map[string]string{"mode": stats.Mode})
 				metricsRegistry.SetGauge("func1_total_bytes", float64(stats.TotalBytes), map[string]string{})
 				
 				log.Printf("[FUNC1:%s] Storing %d keys and %d values in application memory...", requestID, len(stats.Keys), len(stats.Values))
@@ -431,11 +1103,18 @@ func main() {
 	log.Println("Listening on :8080")
 	log.Println("Endpoints:")
 	log.Println("  - POST /api/user")
+	log.Println("  - GET|PATCH /api/user/{id}")
 	log.Println("  - GET  /api/users")
+	log.Println("  - POST /api/users/import?format=csv|ndjson")
+	log.Println("  - GET  /api/users/export?format=csv|ndjson")
 	log.Println("  - POST /api/set")
-	log.Println("  - GET  /api/func1")
+	log.Println("  - GET|HEAD|DELETE /api/kv/{key}")
+	log.Println("  - GET  /api/kv?prefix=&cursor=&count=")
+	log.Println("  - POST /api/kv/batch")
+	log.Println("  - GET  /api/func1?mode=sequential|pipelined")
 	log.Println("  - GET  /api/func2")
 	log.Println("  - GET  /metrics")
+	log.Println("  - GET  /health")
 	log.Println("========================================")
 	
 	if err := http.ListenAndServe(":8080", nil); err != nil {
@@ -443,10 +1122,76 @@ func main() {
 	}
 }
 
+// transferFormat picks the import format from ?format=, falling back to the
+// Content-Type of the body.
+func transferFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return users.FormatCSV
+	case "application/x-ndjson", "application/jsonl", "application/json":
+		return users.FormatNDJSON
+	}
+	return ""
+}
+
+// writeUnavailable answers 503 for a request whose backend is not connected
+// yet. message says which one is missing.
+func writeUnavailable(w http.ResponseWriter, r *http.Request, endpoint, message string) {
+	metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+		"method": r.Method, "endpoint": endpoint, "status": "503",
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "5")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(Response{Success: false, Message: message})
+}
+
+// writeJSON sends v as the response body with status and counts the
+// request. HEAD responses carry the status only.
+func writeJSON(w http.ResponseWriter, r *http.Request, endpoint string, status int, v interface{}) {
+	metricsRegistry.IncrementCounter("api_requests_total", map[string]string{
+		"method": r.Method, "endpoint": endpoint, "status": strconv.Itoa(status),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead && v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// escapeGlob quotes the SCAN MATCH metacharacters in s.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// trackingWriter records whether anything has been written, i.e. whether
+// the status line is already on the wire.
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *trackingWriter) Write(p []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(p)
+}
+
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		
 		if r.Method == "OPTIONS" {
@@ -465,6 +1210,45 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("[INIT] WARNING: Invalid integer for %s=%q, using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("[INIT] WARNING: Invalid boolean for %s=%q, using default %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("[INIT] WARNING: Invalid duration for %s=%q, using default %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
 func keepArraysAlive() {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()